@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Annotation - пометка записи при разборе инцидента: теги (root-cause, noise,
+// known-issue или свои) и комментарий. Пометка без тегов и комментария - закладка.
+type Annotation struct {
+	ID        int
+	EntryID   int
+	Tags      []string
+	Comment   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+var errAnnotationNotFound = errors.New("пометка не найдена")
+
+// AnnotationStore - потокобезопасные пометки записей одной сессии; каждое
+// изменение сохраняется через save до того, как станет видно читателям
+type AnnotationStore struct {
+	mu     sync.RWMutex
+	items  []Annotation // по возрастанию ID
+	nextID int
+	save   func([]Annotation) error
+}
+
+func newAnnotationStore(items []Annotation, save func([]Annotation) error) *AnnotationStore {
+	store := &AnnotationStore{save: save}
+	store.set(items)
+	return store
+}
+
+// set - замена всех пометок без сохранения; вызывается под блокировкой или до публикации
+func (s *AnnotationStore) set(items []Annotation) {
+	s.items = append([]Annotation(nil), items...)
+	sort.Slice(s.items, func(i, j int) bool { return s.items[i].ID < s.items[j].ID })
+	s.nextID = 1
+	if len(s.items) > 0 {
+		s.nextID = s.items[len(s.items)-1].ID + 1
+	}
+}
+
+// commit - сохранение нового списка и замена им текущего
+func (s *AnnotationStore) commit(items []Annotation) error {
+	if err := s.save(items); err != nil {
+		return err
+	}
+	s.items = items
+	return nil
+}
+
+// List - копии пометок по возрастанию ID
+func (s *AnnotationStore) List() []Annotation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Annotation(nil), s.items...)
+}
+
+// Add - новая пометка записи entryID
+func (s *AnnotationStore) Add(entryID int, tags []string, comment string) (Annotation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	annotation := Annotation{
+		ID:        s.nextID,
+		EntryID:   entryID,
+		Tags:      normalizeTags(tags),
+		Comment:   comment,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	items := append(s.items[:len(s.items):len(s.items)], annotation)
+	if err := s.commit(items); err != nil {
+		return Annotation{}, err
+	}
+	s.nextID++
+	return annotation, nil
+}
+
+// Update - изменение пометки id
+func (s *AnnotationStore) Update(id int, apply func(*Annotation)) (Annotation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(id)
+	if i < 0 {
+		return Annotation{}, errAnnotationNotFound
+	}
+	items := append([]Annotation(nil), s.items...)
+	apply(&items[i])
+	items[i].Tags = normalizeTags(items[i].Tags)
+	items[i].UpdatedAt = time.Now()
+	if err := s.commit(items); err != nil {
+		return Annotation{}, err
+	}
+	return items[i], nil
+}
+
+// Delete - удаление пометки id
+func (s *AnnotationStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(id)
+	if i < 0 {
+		return errAnnotationNotFound
+	}
+	items := append(append([]Annotation(nil), s.items[:i]...), s.items[i+1:]...)
+	return s.commit(items)
+}
+
+// Replace - замена всех пометок, например из пакета сессии
+func (s *AnnotationStore) Replace(items []Annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.save(items); err != nil {
+		return err
+	}
+	s.set(items)
+	return nil
+}
+
+// removeBefore - удаление пометок записей с ID меньше firstID (вытесненных);
+// firstID < 0 - удаление всех пометок
+func (s *AnnotationStore) removeBefore(firstID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []Annotation
+	for _, annotation := range s.items {
+		if firstID >= 0 && annotation.EntryID >= firstID {
+			items = append(items, annotation)
+		}
+	}
+	if len(items) == len(s.items) {
+		return nil
+	}
+	return s.commit(items)
+}
+
+func (s *AnnotationStore) find(id int) int {
+	i := sort.Search(len(s.items), func(i int) bool { return s.items[i].ID >= id })
+	if i < len(s.items) && s.items[i].ID == id {
+		return i
+	}
+	return -1
+}
+
+// annotationIndex - теги пометок по ID записей; запись есть в индексе, если у нее есть пометка
+type annotationIndex map[int][]string
+
+// Index - индекс для фильтров annotated и tag
+func (s *AnnotationStore) Index() annotationIndex {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index := make(annotationIndex, len(s.items))
+	for _, annotation := range s.items {
+		index[annotation.EntryID] = append(index[annotation.EntryID], annotation.Tags...)
+	}
+	return index
+}
+
+// ForEntries - пометки записей logs по ID записей, для ответа со страницей логов
+func (s *AnnotationStore) ForEntries(logs []TerraformLog) map[int][]Annotation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.items) == 0 {
+		return nil
+	}
+	ids := make(map[int]bool, len(logs))
+	for i := range logs {
+		ids[logs[i].ID] = true
+	}
+	result := make(map[int][]Annotation)
+	for _, annotation := range s.items {
+		if ids[annotation.EntryID] {
+			result[annotation.EntryID] = append(result[annotation.EntryID], annotation)
+		}
+	}
+	return result
+}
+
+// normalizeTags - теги в нижнем регистре без пробелов по краям и повторов
+func normalizeTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !containsString(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// annotatedStore - хранилище сессии, удаляющее пометки вместе с записями:
+// после очистки и замены ID записей начинаются заново, вытесненных записей нет.
+// Спаны трассировки относятся к прежнему содержимому и удаляются вместе с ним.
+type annotatedStore struct {
+	LogStore
+	annotations *AnnotationStore
+	traces      *TraceStore
+}
+
+func (s *annotatedStore) Replace(result ParseResult) error {
+	if err := s.LogStore.Replace(result); err != nil {
+		return err
+	}
+	return s.reset()
+}
+
+func (s *annotatedStore) Restore(result ParseResult, firstID int) error {
+	if err := s.LogStore.Restore(result, firstID); err != nil {
+		return err
+	}
+	return s.reset()
+}
+
+func (s *annotatedStore) Clear() error {
+	if err := s.LogStore.Clear(); err != nil {
+		return err
+	}
+	return s.reset()
+}
+
+// reset - удаление пометок и спанов после замены содержимого
+func (s *annotatedStore) reset() error {
+	if err := s.annotations.removeBefore(-1); err != nil {
+		return err
+	}
+	return s.traces.Replace(nil)
+}
+
+func (s *annotatedStore) Trim(maxEntries int, maxBytes int64) (int, error) {
+	n, err := s.LogStore.Trim(maxEntries, maxBytes)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	firstID := -1
+	if snapshot := s.LogStore.Snapshot(); snapshot != nil && len(snapshot.Logs) > 0 {
+		firstID = snapshot.Logs[0].ID
+	}
+	return n, s.annotations.removeBefore(firstID)
+}
+
+// Обработчик API для пометок записей:
+// GET /api/annotations?entry=&tag= - список, POST - новая пометка
+// ({"entry_id": 42, "tags": ["root-cause"], "comment": "..."})
+func handleAPIAnnotations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		query := r.URL.Query()
+		tags := normalizeTags(splitFilterList(query["tag"]))
+		entry := -1
+		if value := query.Get("entry"); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, `{"error": "Неверный идентификатор записи"}`, http.StatusBadRequest)
+				return
+			}
+			entry = id
+		}
+
+		list := []Annotation{}
+		for _, annotation := range session.Annotations.List() {
+			if entry >= 0 && annotation.EntryID != entry {
+				continue
+			}
+			if len(tags) > 0 && !sharesTag(annotation.Tags, tags) {
+				continue
+			}
+			list = append(list, annotation)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "success",
+			"annotations": list,
+			"count":       len(list),
+		})
+
+	case "POST":
+		var request struct {
+			EntryID *int     `json:"entry_id"`
+			Tags    []string `json:"tags"`
+			Comment string   `json:"comment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "Неверное тело запроса: "+err.Error()), http.StatusBadRequest)
+			return
+		}
+		if request.EntryID == nil {
+			http.Error(w, `{"error": "Не указан entry_id"}`, http.StatusBadRequest)
+			return
+		}
+		snapshot := session.Store.Snapshot()
+		if snapshot == nil || findLogByID(snapshot.Logs, *request.EntryID) == nil {
+			http.Error(w, `{"error": "Запись не найдена"}`, http.StatusNotFound)
+			return
+		}
+
+		annotation, err := session.Annotations.Add(*request.EntryID, request.Tags, request.Comment)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     "success",
+			"annotation": annotation,
+		})
+
+	default:
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// Обработчик API для одной пометки: PATCH - теги и комментарий
+// ({"tags": [...], "comment": "..."}), DELETE - удаление
+func handleAPIAnnotation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("annotation"))
+	if err != nil {
+		http.Error(w, `{"error": "Неверный идентификатор пометки"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "PATCH":
+		var update struct {
+			Tags    *[]string `json:"tags"`
+			Comment *string   `json:"comment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "Неверное тело запроса: "+err.Error()), http.StatusBadRequest)
+			return
+		}
+		annotation, err := session.Annotations.Update(id, func(annotation *Annotation) {
+			if update.Tags != nil {
+				annotation.Tags = *update.Tags
+			}
+			if update.Comment != nil {
+				annotation.Comment = *update.Comment
+			}
+		})
+		if err != nil {
+			writeAnnotationError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     "success",
+			"annotation": annotation,
+		})
+
+	case "DELETE":
+		if err := session.Annotations.Delete(id); err != nil {
+			writeAnnotationError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Пометка удалена",
+		})
+
+	default:
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// writeAnnotationError - 404 для отсутствующей пометки, иначе как writeStoreError
+func writeAnnotationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAnnotationNotFound) {
+		http.Error(w, `{"error": "Пометка не найдена"}`, http.StatusNotFound)
+		return
+	}
+	writeStoreError(w, err)
+}
+
+// sharesTag - есть ли у tags хотя бы один из wanted
+func sharesTag(tags, wanted []string) bool {
+	for _, tag := range wanted {
+		if containsString(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestAnnotationsFilterAndFollowEntries(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewDiskBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := NewSessionRegistry(backend, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := registry.Create("incident", "api", nil)
+	session.Store.Append(parseSample(t, 5))
+
+	session.Annotations.Add(1, []string{"noise"}, "")
+	cause, _ := session.Annotations.Add(3, []string{"root-cause"}, "")
+	if _, err := session.Annotations.Update(cause.ID, func(a *Annotation) { a.Comment = "таймаут" }); err != nil {
+		t.Fatal(err)
+	}
+
+	match := func(values url.Values) []int {
+		filter, err := parseLogFilter(values, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter.useAnnotations(session.Annotations)
+		var ids []int
+		for _, log := range filterLogs(session.Store.Snapshot().Logs, filter) {
+			ids = append(ids, log.ID)
+		}
+		return ids
+	}
+	if ids := match(url.Values{"annotated": {"true"}}); fmt.Sprint(ids) != "[1 3]" {
+		t.Errorf("annotated=true: %v", ids)
+	}
+	if ids := match(url.Values{"annotated": {"false"}}); fmt.Sprint(ids) != "[0 2 4]" {
+		t.Errorf("annotated=false: %v", ids)
+	}
+	if ids := match(url.Values{"tag": {"Root-Cause"}}); fmt.Sprint(ids) != "[3]" {
+		t.Errorf("tag=root-cause: %v", ids)
+	}
+
+	// Пометки сохраняются вместе с сессией
+	if err := registry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewSessionRegistry(backend, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := reloaded.Get(session.ID)
+	if list := restored.Annotations.List(); len(list) != 2 || list[1].Comment != "таймаут" {
+		t.Fatalf("пометки не загружены: %+v", list)
+	}
+
+	// Пометки вытесненных записей удаляются, после очистки - все
+	restored.Store.Trim(3, 0)
+	if list := restored.Annotations.List(); len(list) != 1 || list[0].EntryID != 3 {
+		t.Errorf("после вытеснения: %+v", list)
+	}
+	restored.Store.Clear()
+	if list := restored.Annotations.List(); len(list) != 0 {
+		t.Errorf("после очистки: %+v", list)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Пакет сессии - один файл (tar.gz), который переносит сессию на другой
+// экземпляр без повторной загрузки исходного лога:
+//
+//	manifest.json - формат, версия, описание сессии и контрольные суммы файлов
+//	raw.jsonl     - исходные строки записей, по одной на строку
+//	entries.json  - разобранные поля записей (уровень, модуль, тип, tf_req_id...),
+//	                чтобы не разбирать строки заново
+//	errors.json   - ошибки разбора
+//	stats.json    - статистика сессии
+//	annotations.json - пометки записей (с версии 2)
+//	traces.json   - спаны трассировки OTLP (с версии 3)
+//	index.json    - производные данные записей: фазы и идентификаторы
+//	                корреляции (с версии 4, см. entryIndex)
+//
+// Манифест идет первым: версия проверяется до чтения данных.
+const (
+	bundleFormat  = "tflog-session-bundle"
+	bundleVersion = 4
+)
+
+// bundleManifest - описание содержимого пакета
+type bundleManifest struct {
+	Format     string
+	Version    int
+	ExportedAt time.Time
+	Session    Session
+	FirstID    int // ID первой записи: записи до него были вытеснены
+	Entries    int
+	Files      []bundleFile
+}
+
+// bundleFile - файл пакета с контрольной суммой
+type bundleFile struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// sessionBundle - содержимое пакета после проверки
+type sessionBundle struct {
+	Manifest    bundleManifest
+	Result      ParseResult // ID записей отсчитываются от Manifest.FirstID, см. LogStore.Restore
+	Annotations []Annotation
+	Traces      []TraceSpan
+}
+
+// writeBundle - пакет с описанием, снимком данных, производными данными
+// записей, пометками и спанами сессии
+func writeBundle(w io.Writer, session Session, snapshot *ParseResult, annotations []Annotation, spans []TraceSpan) error {
+	if snapshot == nil {
+		snapshot = &ParseResult{Stats: newParseStats()}
+	}
+
+	firstID := 0
+	if len(snapshot.Logs) > 0 {
+		firstID = snapshot.Logs[0].ID
+	}
+	var raw bytes.Buffer
+	entries := make([]TerraformLog, len(snapshot.Logs))
+	for i := range snapshot.Logs {
+		raw.WriteString(rawJSON(&snapshot.Logs[i]))
+		raw.WriteByte('\n')
+		entries[i] = snapshot.Logs[i]
+		entries[i].ID -= firstID
+	}
+	stored := make([]storedError, len(snapshot.Errors))
+	for i, parseErr := range snapshot.Errors {
+		stored[i] = storedError{LineNumber: parseErr.LineNumber, Line: parseErr.Line}
+		if parseErr.Error != nil {
+			stored[i].Message = parseErr.Error.Error()
+		}
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"raw.jsonl", raw.Bytes()},
+		{"entries.json", entries},
+		{"errors.json", stored},
+		{"stats.json", snapshot.Stats},
+		{"annotations.json", annotations},
+		{"traces.json", spans},
+		{"index.json", newEntryIndex(snapshot.Logs)},
+	}
+	manifest := bundleManifest{
+		Format:     bundleFormat,
+		Version:    bundleVersion,
+		ExportedAt: time.Now(),
+		Session:    session,
+		FirstID:    firstID,
+		Entries:    len(entries),
+	}
+	contents := make([][]byte, len(files))
+	for i, file := range files {
+		data, ok := file.data.([]byte)
+		if !ok {
+			var err error
+			if data, err = json.Marshal(file.data); err != nil {
+				return err
+			}
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, bundleFile{Name: file.name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+		contents[i] = data
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	writeFile := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: manifest.ExportedAt}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write(data)
+		return err
+	}
+	if err := writeFile("manifest.json", manifestData); err != nil {
+		return err
+	}
+	for i, file := range manifest.Files {
+		if err := writeFile(file.Name, contents[i]); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBundle - чтение пакета с проверкой формата, версии и контрольных сумм
+func readBundle(r io.Reader) (sessionBundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return sessionBundle{}, fmt.Errorf("не пакет сессии: %v", err)
+	}
+	archive := tar.NewReader(gz)
+
+	header, err := archive.Next()
+	if err != nil || header.Name != "manifest.json" {
+		return sessionBundle{}, errors.New("не пакет сессии: нет манифеста")
+	}
+	var manifest bundleManifest
+	if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+		return sessionBundle{}, fmt.Errorf("поврежден манифест: %v", err)
+	}
+	if manifest.Format != bundleFormat {
+		return sessionBundle{}, fmt.Errorf("неизвестный формат пакета: %q", manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > bundleVersion {
+		return sessionBundle{}, fmt.Errorf("версия пакета %d не поддерживается (поддерживаются 1..%d)", manifest.Version, bundleVersion)
+	}
+
+	expected := make(map[string]bundleFile)
+	for _, file := range manifest.Files {
+		expected[file.Name] = file
+	}
+	contents := make(map[string][]byte)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sessionBundle{}, fmt.Errorf("поврежден пакет: %v", err)
+		}
+		file, listed := expected[header.Name]
+		if !listed {
+			return sessionBundle{}, fmt.Errorf("файл %s не описан в манифесте", header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(archive, file.Size+1))
+		if err != nil {
+			return sessionBundle{}, fmt.Errorf("поврежден пакет: %v", err)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+			return sessionBundle{}, fmt.Errorf("не совпадает контрольная сумма %s", header.Name)
+		}
+		contents[header.Name] = data
+	}
+	for name := range expected {
+		if _, exists := contents[name]; !exists {
+			return sessionBundle{}, fmt.Errorf("в пакете нет файла %s", name)
+		}
+	}
+
+	bundle := sessionBundle{Manifest: manifest}
+	var stored []storedError
+	sections := map[string]interface{}{
+		"entries.json": &bundle.Result.Logs,
+		"errors.json":  &stored,
+		"stats.json":   &bundle.Result.Stats,
+	}
+	if manifest.Version >= 2 {
+		sections["annotations.json"] = &bundle.Annotations
+	}
+	if manifest.Version >= 3 {
+		sections["traces.json"] = &bundle.Traces
+	}
+	if manifest.Version >= 4 {
+		sections["index.json"] = &bundle.Result.index
+	}
+	for name, target := range sections {
+		data, exists := contents[name]
+		if !exists {
+			return sessionBundle{}, fmt.Errorf("в пакете нет файла %s", name)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			return sessionBundle{}, fmt.Errorf("поврежден файл %s: %v", name, err)
+		}
+	}
+	for _, parseErr := range stored {
+		bundle.Result.Errors = append(bundle.Result.Errors, ParseError{
+			LineNumber: parseErr.LineNumber,
+			Line:       parseErr.Line,
+			Error:      errors.New(parseErr.Message),
+		})
+	}
+
+	// Исходные строки возвращаются записям; атрибуты хранилище разберет из них само
+	scanner := bufio.NewScanner(bytes.NewReader(contents["raw.jsonl"]))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(contents["raw.jsonl"])+1)
+	line := 0
+	for ; scanner.Scan(); line++ {
+		if line >= len(bundle.Result.Logs) {
+			break
+		}
+		bundle.Result.Logs[line].edit().rawJSON = scanner.Text()
+	}
+	if line != len(bundle.Result.Logs) || len(bundle.Result.Logs) != manifest.Entries {
+		return sessionBundle{}, fmt.Errorf("число записей не совпадает: манифест %d, записей %d, строк %d", manifest.Entries, len(bundle.Result.Logs), line)
+	}
+	for i, entry := range bundle.Result.Logs {
+		if entry.ID != i {
+			return sessionBundle{}, fmt.Errorf("записи пакета идут не по порядку: ID %d на месте %d", entry.ID, i)
+		}
+	}
+	// Пакеты до версии 4 без производных данных: хранилище вычислит их при загрузке
+	if index := bundle.Result.index; index != nil && (len(index.Phases) != manifest.Entries || len(index.Correlation) != manifest.Entries) {
+		return sessionBundle{}, fmt.Errorf("число записей не совпадает: манифест %d, фаз в индексе %d, списков корреляции %d", manifest.Entries, len(index.Phases), len(index.Correlation))
+	}
+	return bundle, nil
+}
+
+// Import - новая сессия с описанием, данными и пометками из пакета: ID выдается
+// новый, название, источник, теги, время создания и ID записей сохраняются
+func (r *SessionRegistry) Import(bundle sessionBundle) (Session, error) {
+	meta := bundle.Manifest.Session
+
+	r.mu.Lock()
+	session, err := r.create(r.unusedID(), meta.Name, meta.Source, meta.Tags)
+	if err == nil && !meta.CreatedAt.IsZero() {
+		session.CreatedAt = meta.CreatedAt
+		if err = r.backend.SaveSession(session); err == nil {
+			r.sessions[session.ID].CreatedAt = meta.CreatedAt
+		}
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return Session{}, err
+	}
+
+	if err := session.Store.Restore(bundle.Result, bundle.Manifest.FirstID); err != nil {
+		r.Delete(session.ID)
+		return Session{}, err
+	}
+	if err := session.Annotations.Replace(bundle.Annotations); err != nil {
+		r.Delete(session.ID)
+		return Session{}, err
+	}
+	if err := session.Traces.Replace(bundle.Traces); err != nil {
+		r.Delete(session.ID)
+		return Session{}, err
+	}
+	return session, nil
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// bundleFilename - имя файла пакета по названию сессии
+func bundleFilename(session Session) string {
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(session.Name, "_"), "_.")
+	if name == "" {
+		name = session.ID
+	}
+	return name + ".tflog.tar.gz"
+}
+
+// Обработчик API для выгрузки сессии: GET /api/export, /api/sessions/{session}/export
+func handleAPIExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+
+	// Пакет собирается в памяти, чтобы ошибка не оборвала уже начатый ответ
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, session, session.Store.Snapshot(), session.Annotations.List(), session.Traces.List()); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Не удалось собрать пакет: "+err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bundleFilename(session)))
+	w.Write(buf.Bytes())
+}
+
+// Обработчик API для загрузки пакета: POST /api/sessions/import, тело -
+// файл пакета или форма с полем file. Создает новую сессию.
+func handleAPIImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, `{"error": "Ошибка чтения файла"}`, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	bundle, err := readBundle(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	session, err := sessions.Import(bundle)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"session": summarize(session),
+	})
+}
+
+// runBundleCommand - команды командной строки:
+//
+//	export [-session ID] [-o файл] [логи...] - пакет сессии из --data-dir или пакет из файлов логов
+//	import пакет...                          - загрузка пакетов в новые сессии
+//
+// Возвращает true, если после import нужно запустить веб-сервер: без
+// --data-dir загруженные сессии живут только в памяти.
+func runBundleCommand(command string, args []string, dataDir string) (bool, error) {
+	switch command {
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		id := fs.String("session", defaultSessionID, "сессия из --data-dir")
+		output := fs.String("o", "", "файл пакета (- - stdout; по умолчанию <название>.tflog.tar.gz)")
+		fs.Parse(args)
+
+		session, err := exportedSession(*id, fs.Args())
+		if err != nil {
+			return false, err
+		}
+		path := *output
+		if path == "" {
+			path = bundleFilename(session)
+		}
+		if path == "-" {
+			return false, writeBundle(os.Stdout, session, session.Store.Snapshot(), session.Annotations.List(), session.Traces.List())
+		}
+		var buf bytes.Buffer
+		if err := writeBundle(&buf, session, session.Store.Snapshot(), session.Annotations.List(), session.Traces.List()); err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(os.Stderr, "Сессия %s (%s) сохранена в %s\n", session.ID, session.Name, path)
+		return false, nil
+
+	case "import":
+		if len(args) == 0 {
+			return false, errors.New("укажите файлы пакетов")
+		}
+		for _, path := range args {
+			file, err := os.Open(path)
+			if err != nil {
+				return false, err
+			}
+			bundle, err := readBundle(file)
+			file.Close()
+			if err != nil {
+				return false, fmt.Errorf("%s: %v", path, err)
+			}
+			session, err := sessions.Import(bundle)
+			if err != nil {
+				return false, fmt.Errorf("%s: %v", path, err)
+			}
+			fmt.Printf("Пакет %s загружен в сессию %s (%s): %d записей\n", path, session.ID, session.Name, bundle.Manifest.Entries)
+		}
+		return dataDir == "", nil
+	}
+	return false, fmt.Errorf("неизвестная команда: %s", command)
+}
+
+// exportedSession - сессия для выгрузки: из реестра или, если переданы
+// файлы логов, новая сессия только в памяти
+func exportedSession(id string, files []string) (Session, error) {
+	if len(files) == 0 {
+		session, exists := sessions.Get(id)
+		if !exists {
+			return Session{}, errSessionNotFound
+		}
+		return session, nil
+	}
+
+	result, err := NewLogParser().ParseFiles(files)
+	if err != nil {
+		return Session{}, err
+	}
+	session := Session{
+		ID:          newSessionID(),
+		Name:        filepath.Base(files[0]),
+		CreatedAt:   time.Now(),
+		Source:      "files: " + strings.Join(files, ", "),
+		Store:       NewMemoryStore(),
+		Annotations: newAnnotationStore(nil, func([]Annotation) error { return nil }),
+		Traces:      newTraceStore(nil, func([]TraceSpan) error { return nil }),
+	}
+	_, err = session.Store.Append(result)
+	return session, err
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := registry.Create("failing run", "upload: plan.log", []string{"ci"})
+	session.Store.Append(parseSample(t, 6))
+	session.Store.Trim(4, 0)
+	session.Annotations.Add(3, []string{"Root-Cause"}, "таймаут провайдера")
+
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, session, session.Store.Snapshot(), session.Annotations.List(), session.Traces.List()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	bundle, err := readBundle(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := registry.Import(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.ID == session.ID || imported.Name != session.Name || imported.Source != session.Source || !imported.CreatedAt.Equal(session.CreatedAt) {
+		t.Errorf("описание сессии не восстановлено: %+v", imported)
+	}
+
+	original, restored := session.Store.Snapshot(), imported.Store.Snapshot()
+	if len(restored.Logs) != len(original.Logs) || restored.Stats.ByLevel["info"] != original.Stats.ByLevel["info"] {
+		t.Fatalf("данные не совпадают: %d записей, %+v", len(restored.Logs), restored.Stats)
+	}
+	for i := range original.Logs {
+		a, b := original.Logs[i], restored.Logs[i]
+		if a.ID != b.ID || a.Message() != b.Message() || rawJSON(&a) != rawJSON(&b) || logAttributes(b)["@message"] != a.Message() {
+			t.Errorf("запись %d восстановлена неверно: %+v", i, b)
+		}
+	}
+
+	if list := imported.Annotations.List(); len(list) != 1 || list[0].EntryID != 3 || list[0].Tags[0] != "root-cause" {
+		t.Errorf("пометки не восстановлены: %+v", list)
+	}
+
+	// Следующая запись продолжает нумерацию
+	imported.Store.Append(parseSample(t, 1))
+	if logs := imported.Store.Snapshot().Logs; logs[len(logs)-1].ID != 6 {
+		t.Errorf("ID новой записи %d, ожидалось 6", logs[len(logs)-1].ID)
+	}
+
+	// Изменение данных ломает контрольную сумму
+	var tampered bytes.Buffer
+	bad := *original
+	bad.Logs = append([]TerraformLog(nil), original.Logs...)
+	entry, _ := json.Marshal(original.Logs[0])
+	if err := json.Unmarshal(entry, &bad.Logs[0]); err != nil {
+		t.Fatal(err)
+	}
+	bad.Logs[0].edit().texts[textMessage] = "подмена"
+	writeBundle(&tampered, session, &bad, nil, nil)
+	if _, err := readBundle(bytes.NewReader(swapEntries(t, data, tampered.Bytes()))); err == nil || !strings.Contains(err.Error(), "контрольная сумма") {
+		t.Errorf("подмена данных не обнаружена: %v", err)
+	}
+}
+
+func TestBundleShipsEntryIndex(t *testing.T) {
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := registry.Create("apply", "test", nil)
+	session.Store.Append(NewLogParser().ParseStream(strings.NewReader(strings.Join(traceLines(5), "\n"))))
+	original := session.Store.Snapshot()
+
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, session, original, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := readBundle(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := newEntryIndex(original.Logs)
+	if index := bundle.Result.index; index == nil || !reflect.DeepEqual(index, want) {
+		t.Fatalf("индекс пакета %+v, ожидалось %+v", index, want)
+	}
+
+	// При загрузке индекс берется из пакета, а не вычисляется заново
+	bundle.Result.index.Phases[0] = "from-bundle"
+	imported, err := registry.Import(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := imported.Store.Snapshot().Logs
+	if phase := logPhase(&logs[0]); phase != "from-bundle" {
+		t.Errorf("фаза первой записи %q, ожидалась фаза из пакета", phase)
+	}
+	for i := range logs {
+		if ids := correlationIDs(&logs[i]); !slices.Equal(ids, want.Correlation[i]) {
+			t.Errorf("запись %d: идентификаторы корреляции %v, ожидалось %v", i, ids, want.Correlation[i])
+		}
+	}
+}
+
+func TestExportedSessionFromFiles(t *testing.T) {
+	path := writeSampleLog(t, 3)
+	session, err := exportedSession(defaultSessionID, []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Name != "plan.log" || len(session.Store.Snapshot().Logs) != 3 {
+		t.Errorf("сессия из файла: %+v", session)
+	}
+	if session.Annotations == nil || len(session.Annotations.List()) != 0 {
+		t.Errorf("сессия из файла должна иметь пустое хранилище пометок")
+	}
+	if session.Traces == nil || len(session.Traces.List()) != 0 {
+		t.Errorf("сессия из файла должна иметь пустое хранилище спанов")
+	}
+}
+
+func TestExportCommandFromFiles(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "run.tflog.tar.gz")
+	if _, err := runBundleCommand("export", []string{"-o", output, writeSampleLog(t, 4)}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	bundle, err := readBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Manifest.Version != bundleVersion || bundle.Manifest.Entries != 4 || len(bundle.Result.Logs) != 4 {
+		t.Errorf("манифест %+v, записей %d", bundle.Manifest, len(bundle.Result.Logs))
+	}
+	if len(bundle.Annotations) != 0 || len(bundle.Traces) != 0 {
+		t.Errorf("пометки %+v и спаны %+v должны быть пустыми", bundle.Annotations, bundle.Traces)
+	}
+}
+
+// writeSampleLog - файл plan.log со строками sampleLines во временном каталоге теста
+func writeSampleLog(t *testing.T, lines int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plan.log")
+	if err := os.WriteFile(path, []byte(sampleLines(t, lines)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// swapEntries - пакет original с entries.json из пакета other при прежнем манифесте
+func swapEntries(t *testing.T, original, other []byte) []byte {
+	t.Helper()
+	files := func(data []byte) map[string][]byte {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := tar.NewReader(gz)
+		contents := make(map[string][]byte)
+		for {
+			header, err := archive.Next()
+			if err != nil {
+				break
+			}
+			contents[header.Name], _ = io.ReadAll(archive)
+		}
+		return contents
+	}
+	result, replacement := files(original), files(other)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for _, name := range []string{"manifest.json", "raw.jsonl", "entries.json", "errors.json", "stats.json", "annotations.json"} {
+		data := result[name]
+		if name == "entries.json" {
+			data = replacement[name]
+		}
+		archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))})
+		archive.Write(data)
+	}
+	archive.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// bundleEntry - файл пакета
+type bundleEntry struct {
+	name string
+	data []byte
+}
+
+// unpackBundle - файлы пакета по порядку
+func unpackBundle(t *testing.T, data []byte) []bundleEntry {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(gz)
+	var entries []bundleEntry
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(archive)
+		entries = append(entries, bundleEntry{header.Name, content})
+	}
+}
+
+func packBundle(entries []bundleEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for _, entry := range entries {
+		archive.WriteHeader(&tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.data))})
+		archive.Write(entry.data)
+	}
+	archive.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// editManifest - пакет с измененным манифестом
+func editManifest(t *testing.T, entries []bundleEntry, edit func(*bundleManifest)) []bundleEntry {
+	t.Helper()
+	var manifest bundleManifest
+	if err := json.Unmarshal(entries[0].data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	edit(&manifest)
+	data, _ := json.Marshal(manifest)
+	return append([]bundleEntry{{"manifest.json", data}}, entries[1:]...)
+}
+
+// replaceFile - пакет с другим содержимым файла и верной контрольной суммой
+func replaceFile(t *testing.T, entries []bundleEntry, name string, data []byte) []bundleEntry {
+	t.Helper()
+	edited := editManifest(t, entries, func(m *bundleManifest) {
+		for i := range m.Files {
+			if m.Files[i].Name == name {
+				sum := sha256.Sum256(data)
+				m.Files[i].Size, m.Files[i].SHA256 = int64(len(data)), hex.EncodeToString(sum[:])
+			}
+		}
+	})
+	for i := range edited {
+		if edited[i].name == name {
+			edited[i].data = data
+		}
+	}
+	return edited
+}
+
+// withoutFiles - пакет без файлов names ни в архиве, ни в манифесте
+func withoutFiles(t *testing.T, entries []bundleEntry, names ...string) []bundleEntry {
+	t.Helper()
+	entries = editManifest(t, entries, func(m *bundleManifest) {
+		m.Files = slices.DeleteFunc(m.Files, func(f bundleFile) bool { return slices.Contains(names, f.Name) })
+	})
+	return slices.DeleteFunc(entries, func(e bundleEntry) bool { return slices.Contains(names, e.name) })
+}
+
+func TestReadBundleErrors(t *testing.T) {
+	registry := useTestSessions(t)
+	session, _ := registry.Create("plan", "test", nil)
+	session.Store.Append(parseSample(t, 3))
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, session, session.Store.Snapshot(), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	valid := unpackBundle(t, buf.Bytes())
+	if valid[0].name != "manifest.json" {
+		t.Fatalf("манифест должен идти первым: %s", valid[0].name)
+	}
+
+	var entries []TerraformLog
+	json.Unmarshal(valid[2].data, &entries)
+	entries[0].ID, entries[1].ID = 1, 0
+	swapped, _ := json.Marshal(entries)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"не gzip", []byte("plain text"), "не пакет сессии"},
+		{"обрезан", buf.Bytes()[:buf.Len()/2], ""},
+		{"манифест не первый", packBundle(append(slices.Clone(valid[1:]), valid[0])), "нет манифеста"},
+		{"манифест не JSON", packBundle(append([]bundleEntry{{"manifest.json", []byte("{")}}, valid[1:]...)), "поврежден манифест"},
+		{"чужой формат", packBundle(editManifest(t, valid, func(m *bundleManifest) { m.Format = "zip" })), "неизвестный формат"},
+		{"версия 0", packBundle(editManifest(t, valid, func(m *bundleManifest) { m.Version = 0 })), "не поддерживается"},
+		{"версия из будущего", packBundle(editManifest(t, valid, func(m *bundleManifest) { m.Version = bundleVersion + 1 })), "не поддерживается"},
+		{"лишний файл", packBundle(append(slices.Clone(valid), bundleEntry{"extra.txt", []byte("x")})), "не описан в манифесте"},
+		{"нет файла", packBundle(slices.DeleteFunc(slices.Clone(valid), func(e bundleEntry) bool { return e.name == "stats.json" })), "в пакете нет файла stats.json"},
+		{"нет файла в манифесте", packBundle(withoutFiles(t, valid, "entries.json")), "в пакете нет файла entries.json"},
+		{"файл не JSON", packBundle(replaceFile(t, valid, "errors.json", []byte("["))), "поврежден файл errors.json"},
+		{"число записей", packBundle(editManifest(t, valid, func(m *bundleManifest) { m.Entries = 5 })), "число записей не совпадает"},
+		{"индекс короче", packBundle(replaceFile(t, valid, "index.json", []byte(`{"Phases":["plan"],"Correlation":[null]}`))), "фаз в индексе 1"},
+		{"строк меньше записей", packBundle(replaceFile(t, valid, "raw.jsonl", []byte("{}\n"))), "число записей не совпадает"},
+		{"порядок записей", packBundle(replaceFile(t, valid, "entries.json", swapped)), "не по порядку"},
+	}
+	for _, tt := range tests {
+		if _, err := readBundle(bytes.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ошибка %v, ожидалась %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Пакет версии 1 - без пометок, спанов и индекса
+	old := withoutFiles(t, editManifest(t, valid, func(m *bundleManifest) { m.Version = 1 }), "annotations.json", "traces.json", "index.json")
+	if bundle, err := readBundle(bytes.NewReader(packBundle(old))); err != nil || len(bundle.Result.Logs) != 3 || bundle.Result.index != nil {
+		t.Errorf("пакет версии 1: %v", err)
+	}
+
+	_, server := testServer(t)
+	var failed struct {
+		Error string `json:"error"`
+	}
+	if code := serveJSON(t, server, "POST", "/api/sessions/import", "plain text", &failed); code != http.StatusBadRequest || !strings.Contains(failed.Error, "не пакет сессии") {
+		t.Errorf("импорт не пакета: код %d, %+v", code, failed)
+	}
+	if code := serveJSON(t, server, "GET", "/api/sessions/import", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET импорта: код %d", code)
+	}
+}
@@ -0,0 +1,788 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Компактное хранение записей. Хранилище держит записи блоками по
+// rawBlockLines: строка TerraformLog остается только для ID и времени,
+// остальное хранится колонками блока:
+//   - поля-имена (уровень, модуль, caller, RPC, провайдер, тип записи) -
+//     номера в словаре блока, сами строки интернируются на сессию;
+//   - сообщение и tf_req_id - номера в строковых значениях блока;
+//   - исходные строки - подряд, у заполненного блока сжаты и распаковываются
+//     по требованию;
+//   - атрибуты - для каждого ключа номер значения в словаре блока,
+//     logAttributes собирает из них карту без разбора JSON; атрибуты,
+//     совпадающие с полями записи или с ее временем, не хранятся;
+//   - строковые значения заполненного блока склеены в одну строку;
+//   - нужная фильтрам фаза и идентификаторы корреляции для /api/requests
+//     вычисляются один раз при записи.
+//
+// Последний блок открыт: мелкие загрузки (поток run, follow, syslog)
+// дописываются в него, пока он не заполнится, и только тогда строки сжимаются.
+
+const (
+	rawBlockLines      = 256
+	maxInternedLength  = 256
+	maxInternedStrings = 1 << 16
+	rawBlockCacheSize  = 32
+)
+
+// nameField - поле записи с небольшим числом разных значений
+type nameField uint8
+
+const (
+	nameLevel nameField = iota
+	nameModule
+	nameCaller
+	nameTfRPC
+	nameTfProtoVersion
+	nameTfProviderAddr
+	nameEntryType
+	nameFields
+)
+
+// textField - текстовое поле записи: у каждой записи свое значение
+type textField uint8
+
+const (
+	textMessage textField = iota
+	textTfReqID
+	textFields
+)
+
+// pendingLog - поля записи, еще не перенесенной в блок
+type pendingLog struct {
+	names   [nameFields]string
+	texts   [textFields]string
+	rawJSON string
+}
+
+func (log *TerraformLog) Level() string          { return log.name(nameLevel) }
+func (log *TerraformLog) Module() string         { return log.name(nameModule) }
+func (log *TerraformLog) Caller() string         { return log.name(nameCaller) }
+func (log *TerraformLog) TfRPC() string          { return log.name(nameTfRPC) }
+func (log *TerraformLog) TfProtoVersion() string { return log.name(nameTfProtoVersion) }
+func (log *TerraformLog) TfProviderAddr() string { return log.name(nameTfProviderAddr) }
+func (log *TerraformLog) EntryType() string      { return log.name(nameEntryType) }
+func (log *TerraformLog) Message() string        { return log.text(textMessage) }
+func (log *TerraformLog) TfReqID() string        { return log.text(textTfReqID) }
+
+func (log *TerraformLog) name(field nameField) string {
+	if log.raw.block != nil {
+		return log.raw.name(field)
+	}
+	if log.pending != nil {
+		return log.pending.names[field]
+	}
+	return ""
+}
+
+func (log *TerraformLog) text(field textField) string {
+	if log.raw.block != nil {
+		return log.raw.text(field)
+	}
+	if log.pending != nil {
+		return log.pending.texts[field]
+	}
+	return ""
+}
+
+// edit - поля записи для заполнения; только для записей, еще не переданных хранилищу
+func (log *TerraformLog) edit() *pendingLog {
+	if log.pending == nil {
+		log.pending = new(pendingLog)
+	}
+	return log.pending
+}
+
+// withoutRawJSON - копия записи без исходной строки (поля записи общие с
+// исходной записью и не меняются)
+func (log TerraformLog) withoutRawJSON() TerraformLog {
+	if log.pending != nil && log.pending.rawJSON != "" {
+		pending := *log.pending
+		pending.rawJSON = ""
+		log.pending = &pending
+	}
+	return log
+}
+
+// terraformLogJSON - запись в JSON: поля-имена выглядят как обычные поля
+type terraformLogJSON struct {
+	ID             int
+	Level          string
+	Message        string
+	Module         string
+	Caller         string
+	Timestamp      time.Time
+	TfReqID        string
+	TfRPC          string
+	TfProtoVersion string
+	TfProviderAddr string
+	EntryType      string
+	RawJSON        string `json:",omitempty"`
+}
+
+func (log TerraformLog) MarshalJSON() ([]byte, error) {
+	decoded := terraformLogJSON{
+		ID:             log.ID,
+		Level:          log.Level(),
+		Message:        log.Message(),
+		Module:         log.Module(),
+		Caller:         log.Caller(),
+		Timestamp:      log.Timestamp,
+		TfReqID:        log.TfReqID(),
+		TfRPC:          log.TfRPC(),
+		TfProtoVersion: log.TfProtoVersion(),
+		TfProviderAddr: log.TfProviderAddr(),
+		EntryType:      log.EntryType(),
+	}
+	// Исходная строка есть в JSON только у записей вне хранилища
+	if log.raw.block == nil && log.pending != nil {
+		decoded.RawJSON = log.pending.rawJSON
+	}
+	return json.Marshal(decoded)
+}
+
+func (log *TerraformLog) UnmarshalJSON(data []byte) error {
+	var decoded terraformLogJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*log = TerraformLog{
+		ID:        decoded.ID,
+		Timestamp: decoded.Timestamp,
+		pending: &pendingLog{
+			names: [nameFields]string{
+				nameLevel:          decoded.Level,
+				nameModule:         decoded.Module,
+				nameCaller:         decoded.Caller,
+				nameTfRPC:          decoded.TfRPC,
+				nameTfProtoVersion: decoded.TfProtoVersion,
+				nameTfProviderAddr: decoded.TfProviderAddr,
+				nameEntryType:      decoded.EntryType,
+			},
+			texts: [textFields]string{
+				textMessage: decoded.Message,
+				textTfReqID: decoded.TfReqID,
+			},
+			rawJSON: decoded.RawJSON,
+		},
+	}
+	return nil
+}
+
+// interner - общий экземпляр для повторяющихся строк
+type interner struct {
+	strings map[string]string
+}
+
+func newInterner() *interner {
+	return &interner{strings: make(map[string]string)}
+}
+
+// intern - сохраненная копия s; длинные строки и строки сверх размера
+// таблицы возвращаются как есть
+func (in *interner) intern(s string) string {
+	if s == "" || len(s) > maxInternedLength {
+		return s
+	}
+	if shared, exists := in.strings[s]; exists {
+		return shared
+	}
+	if len(in.strings) >= maxInternedStrings {
+		return s
+	}
+	// Копия отвязывает строку от исходной строки лога, из которой она вырезана
+	s = string([]byte(s))
+	in.strings[s] = s
+	return s
+}
+
+// rawBlock - колонки подряд идущих записей. Хранилище дописывает записи в
+// открытый блок под своей блокировкой, читатели без блокировки берут
+// состояние блока целиком: срезы состояния только дополняются за их длиной,
+// поэтому выданное состояние (и снимки с записями блока) не меняется.
+type rawBlock struct {
+	state atomic.Pointer[blockState]
+	index *blockIndex // словари для дописывания; nil у заполненного блока
+}
+
+// blockState - содержимое блока на момент последнего дописывания
+type blockState struct {
+	data  []byte   // flate; nil - строки не сжаты и лежат в plain
+	plain []byte   // исходные строки открытого блока
+	ends  []uint32 // конец каждой строки в распакованных данных
+	attrs attrColumns
+	// names - поля-имена записей: номер в nameValues
+	names      [nameFields][]uint8
+	nameValues []string
+	// texts - текстовые поля записей: номер в attrs.strings (с 1), 0 - пустая строка
+	texts  [textFields][]uint16
+	phases []uint8 // logPhase записей: номер в phaseNames
+	// phaseNames - фазы, встретившиеся в блоке
+	phaseNames []string
+	// correlation - correlationIDs записей подряд (номера в attrs.strings),
+	// correlationEnds - конец списка записи
+	correlation     []uint16
+	correlationEnds []uint16
+	// zone - часовой пояс @timestamp записей блока, см. timestampCell
+	zone *time.Location
+}
+
+// attrColumns - атрибуты записей блока по колонкам; строковые значения
+// хранятся без упаковки в interface{}, остальные (числа, bool, null) - отдельно
+type attrColumns struct {
+	keys    []string
+	cells   [][]uint16    // по ключам, затем по записям: 0 - атрибута нет, см. attrValueTag
+	strings []string      // строковые значения открытого блока: i - strings[i-1]
+	others  []interface{} // прочие скалярные значения: i|attrValueTag - others[i-1]
+	decode  []bool        // у записи вложенные значения: атрибуты разбираются из исходной строки
+	// fields - поле записи, с которым совпадает колонка (cells колонки nil),
+	// см. attrFields; -1 - значения колонки в cells
+	fields []int8
+	// text, textEnds - строковые значения заполненного блока подряд в одной строке
+	text     string
+	textEnds []uint32
+}
+
+const (
+	// attrValueTag - признак номера в others; номеров каждого вида меньше 1<<15
+	attrValueTag = 1 << 15
+	// timestampCell - @timestamp записи совпадает с ее временем в формате
+	// timestampLayout и часовом поясе блока
+	timestampCell   = attrValueTag
+	timestampLayout = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// attrFields - атрибуты, из которых парсер берет поля записи: номер поля-имени
+// или nameFields+номер текстового поля. Пока значения такого атрибута в блоке
+// совпадают с полем (атрибут есть ровно у записей с непустым полем), колонка
+// атрибута не хранится.
+var attrFields = map[string]int8{
+	"@level":           int8(nameLevel),
+	"@module":          int8(nameModule),
+	"@caller":          int8(nameCaller),
+	"tf_rpc":           int8(nameTfRPC),
+	"tf_proto_version": int8(nameTfProtoVersion),
+	"tf_provider_addr": int8(nameTfProviderAddr),
+	"@message":         int8(nameFields) + int8(textMessage),
+	"tf_req_id":        int8(nameFields) + int8(textTfReqID),
+}
+
+// field - значение поля записи row по номеру из attrFields
+func (state *blockState) field(field int8, row int) string {
+	if field < int8(nameFields) {
+		return state.nameValues[state.names[field][row]]
+	}
+	if idx := state.texts[field-int8(nameFields)][row]; idx > 0 {
+		return state.attrs.str(idx)
+	}
+	return ""
+}
+
+// fieldColumn - новая колонка атрибута может совпадать с полем: у прежних
+// rows записей поле пустое (атрибута у них не было)
+func (state *blockState) fieldColumn(field int8, rows int) bool {
+	for row := range rows {
+		if state.field(field, row) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// str - строковое значение номер idx (с 1)
+func (c *attrColumns) str(idx uint16) string {
+	if c.strings != nil {
+		return c.strings[idx-1]
+	}
+	start := uint32(0)
+	if idx > 1 {
+		start = c.textEnds[idx-2]
+	}
+	return c.text[start:c.textEnds[idx-1]]
+}
+
+// blockIndex - обратные словари открытого блока: значение -> номер
+type blockIndex struct {
+	names   map[string]uint8
+	keys    map[string]int
+	strings map[string]uint16
+	others  map[interface{}]uint16
+}
+
+// rawLine - ссылка записи на ее строку в блоке
+type rawLine struct {
+	block *rawBlock
+	index int
+}
+
+func newRawBlock() *rawBlock {
+	block := &rawBlock{index: &blockIndex{
+		names:   make(map[string]uint8),
+		keys:    make(map[string]int),
+		strings: make(map[string]uint16),
+		others:  make(map[interface{}]uint16),
+	}}
+	state := &blockState{ends: make([]uint32, 0, rawBlockLines)}
+	for field := range state.names {
+		state.names[field] = make([]uint8, 0, rawBlockLines)
+	}
+	for field := range state.texts {
+		state.texts[field] = make([]uint16, 0, rawBlockLines)
+	}
+	block.state.Store(state)
+	return block
+}
+
+// entryIndex - производные данные записей, вычисляемые при приеме: фаза
+// (logPhase) и идентификаторы корреляции (correlationIDs). Хранилище на диске
+// сохраняет их рядом с записями, чтобы при загрузке не вычислять заново.
+type entryIndex struct {
+	Phases      []string
+	Correlation [][]string
+}
+
+func newEntryIndex(logs []TerraformLog) *entryIndex {
+	index := &entryIndex{
+		Phases:      make([]string, len(logs)),
+		Correlation: make([][]string, len(logs)),
+	}
+	for i := range logs {
+		index.Phases[i] = logPhase(&logs[i])
+		index.Correlation[i] = correlationIDs(&logs[i])
+	}
+	return index
+}
+
+// compactLogs - перенос новых записей logs[open:] в блоки. logs[:open] -
+// записи открытого блока, уже видимые читателям: они не меняются, новые
+// записи дописываются в тот же блок. index (если не nil) - уже вычисленные
+// производные данные новых записей. Возвращает, сколько последних записей
+// logs лежит в открытом блоке.
+func compactLogs(logs []TerraformLog, open int, names *interner, index *entryIndex) int {
+	var block *rawBlock
+	if open > 0 {
+		block = logs[0].raw.block
+	}
+	for start := open; start < len(logs); {
+		if block == nil {
+			block = newRawBlock()
+		}
+		base := len(block.state.Load().ends)
+		limit := min(start+rawBlockLines-base, len(logs))
+		end := start + block.add(logs[start:limit], names, index, start-open)
+
+		for i := start; i < end; i++ {
+			log := &logs[i]
+			log.raw = rawLine{block: block, index: base + i - start}
+			log.pending = nil
+		}
+		// Блок закрывается заполненным или когда в словаре строк нет места
+		// для следующей записи
+		if len(block.state.Load().ends) == rawBlockLines || end < limit {
+			block.seal()
+			block = nil
+		}
+		start = end
+	}
+	if block == nil {
+		return 0
+	}
+	// Первые записи открытого блока могли быть вытеснены
+	return min(len(block.state.Load().ends), len(logs))
+}
+
+// add - дописывание записей в открытый блок. index[offset:] - производные
+// данные записей. Возвращает число дописанных записей: меньше len(logs), если
+// номера значений следующей записи могут не уместиться в словари блока.
+func (b *rawBlock) add(logs []TerraformLog, names *interner, index *entryIndex, offset int) int {
+	state := *b.state.Load()
+	// Колонки атрибутов дописываются по месту: список колонок копируется,
+	// чтобы прежнее состояние не видело новых
+	state.attrs.cells = append([][]uint16(nil), state.attrs.cells...)
+	state.attrs.fields = slices.Clone(state.attrs.fields)
+
+	added := 0
+	for i := range logs {
+		log := &logs[i]
+		var phase string
+		var ids []string
+		if index != nil {
+			phase, ids = index.Phases[offset+i], index.Correlation[offset+i]
+		} else {
+			phase, ids = logPhase(log), correlationIDs(log)
+		}
+		attrs := logAttributes(*log)
+		// Новые строки записи: атрибуты, тексты, идентификаторы и значения
+		// полей при переводе колонок на хранение (storeColumn)
+		values := max(len(state.attrs.strings), len(state.attrs.others)) + len(attrs) + len(ids) + int(textFields) + len(attrFields)*rawBlockLines
+		if len(state.ends) > 0 && (values >= attrValueTag || len(state.nameValues)+int(nameFields) > 1<<8) {
+			break
+		}
+
+		state.plain = append(state.plain, rawJSON(log)...)
+		state.ends = append(state.ends, uint32(len(state.plain)))
+		for field := range state.names {
+			code := b.nameCode(&state, names.intern(log.name(nameField(field))))
+			state.names[field] = append(state.names[field], code)
+		}
+		for field := range state.texts {
+			var code uint16
+			if value := log.text(textField(field)); value != "" {
+				code = b.stringIndex(&state, value)
+			}
+			state.texts[field] = append(state.texts[field], code)
+		}
+		b.addAttributes(&state, log, attrs, names)
+
+		state.phases = append(state.phases, state.phaseIndex(phase))
+		for _, id := range ids {
+			state.correlation = append(state.correlation, b.stringIndex(&state, id))
+		}
+		state.correlationEnds = append(state.correlationEnds, uint16(len(state.correlation)))
+		added++
+	}
+	b.state.Store(&state)
+	return added
+}
+
+// nameCode - номер поля-имени в словаре блока
+func (b *rawBlock) nameCode(state *blockState, value string) uint8 {
+	code, exists := b.index.names[value]
+	if !exists {
+		state.nameValues = append(state.nameValues, value)
+		code = uint8(len(state.nameValues) - 1)
+		b.index.names[value] = code
+	}
+	return code
+}
+
+// stringIndex - номер s в строковых значениях блока (с 1)
+func (b *rawBlock) stringIndex(state *blockState, s string) uint16 {
+	idx, exists := b.index.strings[s]
+	if !exists {
+		state.attrs.strings = append(state.attrs.strings, s)
+		idx = uint16(len(state.attrs.strings))
+		b.index.strings[s] = idx
+	}
+	return idx
+}
+
+// addAttributes - атрибуты записи в колонки блока. @timestamp, совпадающий
+// со временем записи в формате Terraform, не хранится: см. timestampCell.
+// Атрибуты, из которых разобраны поля записи, не хранятся, пока совпадают с
+// полями: см. attrFields.
+func (b *rawBlock) addAttributes(state *blockState, log *TerraformLog, attrs map[string]interface{}, names *interner) {
+	columns := &state.attrs
+	row := len(columns.decode)
+	for col := range columns.cells {
+		if columns.fields[col] < 0 {
+			columns.cells[col] = append(columns.cells[col], 0)
+		}
+	}
+
+	decode := attrs == nil || !scalarAttributes(attrs)
+	columns.decode = append(columns.decode, decode)
+	if decode {
+		return
+	}
+
+	for col, field := range columns.fields {
+		if _, exists := attrs[columns.keys[col]]; !exists && field >= 0 && state.field(field, row) != "" {
+			b.storeColumn(state, col)
+		}
+	}
+	for key, value := range attrs {
+		s, isString := value.(string)
+		col, exists := b.index.keys[key]
+		if !exists {
+			col = len(columns.keys)
+			b.index.keys[key] = col
+			columns.keys = append(columns.keys, names.intern(key))
+			columns.cells = append(columns.cells, nil)
+			columns.fields = append(columns.fields, -1)
+			if field, derived := attrFields[key]; derived && isString && state.fieldColumn(field, row) {
+				columns.fields[col] = field
+			} else {
+				columns.cells[col] = make([]uint16, row+1, rawBlockLines)
+			}
+		}
+		if field := columns.fields[col]; field >= 0 {
+			if isString && s != "" && s == state.field(field, row) {
+				continue
+			}
+			b.storeColumn(state, col)
+		}
+
+		var idx uint16
+		if isString && key == "@timestamp" && s == state.timestamp(log.Timestamp) {
+			idx = timestampCell
+		} else if isString {
+			idx = b.stringIndex(state, s)
+		} else if idx, exists = b.index.others[value]; !exists {
+			columns.others = append(columns.others, value)
+			idx = uint16(len(columns.others)) | attrValueTag
+			b.index.others[value] = idx
+		}
+		columns.cells[col][row] = idx
+	}
+}
+
+// storeColumn - перевод колонки, совпадавшей с полем записи, на хранение
+// значений: прежние значения берутся из поля, значение текущей записи не задано
+func (b *rawBlock) storeColumn(state *blockState, col int) {
+	columns := &state.attrs
+	field := columns.fields[col]
+	if field < 0 {
+		return
+	}
+	cells := make([]uint16, len(columns.decode), rawBlockLines)
+	for row := range cells[:len(cells)-1] {
+		if value := state.field(field, row); value != "" {
+			cells[row] = b.stringIndex(state, value)
+		}
+	}
+	columns.cells[col], columns.fields[col] = cells, -1
+}
+
+// seal - сжатие строк заполненного блока; словари для дописывания больше не
+// нужны, строковые значения склеиваются в одну строку, запас срезов отбрасывается
+func (b *rawBlock) seal() {
+	state := *b.state.Load()
+	if data := compressRawLines(state.plain); data != nil {
+		state.data, state.plain = data, nil
+	}
+	columns := &state.attrs
+	if len(columns.strings) > 0 {
+		var text strings.Builder
+		columns.textEnds = make([]uint32, len(columns.strings))
+		for i, s := range columns.strings {
+			text.WriteString(s)
+			columns.textEnds[i] = uint32(text.Len())
+		}
+		columns.text, columns.strings = text.String(), nil
+	}
+	columns.keys = slices.Clone(columns.keys)
+	columns.others = slices.Clone(columns.others)
+	columns.decode = slices.Clone(columns.decode)
+	state.nameValues = slices.Clone(state.nameValues)
+	state.phases = slices.Clone(state.phases)
+	state.correlation = slices.Clone(state.correlation)
+	state.correlationEnds = slices.Clone(state.correlationEnds)
+	b.index = nil
+	b.state.Store(&state)
+}
+
+func compressRawLines(plain []byte) []byte {
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return nil
+	}
+	writer.Write(plain)
+	if err := writer.Close(); err != nil {
+		return nil
+	}
+	return bytes.Clone(compressed.Bytes())
+}
+
+// timestamp - время записи в формате timestampLayout и часовом поясе блока
+// (пояс первой записи блока)
+func (state *blockState) timestamp(ts time.Time) string {
+	if state.zone == nil {
+		_, offset := ts.Zone()
+		state.zone = time.FixedZone("", offset)
+	}
+	return ts.In(state.zone).Format(timestampLayout)
+}
+
+// phaseIndex - номер фазы в словаре блока; фаз немного, см. logPhase
+func (state *blockState) phaseIndex(phase string) uint8 {
+	for i, name := range state.phaseNames {
+		if name == phase {
+			return uint8(i)
+		}
+	}
+	state.phaseNames = append(state.phaseNames, phase)
+	return uint8(len(state.phaseNames) - 1)
+}
+
+func scalarAttributes(attrs map[string]interface{}) bool {
+	for _, value := range attrs {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+func (line rawLine) name(field nameField) string {
+	state := line.block.state.Load()
+	return state.nameValues[state.names[field][line.index]]
+}
+
+func (line rawLine) text(field textField) string {
+	state := line.block.state.Load()
+	if idx := state.texts[field][line.index]; idx > 0 {
+		return state.attrs.str(idx)
+	}
+	return ""
+}
+
+// phase - фаза записи, вычисленная при записи в блок
+func (line rawLine) phase() (string, bool) {
+	if line.block == nil {
+		return "", false
+	}
+	state := line.block.state.Load()
+	return state.phaseNames[state.phases[line.index]], true
+}
+
+// correlation - идентификаторы корреляции, вычисленные при записи в блок
+func (line rawLine) correlation() ([]string, bool) {
+	if line.block == nil {
+		return nil, false
+	}
+	state := line.block.state.Load()
+	start := uint16(0)
+	if line.index > 0 {
+		start = state.correlationEnds[line.index-1]
+	}
+	end := state.correlationEnds[line.index]
+	if start == end {
+		return nil, true
+	}
+	ids := make([]string, end-start)
+	for i, idx := range state.correlation[start:end] {
+		ids[i] = state.attrs.str(idx)
+	}
+	return ids, true
+}
+
+// attributes - атрибуты записи из колонок блока; false - их нужно разбирать
+// из исходной строки. ts - время записи для @timestamp, см. timestampCell.
+func (line rawLine) attributes(ts time.Time) (map[string]interface{}, bool) {
+	if line.block == nil {
+		return nil, false
+	}
+	state := line.block.state.Load()
+	columns := &state.attrs
+	if columns.decode[line.index] {
+		return nil, false
+	}
+	attrs := make(map[string]interface{}, len(columns.keys))
+	for col, key := range columns.keys {
+		if field := columns.fields[col]; field >= 0 {
+			if value := state.field(field, line.index); value != "" {
+				attrs[key] = value
+			}
+			continue
+		}
+		switch idx := columns.cells[col][line.index]; {
+		case idx == timestampCell:
+			attrs[key] = ts.In(state.zone).Format(timestampLayout)
+		case idx&attrValueTag != 0:
+			attrs[key] = columns.others[idx&^attrValueTag-1]
+		case idx > 0:
+			attrs[key] = columns.str(idx)
+		}
+	}
+	return attrs, true
+}
+
+// rawJSON - исходная строка записи: из блока или из еще не перенесенных полей
+func rawJSON(log *TerraformLog) string {
+	if log.raw.block == nil {
+		if log.pending == nil {
+			return ""
+		}
+		return log.pending.rawJSON
+	}
+	state := log.raw.block.state.Load()
+	plain := state.plain
+	if state.data != nil {
+		if plain = rawBlocks.get(log.raw.block, state.data); plain == nil {
+			return ""
+		}
+	}
+	start := uint32(0)
+	if log.raw.index > 0 {
+		start = state.ends[log.raw.index-1]
+	}
+	return string(plain[start:state.ends[log.raw.index]])
+}
+
+// rawSize - объем колонок записи в памяти: доля блока
+func rawSize(log *TerraformLog) int {
+	block := log.raw.block
+	if block == nil {
+		return len(rawJSON(log))
+	}
+	state := block.state.Load()
+	size := len(state.data) + len(state.plain) + len(state.attrs.others)*32 + len(state.correlation)*2
+	size += len(state.attrs.text) + len(state.attrs.textEnds)*4
+	for _, s := range state.attrs.strings {
+		size += 16 + len(s)
+	}
+	return size/len(state.ends) + 5 + 2*(int(nameFields)+int(textFields)) + 2*len(state.attrs.keys)
+}
+
+// blockCache - распакованные блоки, использованные последними: при просмотре
+// записей подряд каждый блок распаковывается один раз
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // *rawBlock, от недавно использованных
+	blocks   map[*rawBlock]*list.Element
+	plain    map[*rawBlock][]byte
+}
+
+var rawBlocks = &blockCache{
+	capacity: rawBlockCacheSize,
+	order:    list.New(),
+	blocks:   make(map[*rawBlock]*list.Element),
+	plain:    make(map[*rawBlock][]byte),
+}
+
+// get - распакованные строки блока; data - сжатые строки из его состояния
+func (c *blockCache) get(block *rawBlock, data []byte) []byte {
+	c.mu.Lock()
+	if element, cached := c.blocks[block]; cached {
+		c.order.MoveToFront(element)
+		plain := c.plain[block]
+		c.mu.Unlock()
+		return plain
+	}
+	c.mu.Unlock()
+
+	plain, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, cached := c.blocks[block]; !cached {
+		c.blocks[block] = c.order.PushFront(block)
+		c.plain[block] = plain
+		for c.order.Len() > c.capacity {
+			oldest := c.order.Remove(c.order.Back()).(*rawBlock)
+			delete(c.blocks, oldest)
+			delete(c.plain, oldest)
+		}
+	}
+	return plain
+}
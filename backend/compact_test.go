@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// traceLines - строки трассировки провайдера: десятки разных модулей, caller
+// и провайдеров на тысячи записей, как в больших сессиях
+func traceLines(n int) []string {
+	rpcs := []string{"ReadResource", "PlanResourceChange", "ApplyResourceChange"}
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`{"@level":"trace","@message":"HTTP Request Sent: GET /v1/instances/i-%06d","@module":"provider.terraform-provider-aws","@caller":"github.com/hashicorp/aws-sdk-go-base/v2/logging/tf_logger.go:%d","@timestamp":"2025-09-09T10:%02d:%02d.%06dZ","tf_provider_addr":"registry.terraform.io/hashicorp/aws","tf_req_id":"req-%04d","tf_rpc":"%s","tf_resource_type":"aws_instance","tf_http_trans_id":"trans-%06d","tf_http_req_method":"GET"}`,
+			i%5000, 40+i%7, i/3600%60, i/60%60, i%1000000, i/40, rpcs[i%len(rpcs)], i/2)
+	}
+	return lines
+}
+
+// appendLines - строки в хранилище пакетами по batch, как при потоковом приеме
+func appendLines(t testing.TB, store LogStore, lines []string, batch int) {
+	t.Helper()
+	for start := 0; start < len(lines); start += batch {
+		end := min(start+batch, len(lines))
+		result := NewLogParser().ParseStream(strings.NewReader(strings.Join(lines[start:end], "\n")))
+		if len(result.Errors) > 0 {
+			t.Fatalf("ошибка разбора: %v", result.Errors[0].Error)
+		}
+		if _, err := store.Append(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompactRawJSONRoundTrip(t *testing.T) {
+	lines := traceLines(3*rawBlockLines + 17)
+	store := NewMemoryStore()
+
+	// Снимок, взятый при открытом блоке, не меняется при дописывании в этот блок
+	appendLines(t, store, lines[:5], 1)
+	early := store.Snapshot()
+	for _, batch := range []int{1, 7, rawBlockLines + 3} {
+		appendLines(t, store, lines[store.Usage().Entries:store.Usage().Entries+batch], batch)
+	}
+	appendLines(t, store, lines[store.Usage().Entries:], 3)
+
+	for i := range early.Logs {
+		if got := rawJSON(&early.Logs[i]); got != lines[i] {
+			t.Fatalf("снимок до дописывания, запись %d: %s", i, got)
+		}
+	}
+	logs := store.Snapshot().Logs
+	if len(logs) != len(lines) {
+		t.Fatalf("записей %d, ожидалось %d", len(logs), len(lines))
+	}
+	blocks := make(map[*rawBlock]int)
+	for i := range logs {
+		log := &logs[i]
+		if got := rawJSON(log); got != lines[i] {
+			t.Fatalf("запись %d: %s", i, got)
+		}
+		attrs := logAttributes(*log)
+		if attrs["@caller"] != log.Caller() || attrs["tf_rpc"] != log.TfRPC() || attrs["tf_req_id"] != log.TfReqID() {
+			t.Fatalf("запись %d: атрибуты %v не совпадают с полями", i, attrs)
+		}
+		if log.Level() != "trace" || log.Module() != "provider.terraform-provider-aws" || log.TfProviderAddr() != "registry.terraform.io/hashicorp/aws" {
+			t.Fatalf("запись %d: поля-имена %q %q %q", i, log.Level(), log.Module(), log.TfProviderAddr())
+		}
+		blocks[log.raw.block]++
+	}
+
+	// Загрузки по одной строке не дробят блоки: все, кроме открытого, полные
+	if len(blocks) != 4 {
+		t.Errorf("блоков %d, ожидалось 4", len(blocks))
+	}
+	for block, count := range blocks {
+		state := block.state.Load()
+		sealed := state.data != nil
+		if count == rawBlockLines != sealed || len(state.ends) != count {
+			t.Errorf("блок на %d записей (строк %d), сжат: %v", count, len(state.ends), sealed)
+		}
+	}
+
+	// Записи, выгруженные в JSON, читаются обратно с теми же полями
+	data, err := logs[3].MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded TerraformLog
+	if err := decoded.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Caller() != logs[3].Caller() || decoded.EntryType() != logs[3].EntryType() || decoded.Message() != logs[3].Message() || rawJSON(&decoded) != "" {
+		t.Errorf("JSON записи: %s", data)
+	}
+}
+
+func TestCompactAttributesMatchRawJSON(t *testing.T) {
+	lines := []string{
+		`{"@level":"info","@message":"start","@timestamp":"2025-09-09T15:31:32.757289+03:00"}`,
+		`{"@level":"debug","@message":"read","@module":"provider","@timestamp":"2025-09-09T15:31:33.000001+03:00","tf_rpc":"ReadResource","tf_req_id":"r1"}`,
+		`{"@level":"debug","@message":"same","@timestamp":"2025-09-09T12:31:34Z","tf_rpc":"ReadResource","tf_req_id":""}`,
+		`{"@level":5,"@message":"other","@timestamp":"2025-09-09T15:31:35.000000+03:00","tf_rpc":"PlanResourceChange"}`,
+		`{"@level":"warn","@timestamp":"2025-09-09T15:31:36.100000+03:00","tf_req_id":"r2","@caller":"main.go:1"}`,
+	}
+	store := NewMemoryStore()
+	appendLines(t, store, lines, len(lines))
+	logs := store.Snapshot().Logs
+	if len(logs) != len(lines) {
+		t.Fatalf("записей %d, ожидалось %d", len(logs), len(lines))
+	}
+	for i := range logs {
+		var want map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &want); err != nil {
+			t.Fatal(err)
+		}
+		// Время, пересчитанное в другой пояс, не меняет @timestamp
+		moved := logs[i]
+		moved.Timestamp = moved.Timestamp.UTC()
+		for _, log := range []TerraformLog{logs[i], moved} {
+			if got := logAttributes(log); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("запись %d: атрибуты %v, ожидалось %v", i, got, want)
+			}
+		}
+	}
+}
+
+// heapInUse - объем живых объектов кучи после сборки мусора
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// baselineLog - запись в раскладке до компактного хранения: 11 строк без
+// карты атрибутов
+type baselineLog struct {
+	Level          string
+	Message        string
+	Module         string
+	Caller         string
+	Timestamp      time.Time
+	TfReqID        string
+	TfRPC          string
+	TfProtoVersion string
+	TfProviderAddr string
+	EntryType      string
+	RawJSON        string
+}
+
+// parseBaseline - разбор строк так, как их разбирал парсер до компактного
+// хранения: поля из карты JSON, исходная строка целиком
+func parseBaseline(lines []string) []baselineLog {
+	var logs []baselineLog
+	for _, line := range lines {
+		line = strings.Clone(line) // bufio.Scanner.Text
+		var raw map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			continue
+		}
+		entry := baselineLog{
+			Level:          getString(raw, "@level"),
+			Message:        getString(raw, "@message"),
+			Module:         getString(raw, "@module"),
+			Caller:         getString(raw, "@caller"),
+			TfReqID:        getString(raw, "tf_req_id"),
+			TfRPC:          getString(raw, "tf_rpc"),
+			TfProtoVersion: getString(raw, "tf_proto_version"),
+			TfProviderAddr: getString(raw, "tf_provider_addr"),
+			EntryType:      "provider",
+			RawJSON:        line,
+		}
+		entry.Timestamp, _ = time.Parse(time.RFC3339, getString(raw, "@timestamp"))
+		logs = append(logs, entry)
+	}
+	return logs
+}
+
+// BenchmarkStoreHeap - куча на запись в хранилище и в исходной раскладке
+// записей (baselineLog); x-smaller - во сколько раз хранилище компактнее
+func BenchmarkStoreHeap(b *testing.B) {
+	lines := traceLines(20000)
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		baseline := parseBaseline(lines)
+		plain := heapInUse() - before
+		runtime.KeepAlive(baseline)
+		baseline = nil
+
+		before = heapInUse()
+		store := NewMemoryStore()
+		appendLines(b, store, lines, 10)
+		compact := heapInUse() - before
+		runtime.KeepAlive(store)
+
+		b.ReportMetric(float64(plain)/float64(len(lines)), "baseline-B/entry")
+		b.ReportMetric(float64(compact)/float64(len(lines)), "heap-B/entry")
+		b.ReportMetric(float64(plain)/float64(compact), "x-smaller")
+	}
+}
+
+func BenchmarkFilterLogs(b *testing.B) {
+	store := NewMemoryStore()
+	appendLines(b, store, traceLines(20000), 500)
+	logs := store.Snapshot().Logs
+	filter, err := parseLogFilter(url.Values{"level": {"trace"}, "module": {"provider.*"}, "search": {"i-0042"}}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filterLogs(logs, filter)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConcurrencyPoint - число операций в работе начиная с момента Time
+type ConcurrencyPoint struct {
+	Time      time.Time
+	Resources int
+	RPCs      int
+	HTTP      int
+}
+
+// ConcurrencyPeak - пиковая параллельность по одному виду операций
+type ConcurrencyPeak struct {
+	Max         int
+	TimeAtMaxMs int64 // сколько времени держался пик
+}
+
+// maxConcurrencyBuckets - ограничение числа интервалов в ответе: без него
+// step=1ns на логе длиной в час дал бы триллионы точек
+const maxConcurrencyBuckets = 10000
+
+// spanEvent - начало (+1) или конец (-1) операции
+type spanEvent struct {
+	at    time.Time
+	kind  string
+	delta int
+}
+
+// collectOperationSpans - все восстановленные интервалы: ресурсы, RPC провайдеров, HTTP вызовы
+func collectOperationSpans(logs []TerraformLog) []OperationSpan {
+	spans := buildTimeline(logs).Spans
+	spans = append(spans, collectRPCSpans(logs)...)
+	spans = append(spans, collectHTTPSpans(logs)...)
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+	return spans
+}
+
+// collectRPCSpans - интервалы gRPC вызовов провайдера, сгруппированные по tf_req_id
+func collectRPCSpans(logs []TerraformLog) []OperationSpan {
+	groups := make(map[string]*OperationSpan)
+	var order []string
+
+	for _, log := range logs {
+		if log.TfReqID() == "" {
+			continue
+		}
+
+		span, exists := groups[log.TfReqID()]
+		if !exists {
+			resource := getString(logAttributes(log), "tf_resource_type")
+			if resource == "" {
+				resource = log.TfProviderAddr()
+			}
+			span = &OperationSpan{
+				Kind:     "rpc",
+				Resource: resource,
+				Start:    log.Timestamp,
+				End:      log.Timestamp,
+				Outcome:  "success",
+				Source:   "tf_req_id",
+			}
+			groups[log.TfReqID()] = span
+			order = append(order, log.TfReqID())
+		}
+
+		extendSpan(span, log)
+		if span.Operation == "" {
+			span.Operation = log.TfRPC()
+		}
+		if strings.EqualFold(log.Level(), "error") {
+			span.Outcome = "error"
+		}
+	}
+
+	return flattenSpans(groups, order)
+}
+
+// collectHTTPSpans - интервалы HTTP вызовов SDK провайдера, сгруппированные по tf_http_trans_id
+func collectHTTPSpans(logs []TerraformLog) []OperationSpan {
+	groups := make(map[string]*OperationSpan)
+	var order []string
+
+	for _, log := range logs {
+		if !strings.Contains(rawJSON(&log), "tf_http_trans_id") {
+			continue
+		}
+		attrs := logAttributes(log)
+		transID := getString(attrs, "tf_http_trans_id")
+		if transID == "" {
+			continue
+		}
+
+		span, exists := groups[transID]
+		if !exists {
+			span = &OperationSpan{
+				Kind:     "http",
+				Resource: log.TfProviderAddr(),
+				Start:    log.Timestamp,
+				End:      log.Timestamp,
+				Outcome:  "success",
+				Source:   "tf_http_trans_id",
+			}
+			groups[transID] = span
+			order = append(order, transID)
+		}
+
+		extendSpan(span, log)
+		if method := getString(attrs, "tf_http_req_method"); method != "" && span.Operation == "" {
+			span.Operation = strings.TrimSpace(method + " " + getString(attrs, "tf_http_req_uri"))
+		}
+		if status, ok := attrs["tf_http_res_status_code"].(float64); ok && status >= 400 {
+			span.Outcome = "error"
+		}
+	}
+
+	return flattenSpans(groups, order)
+}
+
+// extendSpan - расширение интервала до времени очередной записи группы
+func extendSpan(span *OperationSpan, log TerraformLog) {
+	if log.Timestamp.IsZero() {
+		return
+	}
+	if span.Start.IsZero() || log.Timestamp.Before(span.Start) {
+		span.Start = log.Timestamp
+	}
+	if log.Timestamp.After(span.End) {
+		span.End = log.Timestamp
+	}
+	span.DurationMs = span.End.Sub(span.Start).Milliseconds()
+}
+
+func flattenSpans(groups map[string]*OperationSpan, order []string) []OperationSpan {
+	spans := make([]OperationSpan, 0, len(order))
+	for _, key := range order {
+		spans = append(spans, *groups[key])
+	}
+	return spans
+}
+
+// logAttributes - все поля исходной JSON строки записи
+func logAttributes(log TerraformLog) map[string]interface{} {
+	if attrs, ok := log.raw.attributes(log.Timestamp); ok {
+		return attrs
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal([]byte(rawJSON(&log)), &attrs); err != nil {
+		return nil
+	}
+	return attrs
+}
+
+// concurrencySeries - ступенчатый ряд: сколько операций каждого вида было в работе
+func concurrencySeries(spans []OperationSpan) []ConcurrencyPoint {
+	events := make([]spanEvent, 0, len(spans)*2)
+	for _, span := range spans {
+		events = append(events,
+			spanEvent{at: span.Start, kind: span.Kind, delta: 1},
+			spanEvent{at: span.End, kind: span.Kind, delta: -1},
+		)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].at.Before(events[j].at)
+	})
+
+	var series []ConcurrencyPoint
+	var current ConcurrencyPoint
+
+	for i := 0; i < len(events); {
+		at := events[i].at
+		// Все события одного момента применяем вместе
+		for ; i < len(events) && events[i].at.Equal(at); i++ {
+			switch events[i].kind {
+			case "resource":
+				current.Resources += events[i].delta
+			case "rpc":
+				current.RPCs += events[i].delta
+			case "http":
+				current.HTTP += events[i].delta
+			}
+		}
+		current.Time = at
+		series = append(series, current)
+	}
+
+	return series
+}
+
+// bucketSeries - максимум параллельности в каждом интервале длиной step
+func bucketSeries(series []ConcurrencyPoint, step time.Duration) []ConcurrencyPoint {
+	if len(series) == 0 || step <= 0 {
+		return series
+	}
+
+	start := series[0].Time
+	end := series[len(series)-1].Time
+	var buckets []ConcurrencyPoint
+	var level ConcurrencyPoint // значение, действующее на начало интервала
+	i := 0
+
+	for bucketStart := start; !bucketStart.After(end); bucketStart = bucketStart.Add(step) {
+		bucketEnd := bucketStart.Add(step)
+		bucket := ConcurrencyPoint{Time: bucketStart, Resources: level.Resources, RPCs: level.RPCs, HTTP: level.HTTP}
+
+		for ; i < len(series) && series[i].Time.Before(bucketEnd); i++ {
+			level = series[i]
+			bucket.Resources = maxInt(bucket.Resources, level.Resources)
+			bucket.RPCs = maxInt(bucket.RPCs, level.RPCs)
+			bucket.HTTP = maxInt(bucket.HTTP, level.HTTP)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets
+}
+
+// concurrencyPeaks - пики параллельности и время, проведенное на пике
+func concurrencyPeaks(series []ConcurrencyPoint) map[string]ConcurrencyPeak {
+	values := map[string]func(ConcurrencyPoint) int{
+		"resources": func(p ConcurrencyPoint) int { return p.Resources },
+		"rpcs":      func(p ConcurrencyPoint) int { return p.RPCs },
+		"http":      func(p ConcurrencyPoint) int { return p.HTTP },
+	}
+
+	peaks := make(map[string]ConcurrencyPeak)
+	for name, value := range values {
+		var peak ConcurrencyPeak
+		for i, point := range series {
+			v := value(point)
+			if v > peak.Max {
+				peak = ConcurrencyPeak{Max: v}
+			}
+			if v == peak.Max && v > 0 && i+1 < len(series) {
+				peak.TimeAtMaxMs += series[i+1].Time.Sub(point.Time).Milliseconds()
+			}
+		}
+		peaks[name] = peak
+	}
+	return peaks
+}
+
+// spansActiveAt - операции, выполнявшиеся в момент t
+func spansActiveAt(spans []OperationSpan, t time.Time) []OperationSpan {
+	active := []OperationSpan{}
+	for _, span := range spans {
+		if !span.Start.After(t) && !span.End.Before(t) {
+			active = append(active, span)
+		}
+	}
+	return active
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Обработчик API для параллельности операций во времени
+func handleAPIConcurrency(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	store, ok := requestStore(w, r)
+	if !ok {
+		return
+	}
+	snapshot := store.Snapshot()
+	if snapshot == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "no_data",
+			"message": "Нет данных логов",
+			"series":  []interface{}{},
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	ctx, err := newTimeContext(snapshot.Logs, query.Get("tz"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	spans := collectOperationSpans(snapshot.Logs)
+	series := concurrencySeries(spans)
+	peaks := concurrencyPeaks(series)
+
+	if stepStr := query.Get("step"); stepStr != "" {
+		step, err := time.ParseDuration(stepStr)
+		if err != nil || step <= 0 {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "неверный шаг: "+stepStr), http.StatusBadRequest)
+			return
+		}
+		if len(series) > 0 && series[len(series)-1].Time.Sub(series[0].Time)/step >= maxConcurrencyBuckets {
+			message := fmt.Sprintf("шаг %s слишком мал: больше %d интервалов", stepStr, maxConcurrencyBuckets)
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, message), http.StatusBadRequest)
+			return
+		}
+		series = bucketSeries(series, step)
+	}
+	if series == nil {
+		series = []ConcurrencyPoint{}
+	}
+	for i := range series {
+		series[i].Time = ctx.render(series[i].Time)
+	}
+
+	response := map[string]interface{}{
+		"status": "success",
+		"series": series,
+		"peaks":  peaks,
+		"spans":  len(spans),
+	}
+
+	if atStr := query.Get("at"); atStr != "" {
+		at, err := ctx.parse(atStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		active := spansActiveAt(spans, at)
+		ctx.renderSpans(active)
+		response["at"] = ctx.render(at)
+		response["active"] = active
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
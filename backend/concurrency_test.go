@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestConcurrencyAPI(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", timelineSample, nil)
+
+	var response struct {
+		Series []ConcurrencyPoint         `json:"series"`
+		Peaks  map[string]ConcurrencyPeak `json:"peaks"`
+		Active []OperationSpan            `json:"active"`
+	}
+	if code := serveJSON(t, server, "GET", "/api/concurrency?at=2025-09-09T10:00:00.7Z", "", &response); code != http.StatusOK {
+		t.Fatalf("код %d", code)
+	}
+	if peak := response.Peaks["resources"]; peak.Max != 2 || peak.TimeAtMaxMs != 500 {
+		t.Errorf("пик ресурсов %+v, ожидалось 2 в течение 500 мс", peak)
+	}
+	if len(response.Active) != 2 {
+		t.Errorf("в работе %d операций, ожидалось 2: %+v", len(response.Active), response.Active)
+	}
+
+	tests := []struct {
+		step    string
+		code    int
+		buckets int
+	}{
+		{"1s", http.StatusOK, 6},
+		{"2s", http.StatusOK, 3},
+		{"1ms", http.StatusOK, 5001},
+		{"100us", http.StatusBadRequest, 0},
+		{"1ns", http.StatusBadRequest, 0},
+		{"0s", http.StatusBadRequest, 0},
+		{"-1s", http.StatusBadRequest, 0},
+		{"часто", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		var bucketed struct {
+			Series []ConcurrencyPoint `json:"series"`
+		}
+		code := serveJSON(t, server, "GET", "/api/concurrency?step="+tt.step, "", &bucketed)
+		if code != tt.code || len(bucketed.Series) != tt.buckets {
+			t.Errorf("step=%s: код %d, интервалов %d, ожидалось %d и %d", tt.step, code, len(bucketed.Series), tt.code, tt.buckets)
+		}
+	}
+}
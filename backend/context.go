@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultContextLines = 25
+	maxContextLines     = 1000
+)
+
+// contextOptions - сколько записей показывать вокруг найденной, как grep -B/-A/-C
+type contextOptions struct {
+	before int
+	after  int
+	around int    // -C: значение по умолчанию для before и after
+	same   string // "", tf_req_id или provider
+	flags  *flag.FlagSet
+}
+
+// registerContextFlags - флаги -C/-B/-A и --context-same для командной строки
+func registerContextFlags(fs *flag.FlagSet) *contextOptions {
+	opts := &contextOptions{flags: fs}
+
+	fs.IntVar(&opts.around, "C", 0, "записей контекста до и после каждого совпадения")
+	fs.IntVar(&opts.around, "context", 0, "записей контекста до и после каждого совпадения")
+	fs.IntVar(&opts.before, "B", 0, "записей контекста до совпадения")
+	fs.IntVar(&opts.before, "before-context", 0, "записей контекста до совпадения")
+	fs.IntVar(&opts.after, "A", 0, "записей контекста после совпадения")
+	fs.IntVar(&opts.after, "after-context", 0, "записей контекста после совпадения")
+	fs.StringVar(&opts.same, "context-same", "", "контекст только из записей с тем же tf_req_id или provider")
+
+	return opts
+}
+
+// enabled - запрошен ли вывод контекста; -C задает -B и -A, если они не указаны
+// явно, как в grep: явный -B 0 с -C 5 оставляет контекст только после совпадения
+func (opts *contextOptions) enabled() bool {
+	set := make(map[string]bool)
+	opts.flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["B"] && !set["before-context"] {
+		opts.before = opts.around
+	}
+	if !set["A"] && !set["after-context"] {
+		opts.after = opts.around
+	}
+	return opts.before > 0 || opts.after > 0
+}
+
+// sameContextMatcher - условие сужения контекста до записей, связанных с исходной
+func sameContextMatcher(entry *TerraformLog, same string) (func(*TerraformLog) bool, error) {
+	switch strings.ToLower(same) {
+	case "":
+		return func(*TerraformLog) bool { return true }, nil
+	case "tf_req_id", "request":
+		reqID := entry.TfReqID()
+		return func(log *TerraformLog) bool { return reqID != "" && log.TfReqID() == reqID }, nil
+	case "provider", "tf_provider_addr":
+		provider := entry.TfProviderAddr()
+		return func(log *TerraformLog) bool { return provider != "" && log.TfProviderAddr() == provider }, nil
+	}
+	return nil, fmt.Errorf("неизвестное значение same: %s (ожидается tf_req_id или provider)", same)
+}
+
+// logContext - записи до и после записи с индексом idx в нефильтрованном потоке
+func logContext(logs []TerraformLog, idx, before, after int, match func(*TerraformLog) bool) ([]TerraformLog, []TerraformLog) {
+	var beforeLogs []TerraformLog
+	for i := idx - 1; i >= 0 && len(beforeLogs) < before; i-- {
+		if match(&logs[i]) {
+			beforeLogs = append(beforeLogs, logs[i])
+		}
+	}
+	// Собирали в обратном порядке
+	for i, j := 0, len(beforeLogs)-1; i < j; i, j = i+1, j-1 {
+		beforeLogs[i], beforeLogs[j] = beforeLogs[j], beforeLogs[i]
+	}
+
+	var afterLogs []TerraformLog
+	for i := idx + 1; i < len(logs) && len(afterLogs) < after; i++ {
+		if match(&logs[i]) {
+			afterLogs = append(afterLogs, logs[i])
+		}
+	}
+
+	return beforeLogs, afterLogs
+}
+
+// findLogIndex - индекс записи с заданным ID; записи упорядочены по возрастанию ID
+func findLogIndex(logs []TerraformLog, id int) int {
+	idx := sort.Search(len(logs), func(i int) bool {
+		return logs[i].ID >= id
+	})
+	if idx < len(logs) && logs[idx].ID == id {
+		return idx
+	}
+	return -1
+}
+
+// contextCount - число записей контекста из параметра запроса
+func contextCount(value string) (int, error) {
+	if value == "" {
+		return defaultContextLines, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("неверное число записей: %s", value)
+	}
+	if n > maxContextLines {
+		n = maxContextLines
+	}
+	return n, nil
+}
+
+// Обработчик API для контекста вокруг записи: GET /api/logs/{id}/context?before=&after=&same=
+func handleAPILogContext(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := requestStore(w, r)
+	if !ok {
+		return
+	}
+	snapshot := store.Snapshot()
+	if snapshot == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "no_data",
+			"message": "Нет данных логов",
+			"logs":    []interface{}{},
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error": "Неверный идентификатор записи"}`, http.StatusBadRequest)
+		return
+	}
+	idx := findLogIndex(snapshot.Logs, id)
+	if idx < 0 {
+		http.Error(w, `{"error": "Запись не найдена"}`, http.StatusNotFound)
+		return
+	}
+
+	before, err := contextCount(query.Get("before"))
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	after, err := contextCount(query.Get("after"))
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	entry := snapshot.Logs[idx]
+	match, err := sameContextMatcher(&entry, query.Get("same"))
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	ctx, err := newTimeContext(snapshot.Logs, query.Get("tz"))
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	beforeLogs, afterLogs := logContext(snapshot.Logs, idx, before, after, match)
+	logs := make([]TerraformLog, 0, len(beforeLogs)+1+len(afterLogs))
+	logs = append(logs, beforeLogs...)
+	logs = append(logs, entry)
+	logs = append(logs, afterLogs...)
+	ctx.renderLogs(logs)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"anchor_id": entry.ID,
+		"before":    len(beforeLogs),
+		"after":     len(afterLogs),
+		"same":      query.Get("same"),
+		"logs":      projectLogs(logs, query.Get("fields")),
+		"count":     len(logs),
+	})
+}
+
+// printContextMatches - вывод совпадений с контекстом в стиле grep:
+// совпадения помечаются ':', контекст '-', несмежные группы разделяются "--"
+func printContextMatches(logs, matches []TerraformLog, opts contextOptions) error {
+	matched := make(map[int]bool, len(matches))
+	for _, log := range matches {
+		matched[log.ID] = true
+	}
+
+	printed := make(map[int]bool)
+	lastPrinted := -1
+
+	for _, m := range matches {
+		idx := findLogIndex(logs, m.ID)
+		if idx < 0 {
+			continue
+		}
+		match, err := sameContextMatcher(&logs[idx], opts.same)
+		if err != nil {
+			return err
+		}
+		beforeLogs, afterLogs := logContext(logs, idx, opts.before, opts.after, match)
+
+		group := append(append(beforeLogs, logs[idx]), afterLogs...)
+		for _, log := range group {
+			if printed[log.ID] {
+				continue
+			}
+			if lastPrinted >= 0 && log.ID != lastPrinted+1 {
+				fmt.Println("--")
+			}
+			marker := "-"
+			if matched[log.ID] {
+				marker = ":"
+			}
+			fmt.Printf("%d%s %s %s: %s\n", log.ID, marker, log.Timestamp.Format("15:04:05.000"), log.Level(), log.Message())
+			printed[log.ID] = true
+			lastPrinted = log.ID
+		}
+	}
+
+	fmt.Printf("\nСовпадений: %d\n", len(matches))
+	return nil
+}
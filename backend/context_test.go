@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// contextSample - записи 1, 3 и 5 относятся к одному запросу провайдера
+const contextSample = `{"@level":"info","@message":"start","@module":"terraform"}
+{"@level":"debug","@message":"rpc start","@module":"provider","tf_req_id":"r1","tf_provider_addr":"registry.terraform.io/hashicorp/aws"}
+{"@level":"info","@message":"core","@module":"terraform"}
+{"@level":"error","@message":"rpc failed","@module":"provider","tf_req_id":"r1","tf_provider_addr":"registry.terraform.io/hashicorp/aws"}
+{"@level":"info","@message":"core","@module":"terraform"}
+{"@level":"debug","@message":"rpc end","@module":"provider","tf_req_id":"r1","tf_provider_addr":"registry.terraform.io/hashicorp/aws"}
+`
+
+func TestLogContextAPI(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", contextSample, nil)
+
+	tests := []struct {
+		query string
+		code  int
+		ids   []int
+	}{
+		{"3/context?before=1&after=1", http.StatusOK, []int{2, 3, 4}},
+		{"3/context?before=0&after=0", http.StatusOK, []int{3}},
+		{"3/context", http.StatusOK, []int{0, 1, 2, 3, 4, 5}},
+		{"0/context?after=2", http.StatusOK, []int{0, 1, 2}},
+		{"3/context?same=tf_req_id&before=1&after=5", http.StatusOK, []int{1, 3, 5}},
+		{"3/context?same=provider", http.StatusOK, []int{1, 3, 5}},
+		{"2/context?same=tf_req_id", http.StatusOK, []int{2}},
+		{"3/context?before=100000", http.StatusOK, []int{0, 1, 2, 3, 4, 5}},
+		{"3/context?same=module", http.StatusBadRequest, nil},
+		{"3/context?before=-1", http.StatusBadRequest, nil},
+		{"3/context?after=many", http.StatusBadRequest, nil},
+		{"99/context", http.StatusNotFound, nil},
+		{"x/context", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		var response struct {
+			AnchorID int            `json:"anchor_id"`
+			Logs     []TerraformLog `json:"logs"`
+		}
+		code := serveJSON(t, server, "GET", "/api/logs/"+tt.query, "", &response)
+		var ids []int
+		for _, entry := range response.Logs {
+			ids = append(ids, entry.ID)
+		}
+		if code != tt.code || !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: код %d, записи %v, ожидались %d и %v", tt.query, code, ids, tt.code, tt.ids)
+		}
+	}
+}
+
+func TestContextFlags(t *testing.T) {
+	tests := []struct {
+		args          []string
+		before, after int
+		enabled       bool
+	}{
+		{[]string{"-C", "2"}, 2, 2, true},
+		{[]string{"-C", "3", "-B", "0"}, 0, 3, true},
+		{[]string{"-B", "2", "-C", "5"}, 2, 5, true},
+		{[]string{"--context", "4", "--after-context", "1"}, 4, 1, true},
+		{[]string{"-C", "3", "-A", "0", "-B", "0"}, 0, 0, false},
+		{nil, 0, 0, false},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts := registerContextFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if enabled := opts.enabled(); enabled != tt.enabled || opts.before != tt.before || opts.after != tt.after {
+			t.Errorf("%v: -B %d -A %d (%v), ожидалось -B %d -A %d", tt.args, opts.before, opts.after, enabled, tt.before, tt.after)
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskBackend - хранение сессий в каталоге на диске, без внешних сервисов:
+//
+//	<dir>/sessions/<id>/session.json  - описание сессии
+//	<dir>/sessions/<id>/manifest.json - список сегментов с данными сессии
+//	<dir>/sessions/<id>/seg-<n>.json  - записи, ошибки, статистика и производные данные записей
+//	<dir>/sessions/<id>/annotations.json - пометки записей
+//
+// Каждый файл пишется во временный и переименовывается, а новый сегмент
+// становится видимым только после записи манифеста. Сбой посреди записи
+// оставляет сессию в последнем целостном состоянии.
+//
+// Загрузки копятся в памяти и пишутся одним сегментом не реже раза в
+// diskFlushInterval или по накоплении diskFlushEntries записей, а соседние
+// сегменты сливаются, пока их не станет O(log n). При сбое теряются только
+// записи, еще не сброшенные на диск.
+type diskBackend struct {
+	dir string
+}
+
+const (
+	// diskFlushEntries - столько отложенных записей Append сохраняет сразу
+	diskFlushEntries = 4096
+	// diskFlushInterval - наибольшая задержка записи загрузок на диск
+	diskFlushInterval = time.Second
+	// maxSegmentEntries - сегменты больше этого не сливаются
+	maxSegmentEntries = 1 << 16
+)
+
+// diskStore - хранилище сессии: данные в памяти, загрузки сохраняются на диск
+// пакетами, замена и очистка содержимого - сразу
+type diskStore struct {
+	dir      string
+	mu       sync.Mutex // упорядочивает запись на диск
+	mem      *memoryStore
+	manifest segmentManifest
+	pending  segmentData // загрузки, еще не записанные на диск
+	timer    *time.Timer // отложенный Flush, см. diskFlushInterval
+}
+
+type segmentManifest struct {
+	Segments    []segmentRef
+	NextSegment int
+	BaseID      int // ID первой записи первого сегмента
+	Evicted     int // записи в начале первого сегмента, вытесненные ограничениями
+}
+
+type segmentRef struct {
+	Name    string
+	Entries int
+}
+
+// segmentData - содержимое сегмента; ID записей отсчитываются от начала сегмента,
+// при чтении они назначаются заново тем же Append, что и при приеме
+type segmentData struct {
+	Logs   []TerraformLog
+	Errors []storedError
+	Stats  ParseStats
+	Index  *entryIndex `json:",omitempty"` // нет в сегментах прежних версий
+}
+
+// storedError - ParseError в виде, пригодном для JSON
+type storedError struct {
+	LineNumber int
+	Line       string
+	Message    string
+}
+
+func NewDiskBackend(dir string) (*diskBackend, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "sessions"), 0o755); err != nil {
+		return nil, fmt.Errorf("не удалось создать каталог данных: %v", err)
+	}
+	return &diskBackend{dir: dir}, nil
+}
+
+func (b *diskBackend) sessionDir(id string) string {
+	return filepath.Join(b.dir, "sessions", id)
+}
+
+func (b *diskBackend) Open(id string) (LogStore, error) {
+	return openDiskStore(b.sessionDir(id))
+}
+
+func (b *diskBackend) SaveSession(session Session) error {
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(b.sessionDir(session.ID), "session.json"), data)
+}
+
+// LoadSessions - сессии, сохраненные при прошлых запусках. Каталоги без
+// session.json (создание прервано сбоем) пропускаются.
+func (b *diskBackend) LoadSessions() ([]Session, error) {
+	entries, err := os.ReadDir(filepath.Join(b.dir, "sessions"))
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []Session
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(b.sessionDir(entry.Name()), "session.json"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil {
+			return nil, fmt.Errorf("сессия %s: %v", entry.Name(), err)
+		}
+		session.ID = entry.Name()
+		if session.Store, err = b.Open(session.ID); err != nil {
+			return nil, fmt.Errorf("сессия %s: %v", session.ID, err)
+		}
+		loaded = append(loaded, session)
+	}
+	return loaded, nil
+}
+
+// DeleteSession - каталог сначала переименовывается, чтобы прерванное
+// удаление не оставило наполовину удаленную сессию
+func (b *diskBackend) DeleteSession(id string) error {
+	trash := filepath.Join(b.dir, "sessions", ".deleted-"+id)
+	if err := os.RemoveAll(trash); err != nil {
+		return err
+	}
+	if err := os.Rename(b.sessionDir(id), trash); err != nil {
+		return err
+	}
+	return os.RemoveAll(trash)
+}
+
+func (b *diskBackend) LoadAnnotations(id string) ([]Annotation, error) {
+	data, err := os.ReadFile(filepath.Join(b.sessionDir(id), "annotations.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var annotations []Annotation
+	if err := json.Unmarshal(data, &annotations); err != nil {
+		return nil, fmt.Errorf("повреждены пометки: %v", err)
+	}
+	return annotations, nil
+}
+
+func (b *diskBackend) SaveAnnotations(id string, annotations []Annotation) error {
+	data, err := json.Marshal(annotations)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(b.sessionDir(id), "annotations.json"), data); err != nil {
+		return fmt.Errorf("не удалось сохранить пометки: %v", err)
+	}
+	return nil
+}
+
+func (b *diskBackend) LoadTraces(id string) ([]TraceSpan, error) {
+	data, err := os.ReadFile(filepath.Join(b.sessionDir(id), "traces.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var spans []TraceSpan
+	if err := json.Unmarshal(data, &spans); err != nil {
+		return nil, fmt.Errorf("повреждены спаны трассировки: %v", err)
+	}
+	return spans, nil
+}
+
+func (b *diskBackend) SaveTraces(id string, spans []TraceSpan) error {
+	data, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(b.sessionDir(id), "traces.json"), data); err != nil {
+		return fmt.Errorf("не удалось сохранить спаны трассировки: %v", err)
+	}
+	return nil
+}
+
+// openDiskStore - загрузка данных сессии по манифесту; временные файлы и
+// сегменты, не попавшие в манифест, остались от прерванных записей и удаляются
+func openDiskStore(dir string) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	store := &diskStore{dir: dir, mem: NewMemoryStore()}
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &store.manifest); err != nil {
+			return nil, fmt.Errorf("поврежден манифест: %v", err)
+		}
+	}
+
+	// Записи получают те же ID, что и при приеме: отсчет начинается с BaseID
+	store.mem.nextID = store.manifest.BaseID
+	live := make(map[string]bool)
+	for _, ref := range store.manifest.Segments {
+		segment, err := readSegment(filepath.Join(dir, ref.Name))
+		if err != nil {
+			return nil, fmt.Errorf("сегмент %s: %v", ref.Name, err)
+		}
+		store.mem.appendIndexed(segment.result(), segment.Index)
+		live[ref.Name] = true
+	}
+	store.mem.evict(store.manifest.Evicted)
+	store.mem.evicted = 0
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".") || (strings.HasPrefix(name, "seg-") && !live[name]) {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+	return store, nil
+}
+
+// Append - запись сразу видна читателям, а на диск попадает со следующим
+// пакетом; ошибка записи пакета, начатой Append, возвращается вызывающему
+func (s *diskStore) Append(result ParseResult) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total, err := s.mem.Append(result)
+	if err != nil {
+		return 0, err
+	}
+	s.pending.append(newSegmentData(result, s.mem.indexTail(len(result.Logs))))
+	if len(s.pending.Logs) >= diskFlushEntries {
+		return total, s.flush()
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(diskFlushInterval, s.flushLater)
+	}
+	return total, nil
+}
+
+// Flush - запись отложенных загрузок на диск
+func (s *diskStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flushLater - Flush по таймеру; при ошибке загрузки остаются в очереди
+// и записываются со следующим пакетом
+func (s *diskStore) flushLater() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer = nil
+	if err := s.flush(); err != nil {
+		log.Printf("Сессия %s: %v", filepath.Base(s.dir), err)
+	}
+}
+
+// flush - отложенные загрузки пишутся новым сегментом, после чего хвостовые
+// сегменты сливаются, пока предыдущий не больше последнего (как разряды
+// двоичного счетчика): сегментов остается O(log n), а каждая запись
+// переписывается O(log n) раз. Вызывается под блокировкой.
+func (s *diskStore) flush() error {
+	s.stopTimer()
+	if s.pending.empty() {
+		return nil
+	}
+
+	manifest := s.manifest
+	manifest.Segments = slices.Clone(manifest.Segments)
+	written, obsolete, err := s.writeTail(&manifest)
+	if err == nil {
+		err = s.writeManifest(manifest)
+	}
+	if err != nil {
+		for _, name := range written {
+			os.Remove(filepath.Join(s.dir, name))
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			// Каталог удален вместе с сессией: сохранять больше некуда
+			s.pending = segmentData{}
+			return nil
+		}
+		return err
+	}
+	s.removeSegments(obsolete)
+	s.pending = segmentData{}
+	return nil
+}
+
+// writeTail - запись отложенного сегмента и слияние хвоста в manifest;
+// возвращает записанные файлы и сегменты, замененные слиянием
+func (s *diskStore) writeTail(manifest *segmentManifest) (written []string, obsolete []segmentRef, err error) {
+	name, err := s.writeSegment(manifest, s.pending)
+	if err != nil {
+		return written, nil, err
+	}
+	written = append(written, name)
+	manifest.Segments = append(manifest.Segments, segmentRef{Name: name, Entries: len(s.pending.Logs)})
+
+	for n := len(manifest.Segments); n >= 2; n = len(manifest.Segments) {
+		prev, last := manifest.Segments[n-2], manifest.Segments[n-1]
+		if prev.Entries > last.Entries || prev.Entries+last.Entries > maxSegmentEntries {
+			break
+		}
+		merged, err := readSegment(filepath.Join(s.dir, prev.Name))
+		if err != nil {
+			return written, nil, fmt.Errorf("сегмент %s: %w", prev.Name, err)
+		}
+		tail, err := readSegment(filepath.Join(s.dir, last.Name))
+		if err != nil {
+			return written, nil, fmt.Errorf("сегмент %s: %w", last.Name, err)
+		}
+		merged.append(tail)
+
+		name, err := s.writeSegment(manifest, merged)
+		if err != nil {
+			return written, nil, err
+		}
+		written = append(written, name)
+		manifest.Segments = append(manifest.Segments[:n-2], segmentRef{Name: name, Entries: len(merged.Logs)})
+		obsolete = append(obsolete, prev, last)
+	}
+	return written, obsolete, nil
+}
+
+// stopTimer - отмена отложенного Flush; вызывается под блокировкой
+func (s *diskStore) stopTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+func (s *diskStore) Replace(result ParseResult) error {
+	return s.Restore(result, 0)
+}
+
+func (s *diskStore) Restore(result ParseResult, firstID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Отложенные загрузки заменяются вместе с остальным содержимым
+	s.stopTimer()
+	s.pending = segmentData{}
+	manifest := segmentManifest{NextSegment: s.manifest.NextSegment, BaseID: firstID}
+	name, err := s.writeSegment(&manifest, newSegmentData(result, result.index))
+	if err != nil {
+		return err
+	}
+	old := s.manifest.Segments
+	manifest.Segments = []segmentRef{{Name: name, Entries: len(result.Logs)}}
+	if err := s.writeManifest(manifest); err != nil {
+		os.Remove(filepath.Join(s.dir, name))
+		return err
+	}
+	s.removeSegments(old)
+	return s.mem.Restore(result, firstID)
+}
+
+func (s *diskStore) Snapshot() *ParseResult {
+	return s.mem.Snapshot()
+}
+
+func (s *diskStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopTimer()
+	s.pending = segmentData{}
+	old := s.manifest.Segments
+	if err := s.writeManifest(segmentManifest{NextSegment: s.manifest.NextSegment}); err != nil {
+		return err
+	}
+	s.removeSegments(old)
+	return s.mem.Clear()
+}
+
+// Trim - вытесненные записи отмечаются в манифесте, а сегменты, вытесненные
+// целиком, удаляются; при загрузке вытеснение повторяется по манифесту
+func (s *diskStore) Trim(maxEntries int, maxBytes int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.mu.RLock()
+	n := s.mem.evictable(maxEntries, maxBytes)
+	s.mem.mu.RUnlock()
+	if n == 0 {
+		return 0, nil
+	}
+	// Манифест отсчитывает вытеснение от записей на диске
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+
+	manifest := s.manifest
+	manifest.Evicted += n
+	var dropped []segmentRef
+	for len(manifest.Segments) > 0 && manifest.Evicted >= manifest.Segments[0].Entries {
+		first := manifest.Segments[0]
+		manifest.Evicted -= first.Entries
+		manifest.BaseID += first.Entries
+		manifest.Segments = manifest.Segments[1:]
+		dropped = append(dropped, first)
+	}
+	if err := s.writeManifest(manifest); err != nil {
+		return 0, err
+	}
+	s.removeSegments(dropped)
+
+	s.mem.mu.Lock()
+	s.mem.evict(n)
+	s.mem.mu.Unlock()
+	return n, nil
+}
+
+func (s *diskStore) Usage() StoreUsage {
+	return s.mem.Usage()
+}
+
+func (s *diskStore) Watch() <-chan struct{} {
+	return s.mem.Watch()
+}
+
+// newSegmentData - результат разбора в виде сегмента
+func newSegmentData(result ParseResult, index *entryIndex) segmentData {
+	segment := segmentData{Logs: result.Logs, Stats: result.Stats, Index: index}
+	for _, parseErr := range result.Errors {
+		stored := storedError{LineNumber: parseErr.LineNumber, Line: parseErr.Line}
+		if parseErr.Error != nil {
+			stored.Message = parseErr.Error.Error()
+		}
+		segment.Errors = append(segment.Errors, stored)
+	}
+	return segment
+}
+
+// append - записи next после записей сегмента, ID next сдвигаются;
+// производные данные сохраняются, только если они есть у обоих
+func (segment *segmentData) append(next segmentData) {
+	switch {
+	case segment.empty():
+		segment.Index = next.Index
+	case segment.Index != nil && next.Index != nil:
+		segment.Index = &entryIndex{
+			Phases:      append(slices.Clip(segment.Index.Phases), next.Index.Phases...),
+			Correlation: append(slices.Clip(segment.Index.Correlation), next.Index.Correlation...),
+		}
+	default:
+		segment.Index = nil
+	}
+
+	offset := len(segment.Logs)
+	for _, entry := range next.Logs {
+		entry.ID += offset
+		segment.Logs = append(segment.Logs, entry)
+	}
+	segment.Errors = append(segment.Errors, next.Errors...)
+	if segment.Stats.ByLevel == nil || segment.Stats.ByModule == nil {
+		segment.Stats = newParseStats()
+	}
+	mergeStats(&segment.Stats, next.Stats)
+}
+
+func (segment *segmentData) empty() bool {
+	return len(segment.Logs) == 0 && len(segment.Errors) == 0 && segment.Stats.TotalLines == 0
+}
+
+// result - сегмент в виде результата разбора; атрибуты не сохраняются:
+// хранилище разбирает их из исходной строки по требованию
+func (segment *segmentData) result() ParseResult {
+	result := ParseResult{Logs: segment.Logs, Stats: segment.Stats}
+	for _, stored := range segment.Errors {
+		result.Errors = append(result.Errors, ParseError{
+			LineNumber: stored.LineNumber,
+			Line:       stored.Line,
+			Error:      errors.New(stored.Message),
+		})
+	}
+	return result
+}
+
+// writeSegment - запись сегмента под следующим номером manifest
+func (s *diskStore) writeSegment(manifest *segmentManifest, segment segmentData) (string, error) {
+	data, err := json.Marshal(segment)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("seg-%06d.json", manifest.NextSegment)
+	if err := writeFileAtomic(filepath.Join(s.dir, name), data); err != nil {
+		return "", fmt.Errorf("не удалось сохранить логи: %w", err)
+	}
+	manifest.NextSegment++
+	return name, nil
+}
+
+func (s *diskStore) writeManifest(manifest segmentManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, "manifest.json"), data); err != nil {
+		return fmt.Errorf("не удалось сохранить манифест: %v", err)
+	}
+	s.manifest = manifest
+	return nil
+}
+
+// removeSegments - удаление сегментов, уже исключенных из манифеста;
+// ошибка не страшна, остатки удалятся при следующей загрузке
+func (s *diskStore) removeSegments(refs []segmentRef) {
+	for _, ref := range refs {
+		os.Remove(filepath.Join(s.dir, ref.Name))
+	}
+}
+
+// readSegment - сегмент с диска; производные данные, не совпадающие
+// по числу записей, отбрасываются и вычисляются заново
+func readSegment(path string) (segmentData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return segmentData{}, err
+	}
+	var segment segmentData
+	if err := json.Unmarshal(data, &segment); err != nil {
+		return segmentData{}, err
+	}
+	if index := segment.Index; index != nil && (len(index.Phases) != len(segment.Logs) || len(index.Correlation) != len(segment.Logs)) {
+		segment.Index = nil
+	}
+	return segment, nil
+}
+
+// writeFileAtomic - запись через временный файл в том же каталоге и
+// переименование: читатель видит либо старое, либо новое содержимое целиком
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Переименование надежно только после синхронизации каталога
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
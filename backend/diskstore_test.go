@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskStoreReloadsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewDiskBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := NewSessionRegistry(backend, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := registry.Create("staging", "api", []string{"eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Store.Append(parseSample(t, 3))
+	session.Store.Append(parseSample(t, 2))
+	if _, err := registry.Update(session.ID, func(s *Session) { s.Name = "prod" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Остатки прерванной записи: временный файл и сегмент вне манифеста
+	sessionDir := backend.sessionDir(session.ID)
+	os.WriteFile(filepath.Join(sessionDir, ".seg-000002.json.tmp-1"), []byte("{"), 0o644)
+	os.WriteFile(filepath.Join(sessionDir, "seg-000009.json"), []byte("{"), 0o644)
+
+	reloaded, err := NewSessionRegistry(backend, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, exists := reloaded.Get(session.ID)
+	if !exists {
+		t.Fatal("сессия не загружена после перезапуска")
+	}
+	if restored.Name != "prod" || len(restored.Tags) != 1 {
+		t.Errorf("описание сессии не сохранено: %+v", restored)
+	}
+	snapshot := restored.Store.Snapshot()
+	if snapshot == nil || len(snapshot.Logs) != 5 || snapshot.Stats.ByLevel["info"] != 5 {
+		t.Fatalf("данные сессии не восстановлены: %+v", snapshot)
+	}
+	if snapshot.Logs[4].ID != 4 || logAttributes(snapshot.Logs[4])["@message"] != "line 1" {
+		t.Errorf("запись восстановлена неверно: %+v", snapshot.Logs[4])
+	}
+	if _, err := os.Stat(filepath.Join(sessionDir, "seg-000009.json")); !os.IsNotExist(err) {
+		t.Error("сегмент вне манифеста не удален")
+	}
+
+	if err := restored.Store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Delete(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewSessionRegistry(backend, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := again.Get(session.ID); exists {
+		t.Error("удаленная сессия загружена снова")
+	}
+}
+
+// segmentFiles - сегменты в каталоге сессии
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "seg-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDiskStoreBuffersAndMergesSegments(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Загрузка сразу видна читателям, на диск попадает по Flush или таймеру
+	store.Append(parseSample(t, 3))
+	if files := segmentFiles(t, dir); len(files) != 0 {
+		t.Fatalf("сегменты до Flush: %v", files)
+	}
+	if snapshot := store.Snapshot(); snapshot == nil || len(snapshot.Logs) != 3 {
+		t.Fatal("загрузка не видна до записи на диск")
+	}
+	for deadline := time.Now().Add(5 * diskFlushInterval); len(segmentFiles(t, dir)) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("загрузка не записана по таймеру")
+		}
+	}
+
+	// Частые мелкие пакеты сливаются: сегментов O(log n)
+	for i := 0; i < 100; i++ {
+		store.Append(parseSample(t, 1))
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.mu.Lock()
+	segments := len(store.manifest.Segments)
+	store.mu.Unlock()
+	if files := segmentFiles(t, dir); segments > 8 || len(files) != segments {
+		t.Errorf("сегментов в манифесте %d, файлов %d", segments, len(files))
+	}
+
+	reopened, err := openDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := reopened.Snapshot()
+	if snapshot == nil || len(snapshot.Logs) != 103 || snapshot.Stats.TotalLines != 103 {
+		t.Fatalf("после загрузки: %+v", snapshot)
+	}
+	for i, entry := range snapshot.Logs {
+		if entry.ID != i {
+			t.Fatalf("запись %d получила ID %d", i, entry.ID)
+		}
+	}
+	if logAttributes(snapshot.Logs[102])["@message"] != "line 0" {
+		t.Errorf("последняя запись: %+v", logAttributes(snapshot.Logs[102]))
+	}
+}
+
+func TestDiskStorePersistsEntryIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Append(parseSample(t, 4))
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Подмененная фаза в сегменте доказывает, что при загрузке данные берутся из него
+	path := filepath.Join(dir, store.manifest.Segments[0].Name)
+	segment, err := readSegment(path)
+	if err != nil || segment.Index == nil || len(segment.Index.Phases) != 4 {
+		t.Fatalf("производные данные не сохранены: %+v, %v", segment.Index, err)
+	}
+	for i := range segment.Index.Phases {
+		segment.Index.Phases[i] = "restored"
+	}
+	segment.Index.Correlation[1] = []string{"req-1"}
+	data, _ := json.Marshal(segment)
+	if err := writeFileAtomic(path, data); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := openDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := reopened.Snapshot().Logs
+	if phase := logPhase(&logs[0]); phase != "restored" {
+		t.Errorf("фаза после загрузки %q", phase)
+	}
+	if ids := correlationIDs(&logs[1]); fmt.Sprint(ids) != "[req-1]" {
+		t.Errorf("идентификаторы корреляции после загрузки: %v", ids)
+	}
+}
+
+func TestDiskStoreFlushEdgeCases(t *testing.T) {
+	t.Run("порог записей", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := openDiskStore(dir)
+		if _, err := store.Append(parseSample(t, diskFlushEntries)); err != nil {
+			t.Fatal(err)
+		}
+		if files := segmentFiles(t, dir); len(files) != 1 || !store.pending.empty() {
+			t.Errorf("по достижении порога Append пишет сегмент сразу: %v", files)
+		}
+	})
+
+	t.Run("вытеснение с отложенными записями", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := openDiskStore(dir)
+		store.Append(parseSample(t, 3))
+		store.Flush()
+		store.Append(parseSample(t, 2))
+		if n, err := store.Trim(4, 0); n != 1 || err != nil {
+			t.Fatalf("вытеснено %d, %v", n, err)
+		}
+		reopened, err := openDiskStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs := reopened.Snapshot().Logs
+		if len(logs) != 4 || logs[0].ID != 1 || logs[3].ID != 4 {
+			t.Errorf("после загрузки: %d записей, ID %d..%d", len(logs), logs[0].ID, logs[len(logs)-1].ID)
+		}
+	})
+
+	t.Run("каталог удален", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "session")
+		store, _ := openDiskStore(dir)
+		store.Append(parseSample(t, 2))
+		os.RemoveAll(dir)
+		if err := store.Flush(); err != nil {
+			t.Errorf("Flush удаленной сессии: %v", err)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Error("Flush создал каталог удаленной сессии заново")
+		}
+	})
+}
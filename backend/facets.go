@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// FacetBucket - одно значение фасета и число записей с ним
+type FacetBucket struct {
+	Value string
+	Count int
+}
+
+// facetParams - параметры фильтра, которые снимаются при подсчете фасета с ослаблением
+var facetParams = map[string][]string{
+	"level":      {"level", "min_level", "exclude_level"},
+	"module":     {"module", "module_prefix", "exclude_module"},
+	"entry_type": {"entry_type"},
+	"provider":   {"provider"},
+	"phase":      {"phase"},
+}
+
+// facetValue - значение записи для фасета
+func facetValue(log *TerraformLog, facet string) string {
+	switch facet {
+	case "level":
+		return normalizeLevel(log.Level())
+	case "module":
+		return log.Module()
+	case "entry_type":
+		return log.EntryType()
+	case "provider":
+		return log.TfProviderAddr()
+	case "phase":
+		return logPhase(log)
+	}
+	return ""
+}
+
+// logPhase - фаза работы Terraform, к которой относится запись:
+// по RPC провайдера, по событию машиночитаемого вывода или по узлу графа
+func logPhase(log *TerraformLog) string {
+	if phase, ok := log.raw.phase(); ok {
+		return phase
+	}
+
+	switch rpc := log.TfRPC(); {
+	case rpc == "":
+	case strings.Contains(rpc, "Plan"):
+		return "plan"
+	case strings.Contains(rpc, "Apply"):
+		return "apply"
+	case strings.HasPrefix(rpc, "Read"):
+		return "refresh"
+	case strings.HasPrefix(rpc, "Validate"):
+		return "validate"
+	case strings.HasPrefix(rpc, "Import"):
+		return "import"
+	case strings.HasPrefix(rpc, "Configure") || strings.HasPrefix(rpc, "GetProviderSchema") || strings.HasPrefix(rpc, "GetSchema"):
+		return "init"
+	}
+
+	if eventType := getString(logAttributes(*log), "type"); eventType != "" {
+		switch {
+		case strings.HasPrefix(eventType, "apply_"):
+			return "apply"
+		case strings.HasPrefix(eventType, "refresh_"):
+			return "refresh"
+		case eventType == "planned_change" || eventType == "resource_drift" || eventType == "change_summary":
+			return "plan"
+		}
+	}
+
+	if _, rest, ok := parseVertexMessage(log.Message()); ok && strings.HasPrefix(rest, "starting visit") {
+		if operation := operationFromNodeType(rest); operation != "visit" {
+			return operation
+		}
+	}
+
+	return ""
+}
+
+// countFacets - число записей по каждому значению запрошенных фасетов
+func countFacets(logs []TerraformLog, facets []string) map[string][]FacetBucket {
+	result := make(map[string][]FacetBucket, len(facets))
+	for _, facet := range facets {
+		result[facet] = countFacet(logs, facet)
+	}
+	return result
+}
+
+func countFacet(logs []TerraformLog, facet string) []FacetBucket {
+	counts := make(map[string]int)
+	for i := range logs {
+		counts[facetValue(&logs[i], facet)]++
+	}
+
+	buckets := make([]FacetBucket, 0, len(counts))
+	for value, count := range counts {
+		buckets = append(buckets, FacetBucket{Value: value, Count: count})
+	}
+	// Сначала самые частые, при равенстве - по алфавиту
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return buckets[i].Value < buckets[j].Value
+	})
+	return buckets
+}
+
+// parseFacetList - запрошенные фасеты из facets=level,module,...
+func parseFacetList(value string) []string {
+	var facets []string
+	for _, facet := range splitFilterList([]string{value}) {
+		facet = strings.ToLower(facet)
+		if _, known := facetParams[facet]; known && !containsString(facets, facet) {
+			facets = append(facets, facet)
+		}
+	}
+	return facets
+}
+
+// relaxedFacets - для каждого фасета счетчики по набору, отфильтрованному без
+// условий на сам этот фасет: панель фильтров видит, сколько записей даст
+// выбор другого значения
+func relaxedFacets(logs []TerraformLog, values url.Values, facets []string, annotations *AnnotationStore) (map[string][]FacetBucket, error) {
+	result := make(map[string][]FacetBucket, len(facets))
+	for _, facet := range facets {
+		relaxed := url.Values{}
+		for key, value := range values {
+			relaxed[key] = value
+		}
+		for _, param := range facetParams[facet] {
+			relaxed.Del(param)
+		}
+		relaxed.Del("limit")
+
+		filter, err := parseLogFilter(relaxed, logs)
+		if err != nil {
+			return nil, err
+		}
+		filter.useAnnotations(annotations)
+		result[facet] = countFacet(filterLogs(logs, filter), facet)
+	}
+	return result, nil
+}
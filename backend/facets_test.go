@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFacetsAPI(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", filterSample, nil)
+
+	tests := []struct {
+		query  string
+		facets map[string][]FacetBucket
+	}{
+		{"min_level=warn&facets=level,module,unknown&limit=1", map[string][]FacetBucket{
+			"level":  {{"error", 1}, {"warn", 1}},
+			"module": {{"provider.terraform-provider-aws", 1}, {"terraform", 1}},
+		}},
+		{"module=provider.*&facets=module&facet_relax=true", map[string][]FacetBucket{
+			"module": {{"provider.terraform-provider-aws", 2}, {"provider.terraform-provider-google", 1}, {"terraform", 1}, {"terraform.ui", 1}},
+		}},
+		{"module=provider.*&level=trace,warn&facets=level,module&facet_relax=true", map[string][]FacetBucket{
+			// Уровни без фильтра по уровню, модули - без фильтра по модулю
+			"level":  {{"debug", 1}, {"trace", 1}, {"warn", 1}},
+			"module": {{"provider.terraform-provider-aws", 2}},
+		}},
+	}
+
+	for _, tt := range tests {
+		var response struct {
+			Count  int                      `json:"count"`
+			Facets map[string][]FacetBucket `json:"facets"`
+		}
+		serveJSON(t, server, "GET", "/api/logs?"+tt.query, "", &response)
+		if !reflect.DeepEqual(response.Facets, tt.facets) {
+			t.Errorf("%s: фасеты %+v, ожидалось %+v", tt.query, response.Facets, tt.facets)
+		}
+	}
+
+	var plain map[string]interface{}
+	serveJSON(t, server, "GET", "/api/logs", "", &plain)
+	if _, exists := plain["facets"]; exists {
+		t.Errorf("фасеты без facets= не возвращаются: %v", plain["facets"])
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// levelSeverity - порядок уровней для min_level: trace < debug < info < warn < error
+var levelSeverity = map[string]int{
+	"trace": 0,
+	"debug": 1,
+	"info":  2,
+	"warn":  3,
+	"error": 4,
+}
+
+// filterParams - параметры фильтрации, общие для API и командной строки
+var filterParams = []string{
+	"level", "min_level", "exclude_level",
+	"module", "module_prefix", "exclude_module",
+	"entry_type", "provider", "phase", "since", "until", "tz", "search", "limit", "q",
+}
+
+// annotationFilterParams - фильтры по пометкам записей; есть только в API,
+// у логов из командной строки пометок нет
+var annotationFilterParams = []string{"annotated", "tag"}
+
+// LogFilter - разобранные параметры фильтрации записей
+type LogFilter struct {
+	Levels         []string
+	MinLevel       int // -1, если порог не задан
+	ExcludeLevels  []string
+	Modules        []*regexp.Regexp
+	ModulePrefixes []string
+	ExcludeModules []*regexp.Regexp
+	EntryTypes     []string
+	Providers      []*regexp.Regexp
+	Phases         []string
+	Since          time.Time
+	Until          time.Time
+	Search         string
+	Limit          int
+	Query          queryNode
+	Time           timeContext // разбор since/until и зона вывода (tz)
+	Annotated      *bool       // только записи с пометками (true) или без них (false)
+	Tags           []string    // записи с пометкой хотя бы с одним из тегов
+
+	params      map[string]string // исходные значения для ответа API
+	annotations annotationIndex   // пометки сессии, см. useAnnotations
+}
+
+// parseLogFilter - разбор параметров фильтрации из запроса или флагов командной строки.
+// Списки задаются через запятую (level=error,warn) или повтором параметра.
+// По logs определяются начало и конец сессии для относительного времени.
+func parseLogFilter(values url.Values, logs []TerraformLog) (LogFilter, error) {
+	filter := LogFilter{MinLevel: -1, params: make(map[string]string)}
+	for _, name := range append(filterParams, annotationFilterParams...) {
+		filter.params[name] = strings.Join(values[name], ",")
+	}
+
+	ctx, err := newTimeContext(logs, values.Get("tz"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Time = ctx
+
+	for _, level := range splitFilterList(values["level"]) {
+		filter.Levels = append(filter.Levels, normalizeLevel(level))
+	}
+	for _, level := range splitFilterList(values["exclude_level"]) {
+		filter.ExcludeLevels = append(filter.ExcludeLevels, normalizeLevel(level))
+	}
+	if minLevel := values.Get("min_level"); minLevel != "" {
+		severity, known := levelSeverity[normalizeLevel(minLevel)]
+		if !known {
+			return filter, fmt.Errorf("неизвестный уровень min_level: %s", minLevel)
+		}
+		filter.MinLevel = severity
+	}
+
+	for _, module := range splitFilterList(values["module"]) {
+		filter.Modules = append(filter.Modules, modulePattern(module))
+	}
+	for _, module := range splitFilterList(values["exclude_module"]) {
+		filter.ExcludeModules = append(filter.ExcludeModules, modulePattern(module))
+	}
+	for _, prefix := range splitFilterList(values["module_prefix"]) {
+		filter.ModulePrefixes = append(filter.ModulePrefixes, strings.ToLower(prefix))
+	}
+	for _, entryType := range splitFilterList(values["entry_type"]) {
+		filter.EntryTypes = append(filter.EntryTypes, strings.ToLower(entryType))
+	}
+	for _, provider := range splitFilterList(values["provider"]) {
+		filter.Providers = append(filter.Providers, modulePattern(provider))
+	}
+	for _, phase := range splitFilterList(values["phase"]) {
+		filter.Phases = append(filter.Phases, strings.ToLower(phase))
+	}
+
+	if since := values.Get("since"); since != "" {
+		t, err := ctx.parse(since)
+		if err != nil {
+			return filter, fmt.Errorf("since: %w", err)
+		}
+		filter.Since = t
+	}
+	if until := values.Get("until"); until != "" {
+		t, err := ctx.parse(until)
+		if err != nil {
+			return filter, fmt.Errorf("until: %w", err)
+		}
+		filter.Until = t
+	}
+
+	filter.Search = strings.ToLower(values.Get("search"))
+
+	if limitStr := values.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("limit: ожидается положительное число, получено %s", limitStr)
+		}
+		filter.Limit = limit
+	}
+
+	if annotated := values.Get("annotated"); annotated != "" {
+		value, err := strconv.ParseBool(annotated)
+		if err != nil {
+			return filter, fmt.Errorf("annotated: ожидается true или false, получено %s", annotated)
+		}
+		filter.Annotated = &value
+	}
+	filter.Tags = normalizeTags(splitFilterList(values["tag"]))
+
+	if q := values.Get("q"); q != "" {
+		node, err := parseQuery(q, ctx)
+		if err != nil {
+			return filter, err
+		}
+		filter.Query = node
+	}
+
+	return filter, nil
+}
+
+// splitFilterList - значения параметра, разделенные запятыми, без пустых
+func splitFilterList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// normalizeLevel - уровень в нижнем регистре, warning приводится к warn
+func normalizeLevel(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "warning" {
+		return "warn"
+	}
+	return level
+}
+
+// modulePattern - точное совпадение без учета регистра или шаблон с * и ?
+func modulePattern(module string) *regexp.Regexp {
+	if strings.ContainsAny(module, "*?") {
+		return wildcardPattern(module)
+	}
+	return regexp.MustCompile("(?i)^" + regexp.QuoteMeta(module) + "$")
+}
+
+func matchesAny(patterns []*regexp.Regexp, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// useAnnotations - пометки сессии для условий annotated и tag
+func (f *LogFilter) useAnnotations(annotations *AnnotationStore) {
+	if f.Annotated != nil || len(f.Tags) > 0 {
+		f.annotations = annotations.Index()
+	}
+}
+
+// IsEmpty - не задано ни одного условия
+func (f LogFilter) IsEmpty() bool {
+	for _, value := range f.params {
+		if value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Params - исходные значения параметров фильтрации
+func (f LogFilter) Params() map[string]string {
+	return f.params
+}
+
+// Match - проверка одной записи по всем условиям, кроме лимита
+func (f LogFilter) Match(log *TerraformLog) bool {
+	level := normalizeLevel(log.Level())
+	if len(f.Levels) > 0 && !containsString(f.Levels, level) {
+		return false
+	}
+	if containsString(f.ExcludeLevels, level) {
+		return false
+	}
+	if f.MinLevel >= 0 {
+		severity, known := levelSeverity[level]
+		if !known || severity < f.MinLevel {
+			return false
+		}
+	}
+
+	if len(f.Modules) > 0 || len(f.ModulePrefixes) > 0 {
+		matched := matchesAny(f.Modules, log.Module())
+		for _, prefix := range f.ModulePrefixes {
+			if strings.HasPrefix(strings.ToLower(log.Module()), prefix) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if matchesAny(f.ExcludeModules, log.Module()) {
+		return false
+	}
+
+	if len(f.EntryTypes) > 0 && !containsString(f.EntryTypes, strings.ToLower(log.EntryType())) {
+		return false
+	}
+	if len(f.Providers) > 0 && !matchesAny(f.Providers, log.TfProviderAddr()) {
+		return false
+	}
+	if len(f.Phases) > 0 && !containsString(f.Phases, logPhase(log)) {
+		return false
+	}
+
+	if !f.Since.IsZero() && log.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && log.Timestamp.After(f.Until) {
+		return false
+	}
+
+	if f.Search != "" && !strings.Contains(strings.ToLower(log.Message()), f.Search) {
+		return false
+	}
+
+	if f.Query != nil && !f.Query.match(log) {
+		return false
+	}
+
+	if f.Annotated != nil || len(f.Tags) > 0 {
+		tags, annotated := f.annotations[log.ID]
+		if f.Annotated != nil && annotated != *f.Annotated {
+			return false
+		}
+		if len(f.Tags) > 0 && !sharesTag(tags, f.Tags) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeFilterError - ответ 400 на неверные параметры фильтрации
+func writeFilterError(w http.ResponseWriter, err error) {
+	response := map[string]interface{}{
+		"status": "error",
+		"error":  err.Error(),
+	}
+	if queryErr, ok := err.(*QueryError); ok {
+		response["position"] = queryErr.Pos
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+}
+
+// registerFilterFlags - флаги командной строки с той же семантикой, что и параметры API
+// (--min-level warn, --exclude-module provider.*, -q '...')
+func registerFilterFlags(fs *flag.FlagSet) func() url.Values {
+	usage := map[string]string{
+		"level":          "уровни через запятую (error,warn)",
+		"min_level":      "минимальный уровень: trace < debug < info < warn < error",
+		"exclude_level":  "исключить уровни",
+		"module":         "модули через запятую, допускаются шаблоны * и ?",
+		"module_prefix":  "префиксы модулей",
+		"exclude_module": "исключить модули (шаблоны * и ?)",
+		"entry_type":     "типы записей (http_request, grpc_request, provider, general)",
+		"provider":       "адреса провайдеров (tf_provider_addr), допускаются шаблоны",
+		"phase":          "фазы: init, validate, plan, refresh, apply, import",
+		"since":          "время начала: 2025-09-09T15:30, 14:30, -15m, start+10m",
+		"until":          "время окончания",
+		"tz":             "часовой пояс ввода и вывода: Europe/Moscow, UTC, +03:00",
+		"search":         "поиск по сообщению",
+		"limit":          "ограничение количества записей",
+		"q":              "запрос на языке фильтров",
+	}
+
+	values := make(map[string]*string)
+	for _, name := range filterParams {
+		values[name] = fs.String(strings.ReplaceAll(name, "_", "-"), "", usage[name])
+	}
+
+	return func() url.Values {
+		result := url.Values{}
+		for name, value := range values {
+			if *value != "" {
+				result.Set(name, *value)
+			}
+		}
+		return result
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// filterSample - записи разных уровней и модулей; ID - порядок строк
+const filterSample = `{"@level":"trace","@message":"aws trace","@module":"provider.terraform-provider-aws"}
+{"@level":"debug","@message":"google debug","@module":"provider.terraform-provider-google"}
+{"@level":"info","@message":"ui info","@module":"terraform.ui"}
+{"@level":"warn","@message":"aws warn","@module":"provider.terraform-provider-aws"}
+{"@level":"error","@message":"core error","@module":"terraform"}
+`
+
+// logIDs - ID записей ответа GET /api/logs
+func logIDs(t *testing.T, handler http.Handler, query string) (int, []int) {
+	t.Helper()
+	var response struct {
+		Logs []TerraformLog `json:"logs"`
+	}
+	code := serveJSON(t, handler, "GET", "/api/logs?"+query, "", &response)
+	ids := []int{}
+	for _, entry := range response.Logs {
+		ids = append(ids, entry.ID)
+	}
+	return code, ids
+}
+
+func TestLogFiltersAPI(t *testing.T) {
+	_, server := testServer(t)
+	if code := serveJSON(t, server, "POST", "/api/logs", filterSample, nil); code != http.StatusOK {
+		t.Fatalf("загрузка логов: код %d", code)
+	}
+
+	tests := []struct {
+		query string
+		ids   []int
+	}{
+		{"level=error,warn", []int{3, 4}},
+		{"level=error&level=trace", []int{0, 4}},
+		{"min_level=info", []int{2, 3, 4}},
+		{"min_level=WARNING", []int{3, 4}},
+		{"exclude_level=trace,debug", []int{2, 3, 4}},
+		{"module=provider.*", []int{0, 1, 3}},
+		{"module=terraform", []int{4}},
+		{"module_prefix=terraform", []int{2, 4}},
+		{"exclude_module=provider.*-aws", []int{1, 2, 4}},
+		{"min_level=debug&exclude_module=terraform*", []int{1, 3}},
+		{"search=AWS", []int{0, 3}},
+		{"level=fatal", []int{}},
+	}
+	for _, tt := range tests {
+		code, ids := logIDs(t, server, tt.query)
+		if code != http.StatusOK || !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: код %d, записи %v, ожидались %v", tt.query, code, ids, tt.ids)
+		}
+	}
+
+	var failed struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if code := serveJSON(t, server, "GET", "/api/logs?min_level=loud", "", &failed); code != http.StatusBadRequest || failed.Status != "error" {
+		t.Errorf("неизвестный min_level: код %d, ответ %+v", code, failed)
+	}
+	for _, limit := range []string{"abc", "-5", "0"} {
+		failed.Error = ""
+		if code := serveJSON(t, server, "GET", "/api/logs?limit="+limit, "", &failed); code != http.StatusBadRequest || !strings.Contains(failed.Error, "limit") {
+			t.Errorf("limit=%s: код %d, ответ %+v", limit, code, failed)
+		}
+	}
+}
+
+func TestParseLogFilterParams(t *testing.T) {
+	values := url.Values{"level": {"error", "warn"}, "module": {"provider.*"}}
+	filter, err := parseLogFilter(values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := filter.Params()
+	if params["level"] != "error,warn" || params["module"] != "provider.*" || params["exclude_level"] != "" {
+		t.Errorf("параметры фильтра для ответа: %v", params)
+	}
+}
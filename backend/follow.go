@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// followOptions - режим --follow: файлы дочитываются по мере записи, как tail -F
+type followOptions struct {
+	enabled  bool
+	interval time.Duration
+}
+
+// registerFollowFlags - флаги -F/--follow и --follow-interval для командной строки
+func registerFollowFlags(fs *flag.FlagSet) *followOptions {
+	opts := &followOptions{}
+
+	fs.BoolVar(&opts.enabled, "F", false, "дочитывать новые строки файлов, как tail -F")
+	fs.BoolVar(&opts.enabled, "follow", false, "дочитывать новые строки файлов, как tail -F (переживает усечение и ротацию)")
+	fs.DurationVar(&opts.interval, "follow-interval", 500*time.Millisecond, "период проверки файлов в режиме --follow")
+
+	return opts
+}
+
+// fileFollower - чтение строк, дописываемых в файл. Если файл по этому имени
+// заменили (ротация), старый дочитывается и открывается новый; если файл стал
+// короче прочитанного (усечение), чтение начинается сначала. Незавершенная
+// последняя строка ждет перевода строки.
+type fileFollower struct {
+	path    string
+	file    *os.File
+	offset  int64
+	lines   int    // прочитано строк: сквозные номера строк в ошибках разбора
+	partial []byte // начало незавершенной строки
+	missing bool   // файла нет; сообщение выводится один раз
+}
+
+// followFiles - первичное чтение файлов в store и фоновое дочитывание новых строк
+func followFiles(paths []string, store LogStore, interval time.Duration) error {
+	followers := make([]*fileFollower, len(paths))
+	for i, path := range paths {
+		followers[i] = &fileFollower{path: path}
+		result, err := followers[i].poll()
+		if err != nil {
+			return err
+		}
+		if _, err := store.Append(result); err != nil {
+			return err
+		}
+	}
+
+	for _, follower := range followers {
+		go follower.run(store, interval)
+	}
+	return nil
+}
+
+// run - периодическая проверка файла и добавление новых записей в store
+func (f *fileFollower) run(store LogStore, interval time.Duration) {
+	for range time.Tick(interval) {
+		result, err := f.poll()
+		if err != nil {
+			log.Printf("Ошибка чтения %s: %v", f.path, err)
+			continue
+		}
+		if result.Stats.TotalLines == 0 {
+			continue
+		}
+		if _, err := store.Append(result); err != nil {
+			log.Printf("Ошибка сохранения логов из %s: %v", f.path, err)
+		}
+	}
+}
+
+// poll - записи из строк, дописанных с прошлого вызова
+func (f *fileFollower) poll() (ParseResult, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return ParseResult{}, f.reportMissing(err)
+		}
+	}
+
+	var lines []byte
+	current, err := f.file.Stat()
+	if err != nil {
+		return ParseResult{}, err
+	}
+	info, err := os.Stat(f.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Файл переименован при ротации, а новый еще не создан: дочитываем старый
+		chunk, err := f.readNew()
+		if err != nil {
+			return ParseResult{}, err
+		}
+		lines = f.complete(chunk, false)
+
+	case err != nil:
+		return ParseResult{}, err
+
+	case !os.SameFile(info, current):
+		chunk, err := f.readNew()
+		if err != nil {
+			return ParseResult{}, err
+		}
+		lines = f.complete(chunk, true)
+		f.file.Close()
+		if err := f.open(); err != nil {
+			// Новый файл успели переименовать: записи старого не теряются
+			if err := f.reportMissing(err); err != nil {
+				return ParseResult{}, err
+			}
+			return f.parse(lines), nil
+		}
+		log.Printf("Файл %s заменен (ротация), чтение нового файла", f.path)
+		chunk, err = f.readNew()
+		if err != nil {
+			return ParseResult{}, err
+		}
+		lines = append(lines, f.complete(chunk, false)...)
+
+	default:
+		if info.Size() < f.offset {
+			log.Printf("Файл %s усечен, чтение с начала", f.path)
+			f.offset = 0
+			f.partial = nil
+		}
+		chunk, err := f.readNew()
+		if err != nil {
+			return ParseResult{}, err
+		}
+		lines = f.complete(chunk, false)
+	}
+
+	return f.parse(lines), nil
+}
+
+func (f *fileFollower) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.offset = 0
+	f.partial = nil
+	f.missing = false
+	return nil
+}
+
+// reportMissing - отсутствующий файл не ошибка: он может появиться позже, как в tail -F
+func (f *fileFollower) reportMissing(err error) error {
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if !f.missing {
+		log.Printf("Файл %s не найден, ожидание его появления", f.path)
+		f.missing = true
+	}
+	f.file = nil
+	return nil
+}
+
+// readNew - байты файла после уже прочитанных
+func (f *fileFollower) readNew() ([]byte, error) {
+	if f.file == nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f.file, f.offset, 1<<62))
+	f.offset += int64(len(data))
+	return data, err
+}
+
+// complete - завершенные строки с учетом начала строки из прошлого чтения;
+// final - файл больше не пишется, незавершенная строка отдается как есть
+func (f *fileFollower) complete(chunk []byte, final bool) []byte {
+	data := append(f.partial, chunk...)
+	f.partial = nil
+	if final {
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		return data
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end < len(data) {
+		f.partial = append([]byte(nil), data[end:]...)
+	}
+	return data[:end]
+}
+
+func (f *fileFollower) parse(lines []byte) ParseResult {
+	result := parseLines(lines, f.lines)
+	f.lines += bytes.Count(lines, []byte{'\n'})
+	return result
+}
+
+// parseLines - разбор порции строк отдельным парсером: статистика и ID записей
+// относятся только к этой порции, хранилище добавляет их к уже сохраненным.
+// Номера строк в ошибках отсчитываются от offset.
+func parseLines(lines []byte, offset int) ParseResult {
+	result := NewLogParser().ParseStream(bytes.NewReader(lines))
+	for i := range result.Errors {
+		result.Errors[i].LineNumber += offset
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileFollowerTruncationAndRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "terraform.log")
+	line := func(message string) string {
+		return fmt.Sprintf(`{"@level":"info","@message":%q}`+"\n", message)
+	}
+	write := func(name, data string, flags int) {
+		file, err := os.OpenFile(name, flags|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(data)
+		file.Close()
+	}
+
+	store := NewMemoryStore()
+	follower := &fileFollower{path: path}
+	poll := func() {
+		t.Helper()
+		result, err := follower.poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Append(result)
+	}
+	messages := func() string {
+		var list []string
+		for _, log := range store.Snapshot().Logs {
+			list = append(list, log.Message())
+		}
+		return strings.Join(list, ",")
+	}
+
+	// Файла еще нет, затем дописывается строка по частям
+	poll()
+	write(path, line("a1")+`{"@level":"info","@message":"pa`, os.O_APPEND)
+	poll()
+	write(path, `rt"}`+"\n", os.O_APPEND)
+	poll()
+	if got := messages(); got != "a1,part" {
+		t.Fatalf("после дописывания: %s", got)
+	}
+
+	// Усечение: файл читается сначала
+	write(path, line("t1"), os.O_TRUNC)
+	poll()
+
+	// Ротация: старый файл дочитывается, новый читается с начала
+	os.Rename(path, path+".1")
+	write(path+".1", line("old"), os.O_APPEND)
+	write(path, line("new"), 0)
+	poll()
+
+	if got := messages(); got != "a1,part,t1,old,new" {
+		t.Errorf("после усечения и ротации: %s", got)
+	}
+	if stats := store.Snapshot().Stats; stats.SuccessLines != 5 || stats.ByLevel["info"] != 5 {
+		t.Errorf("статистика не накоплена: %+v", stats)
+	}
+}
+
+func TestFileFollowerEdgeCases(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "terraform.log")
+	store := NewMemoryStore()
+	follower := &fileFollower{path: path}
+	poll := func() {
+		t.Helper()
+		result, err := follower.poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Append(result)
+	}
+
+	// Ошибки разбора нумеруются сквозь все порции
+	os.WriteFile(path, []byte(`{"@level":"info","@message":"a"}`+"\nbroken\n"), 0o644)
+	poll()
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString("also broken\n" + `{"@level":"info","@message":"b`)
+	file.Close()
+	poll()
+	errs := store.Snapshot().Errors
+	if len(errs) != 2 || errs[0].LineNumber != 2 || errs[1].LineNumber != 3 {
+		t.Fatalf("номера строк ошибок: %+v", errs)
+	}
+
+	// Файл переименован, нового нет: старый дочитывается, незавершенная строка ждет
+	os.Rename(path, path+".1")
+	file, _ = os.OpenFile(path+".1", os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString(`"}` + "\n" + `{"@level":"info","@message":"c"}`)
+	file.Close()
+	poll()
+	if logs := store.Snapshot().Logs; len(logs) != 2 || logs[1].Message() != "b" {
+		t.Fatalf("после переименования: %d записей", len(logs))
+	}
+
+	// Новый файл появился: незавершенная строка старого отдается как есть
+	os.WriteFile(path, []byte(`{"@level":"info","@message":"d"}`+"\n"), 0o644)
+	poll()
+	var messages []string
+	for _, log := range store.Snapshot().Logs {
+		messages = append(messages, log.Message())
+	}
+	if got := strings.Join(messages, ","); got != "a,b,c,d" {
+		t.Errorf("после ротации: %s", got)
+	}
+
+	// Отсутствующий файл не ошибка, каталог вместо файла - ошибка
+	if err := followFiles([]string{filepath.Join(dir, "missing.log")}, NewMemoryStore(), time.Hour); err != nil {
+		t.Errorf("отсутствующий файл: %v", err)
+	}
+	if err := followFiles([]string{dir}, NewMemoryStore(), time.Hour); err == nil {
+		t.Error("каталог должен давать ошибку чтения")
+	}
+}
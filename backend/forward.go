@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// Прием событий Fluent Bit и Fluentd по протоколу Forward (MessagePack через TCP):
+// режимы Message, Forward, PackedForward и CompressedPackedForward. Тег события
+// выбирает сессию; на запрос с option chunk отправляется ack после сохранения,
+// так что доставка - не менее одного раза. Аутентификация (HELO/PING) не поддерживается.
+
+// forwardOptions - флаги приема Forward
+type forwardOptions struct {
+	addr      string
+	tagPrefix string
+}
+
+func registerForwardFlags(fs *flag.FlagSet) *forwardOptions {
+	opts := &forwardOptions{}
+
+	fs.StringVar(&opts.addr, "forward", "", "адрес приема Fluent Forward, например :24224 (пусто - выключено)")
+	fs.StringVar(&opts.tagPrefix, "forward-tag-prefix", "", "префикс тега, который отбрасывается: остаток тега - ID или название сессии")
+
+	return opts
+}
+
+// forwardEvent - событие Forward: тег, время и запись
+type forwardEvent struct {
+	Tag    string
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// forwardMessageFields - поля записи с исходной строкой лога: Fluent Bit tail
+// кладет строку в log, Fluentd и syslog input - в message
+var forwardMessageFields = []string{"log", "message"}
+
+// startForward - запуск приема Forward на адресе из флагов; ошибка, если адрес занят
+func startForward(opts *forwardOptions) error {
+	if opts.addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", opts.addr)
+	if err != nil {
+		return fmt.Errorf("Fluent Forward: %w", err)
+	}
+	fmt.Printf("Прием Fluent Forward: %s\n", listener.Addr())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				if err := readForwardStream(conn, opts); err != nil {
+					log.Printf("Fluent Forward %s: %v", conn.RemoteAddr(), err)
+				}
+			}()
+		}
+	}()
+	return nil
+}
+
+// readForwardStream - сообщения соединения до его закрытия. Ack отправляется
+// только после сохранения: при ошибке соединение закрывается без ack,
+// и клиент повторит отправку.
+func readForwardStream(conn io.ReadWriter, opts *forwardOptions) error {
+	reader := newMsgpackReader(conn, maxPushBytes)
+	for {
+		value, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		events, chunk, err := decodeForwardMessage(value)
+		if err != nil {
+			return err
+		}
+
+		if len(events) > 0 {
+			summaries, err := forwardBatch(events, opts.tagPrefix).store(sessions, "fluent forward")
+			if err != nil {
+				return err
+			}
+			for _, summary := range summaries {
+				fmt.Fprintf(serverOutput, " Fluent Forward: сессия %s, записей %d, ошибок разбора %d\n", summary.Session, summary.Added, summary.Errors)
+			}
+		}
+		if chunk != "" {
+			if _, err := conn.Write(appendMsgpackMap(nil, map[string]string{"ack": chunk})); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// decodeForwardMessage - события одного сообщения и chunk из option для ack:
+//
+//	Message:       [tag, time, record, option?]
+//	Forward:       [tag, [[time, record], ...], option?]
+//	PackedForward: [tag, bin с записями [time, record] подряд, option?]
+//	               (option compressed: "gzip" - записи сжаты)
+func decodeForwardMessage(value interface{}) ([]forwardEvent, string, error) {
+	message, ok := value.([]interface{})
+	if !ok || len(message) < 2 {
+		return nil, "", errors.New("сообщение Forward должно быть массивом [tag, ...]")
+	}
+	tag, ok := message[0].(string)
+	if !ok {
+		return nil, "", errors.New("тег сообщения Forward должен быть строкой")
+	}
+
+	var events []forwardEvent
+	var option interface{}
+	switch entries := message[1].(type) {
+	case []interface{}:
+		for i, entry := range entries {
+			pair, ok := entry.([]interface{})
+			if !ok || len(pair) < 2 {
+				return nil, "", fmt.Errorf("запись %d: ожидается [time, record]", i)
+			}
+			event, err := newForwardEvent(tag, pair[0], pair[1])
+			if err != nil {
+				return nil, "", fmt.Errorf("запись %d: %v", i, err)
+			}
+			events = append(events, event)
+		}
+		if len(message) > 2 {
+			option = message[2]
+		}
+	case []byte, string:
+		if len(message) > 2 {
+			option = message[2]
+		}
+		packed, err := decodeForwardPacked(tag, entries, option)
+		if err != nil {
+			return nil, "", err
+		}
+		events = packed
+	default:
+		if len(message) < 3 {
+			return nil, "", errors.New("сообщение Forward: ожидается [tag, time, record]")
+		}
+		event, err := newForwardEvent(tag, message[1], message[2])
+		if err != nil {
+			return nil, "", err
+		}
+		events = append(events, event)
+		if len(message) > 3 {
+			option = message[3]
+		}
+	}
+
+	var chunk string
+	if options, ok := option.(map[string]interface{}); ok {
+		chunk, _ = options["chunk"].(string)
+	}
+	return events, chunk, nil
+}
+
+// decodeForwardPacked - записи PackedForward, при compressed: "gzip" - сжатые
+func decodeForwardPacked(tag string, entries interface{}, option interface{}) ([]forwardEvent, error) {
+	var data []byte
+	switch value := entries.(type) {
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	}
+	var body io.Reader = bytes.NewReader(data)
+	if options, ok := option.(map[string]interface{}); ok {
+		switch compressed, _ := options["compressed"].(string); compressed {
+		case "", "text":
+		case "gzip":
+			// Сжатый поток может состоять из нескольких gzip членов: gzip.Reader читает их подряд
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				return nil, fmt.Errorf("gzip: %w", err)
+			}
+			defer gz.Close()
+			body = io.LimitReader(gz, maxPushBytes)
+		default:
+			return nil, fmt.Errorf("неподдерживаемое сжатие %q", compressed)
+		}
+	}
+
+	var events []forwardEvent
+	reader := newMsgpackReader(body, maxPushBytes)
+	for i := 0; ; i++ {
+		value, err := reader.Read()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("запись %d: %v", i, err)
+		}
+		pair, ok := value.([]interface{})
+		if !ok || len(pair) < 2 {
+			return nil, fmt.Errorf("запись %d: ожидается [time, record]", i)
+		}
+		event, err := newForwardEvent(tag, pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("запись %d: %v", i, err)
+		}
+		events = append(events, event)
+	}
+}
+
+func newForwardEvent(tag string, timestamp, record interface{}) (forwardEvent, error) {
+	at, err := forwardTime(timestamp)
+	if err != nil {
+		return forwardEvent{}, err
+	}
+	fields, ok := record.(map[string]interface{})
+	if !ok {
+		return forwardEvent{}, errors.New("запись должна быть словарем")
+	}
+	return forwardEvent{Tag: tag, Time: at, Record: fields}, nil
+}
+
+// forwardTime - время события: секунды Unix (целые или дробные) или EventTime
+// (расширение 0: секунды и наносекунды, по 4 байта)
+func forwardTime(value interface{}) (time.Time, error) {
+	switch t := value.(type) {
+	case int64:
+		return time.Unix(t, 0), nil
+	case uint64:
+		return time.Unix(int64(t), 0), nil
+	case float64:
+		seconds, fraction := math.Modf(t)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), nil
+	case msgpackExt:
+		if t.Type == 0 && len(t.Data) == 8 {
+			return time.Unix(int64(binary.BigEndian.Uint32(t.Data)), int64(binary.BigEndian.Uint32(t.Data[4:]))), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("некорректное время события %v", value)
+}
+
+// forwardBatch - события по сессиям: тег без tagPrefix - ID или название сессии
+// (пустой - сессия по умолчанию). Строка лога из поля log или message разбирается
+// как строка Terraform, остальные поля записи и тег (fluent_tag) добавляются к ней;
+// запись без этих полей сохраняется целиком.
+func forwardBatch(events []forwardEvent, tagPrefix string) *ingestBatch {
+	batch := newIngestBatch()
+	for _, event := range events {
+		record := forwardJSONValue(event.Record).(map[string]interface{})
+		attributes := map[string]interface{}{"fluent_tag": event.Tag}
+
+		var line string
+		for _, name := range forwardMessageFields {
+			if text, ok := record[name].(string); ok {
+				line = textPayloadLine(text)
+				for field, value := range record {
+					if field != name {
+						attributes[field] = value
+					}
+				}
+				break
+			}
+		}
+		if line == "" {
+			data, _ := json.Marshal(record)
+			line = string(data)
+		}
+
+		batch.add(strings.TrimPrefix(event.Tag, tagPrefix), ingestLine{Line: line, Timestamp: event.Time, Attributes: attributes})
+	}
+	return batch
+}
+
+// forwardJSONValue - значение MessagePack в виде, пригодном для JSON:
+// bin - строка, расширения - hex
+func forwardJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case msgpackExt:
+		return hex.EncodeToString(v.Data)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = forwardJSONValue(item)
+		}
+		return items
+	case map[string]interface{}:
+		items := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			items[key] = forwardJSONValue(item)
+		}
+		return items
+	}
+	return value
+}
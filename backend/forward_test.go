@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFluentForward(t *testing.T) {
+	str := func(s string) []byte { return appendMsgpackString(nil, s) }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	eventTime := []byte{0xd7, 0x00, 0x65, 0x92, 0x8e, 0x20, 0x00, 0x00, 0x00, 0x05} // 1704103456 с + 5 нс
+	line := `{"@level":"info","@message":"fb line","@timestamp":"2024-01-01T10:00:00.000000Z"}`
+	record := join([]byte{0x82}, str("log"), str(line), str("stream"), str("stderr"))
+	option := join([]byte{0x81}, str("chunk"), str("c1"))
+
+	// Message: [tag, time, record, option]
+	message := join([]byte{0x94}, str("terraform.nightly"), eventTime, record, option)
+	// Forward: [tag, [[time, record]]] с целым временем и int16 в записи
+	forward := join([]byte{0x92}, str("terraform.nightly"), []byte{0x91, 0x92, 0xce, 0x65, 0x92, 0x8e, 0x20},
+		[]byte{0x82}, str("@message"), str("fwd mode"), str("attempt"), []byte{0xd1, 0xff, 0xfe})
+	// PackedForward со сжатием: [tag, bin, {compressed: gzip}]
+	var packed bytes.Buffer
+	gz := gzip.NewWriter(&packed)
+	gz.Write(join([]byte{0x92, 0x01, 0x81}, str("message"), str("plain text")))
+	gz.Close()
+	packedForward := join([]byte{0x93}, str("other"), []byte{0xc5, byte(packed.Len() >> 8), byte(packed.Len())}, packed.Bytes(),
+		[]byte{0x82}, str("compressed"), str("gzip"), str("chunk"), str("c3"))
+
+	reader := newMsgpackReader(bytes.NewReader(join(message, forward, packedForward)), 1<<20)
+	var events []forwardEvent
+	var chunks []string
+	for {
+		value, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, chunk, err := decodeForwardMessage(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, decoded...)
+		chunks = append(chunks, chunk)
+	}
+	if len(events) != 3 || strings.Join(chunks, ",") != "c1,,c3" {
+		t.Fatalf("событий %d, chunk %q", len(events), chunks)
+	}
+	if want := time.Unix(1704103456, 5); !events[0].Time.Equal(want) || events[2].Time.Unix() != 1 {
+		t.Errorf("время: %v, %v", events[0].Time, events[2].Time)
+	}
+	if events[1].Record["attempt"] != int64(-2) {
+		t.Errorf("int16: %#v", events[1].Record["attempt"])
+	}
+
+	batch := forwardBatch(events, "terraform.")
+	if strings.Join(batch.order, ",") != "nightly,other" {
+		t.Fatalf("сессии: %v", batch.order)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(batch.lines["nightly"][0]), &fields); err != nil || fields["@message"] != "fb line" || fields["stream"] != "stderr" || fields["fluent_tag"] != "terraform.nightly" || fields["log"] != nil {
+		t.Errorf("строка из log: %v, %v", fields, err)
+	}
+	if err := json.Unmarshal([]byte(batch.lines["other"][0]), &fields); err != nil || fields["@message"] != "plain text" {
+		t.Errorf("текст из message: %v, %v", fields, err)
+	}
+
+	if _, _, err := decodeForwardMessage([]interface{}{"tag", int64(1), "not a map"}); err == nil {
+		t.Error("запись не словарь - ошибка")
+	}
+	if _, err := newMsgpackReader(bytes.NewReader([]byte{0xdb, 0xff, 0xff, 0xff, 0xff}), 1<<20).Read(); err == nil {
+		t.Error("строка длиннее ограничения - ошибка")
+	}
+}
+
+// forwardConn - соединение клиента Forward: запросы из input, ответы в output
+type forwardConn struct {
+	io.Reader
+	output bytes.Buffer
+}
+
+func (c *forwardConn) Write(data []byte) (int, error) { return c.output.Write(data) }
+
+func TestReadForwardStreamAcks(t *testing.T) {
+	registry := useTestSessions(t)
+	str := func(s string) []byte { return appendMsgpackString(nil, s) }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	record := join([]byte{0x81}, str("log"), str(`{"@level":"warn","@message":"retry"}`))
+	withChunk := join([]byte{0x94}, str("ci"), []byte{0x01}, record, []byte{0x81}, str("chunk"), str("p8n9gmxTQVC8/nh2wlKKeQ=="))
+	withoutChunk := join([]byte{0x93}, str("ci"), []byte{0x02}, record)
+
+	var output bytes.Buffer
+	defer func(previous io.Writer) { serverOutput = previous }(serverOutput)
+	serverOutput = &output
+
+	conn := &forwardConn{Reader: bytes.NewReader(join(withChunk, withoutChunk))}
+	if err := readForwardStream(conn, &forwardOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(output.String(), "Fluent Forward: сессия"); got != 2 {
+		t.Errorf("сообщения сервера: %q", output.String())
+	}
+	if want := appendMsgpackMap(nil, map[string]string{"ack": "p8n9gmxTQVC8/nh2wlKKeQ=="}); !bytes.Equal(conn.output.Bytes(), want) {
+		t.Errorf("ответы %q, ожидался один ack %q", conn.output.Bytes(), want)
+	}
+	session, _ := registry.FindOrCreate("ci", "")
+	if logs := session.Store.Snapshot().Logs; len(logs) != 2 || logs[0].Level() != "warn" {
+		t.Errorf("записи: %+v", logs)
+	}
+
+	// Ошибка протокола: соединение закрывается без ack, записи не сохраняются
+	tests := map[string][]byte{
+		"не массив":               str("hello"),
+		"тег не строка":           join([]byte{0x92, 0x01, 0x01}),
+		"запись не словарь":       join([]byte{0x94}, str("ci"), []byte{0x01, 0x01}, []byte{0x81}, str("chunk"), str("x")),
+		"некорректное время":      join([]byte{0x93}, str("ci"), str("вчера"), record),
+		"пара Forward без записи": join([]byte{0x92}, str("ci"), []byte{0x91, 0x91, 0x01}),
+		"PackedForward с мусором": join([]byte{0x92}, str("ci"), []byte{0xc4, 1, 0xc1}),
+		"неизвестное сжатие":      join([]byte{0x93}, str("ci"), []byte{0xc4, 0}, []byte{0x81}, str("compressed"), str("zstd")),
+		"обрезанное сообщение":    withChunk[:len(withChunk)-3],
+	}
+	for name, input := range tests {
+		conn := &forwardConn{Reader: bytes.NewReader(input)}
+		if err := readForwardStream(conn, &forwardOptions{}); err == nil || conn.output.Len() != 0 {
+			t.Errorf("%s: ошибка %v, ответ %q", name, err, conn.output.Bytes())
+		}
+	}
+	if logs := session.Store.Snapshot().Logs; len(logs) != 2 {
+		t.Errorf("после ошибок записей %d, ожидалось 2", len(logs))
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Прием логов от сборщиков (Loki push и другие протоколы): строки раскладываются
+// по сессиям, метки потока добавляются к полям записи, строки разбираются LogParser.
+
+// maxPushBytes - ограничение тела запроса сборщика после распаковки
+const maxPushBytes = 64 << 20
+
+// ingestLine - строка лога от сборщика: метки и атрибуты источника становятся
+// полями записи, время доставки используется, если в строке его нет
+type ingestLine struct {
+	Line       string
+	Timestamp  time.Time
+	Attributes map[string]interface{}
+}
+
+// ingestBatch - строки запроса сборщика по сессиям в порядке поступления
+type ingestBatch struct {
+	order []string
+	lines map[string][]string
+}
+
+func newIngestBatch() *ingestBatch {
+	return &ingestBatch{lines: make(map[string][]string)}
+}
+
+// add - строка для сессии с названием или ID session ("" - сессия по умолчанию)
+func (b *ingestBatch) add(session string, line ingestLine) {
+	if _, exists := b.lines[session]; !exists {
+		b.order = append(b.order, session)
+	}
+	b.lines[session] = append(b.lines[session], mergeLineAttributes(line))
+}
+
+// ingestSummary - итог сохранения строк в одну сессию
+type ingestSummary struct {
+	Session string
+	Added   int
+	Errors  int
+}
+
+// store - разбор и сохранение строк; сессии, которых еще нет, создаются
+// с источником source
+func (b *ingestBatch) store(registry *SessionRegistry, source string) ([]ingestSummary, error) {
+	var summaries []ingestSummary
+	for _, key := range b.order {
+		session, err := registry.FindOrCreate(key, source)
+		if err != nil {
+			return summaries, err
+		}
+		lines := b.lines[key]
+		result := parseLines([]byte(strings.Join(lines, "\n")+"\n"), 0)
+		if _, err := session.Store.Append(result); err != nil {
+			return summaries, fmt.Errorf("сессия %s: %w", session.ID, err)
+		}
+		summaries = append(summaries, ingestSummary{Session: session.ID, Added: len(result.Logs), Errors: len(result.Errors)})
+	}
+	return summaries, nil
+}
+
+// mergeLineAttributes - JSON строка с добавленными полями, которых в ней нет;
+// исходные поля и их порядок сохраняются. Строки, не являющиеся JSON объектом,
+// возвращаются без изменений (разбор запишет их в ошибки). Переводы строк
+// внутри строки заменяются пробелами: одна строка - одна запись.
+func mergeLineAttributes(line ingestLine) string {
+	text := strings.TrimSpace(strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(line.Line))
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &fields); err != nil || fields == nil {
+		return text
+	}
+
+	extra := make(map[string]interface{}, len(line.Attributes)+1)
+	for name, value := range line.Attributes {
+		if _, exists := fields[name]; !exists && name != "" {
+			extra[name] = value
+		}
+	}
+	if _, exists := fields["@timestamp"]; !exists && !line.Timestamp.IsZero() {
+		extra["@timestamp"] = line.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if len(extra) == 0 {
+		return text
+	}
+
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		value, _ := json.Marshal(extra[name])
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	rest := strings.TrimSpace(text[1:])
+	if rest != "}" {
+		b.WriteByte(',')
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+// textPayloadLine - JSON строка Terraform из текста (после префикса вида
+// "terraform: ", если он есть) или весь текст как @message
+func textPayloadLine(text string) string {
+	text = strings.TrimSpace(text)
+	if start := strings.IndexByte(text, '{'); start >= 0 {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(text[start:]), &object); err == nil && object != nil {
+			return text[start:]
+		}
+	}
+	return otlpBodyLine(text)
+}
+
+// FindOrCreate - сессия для логов сборщика: key - ID или название сессии
+// ("" - сессия по умолчанию); если такой нет, создается сессия с этим названием
+func (r *SessionRegistry) FindOrCreate(key, source string) (Session, error) {
+	if key == "" {
+		key = defaultSessionID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if session, exists := r.sessions[key]; exists {
+		return *session, nil
+	}
+	var found *Session
+	for _, session := range r.sessions {
+		if session.Name == key && (found == nil || session.CreatedAt.Before(found.CreatedAt)) {
+			found = session
+		}
+	}
+	if found != nil {
+		return *found, nil
+	}
+	return r.create(r.unusedID(), key, source, nil)
+}
+
+// readPushBody - тело запроса сборщика с учетом Content-Encoding (gzip)
+// и ограничения maxPushBytes
+func readPushBody(r *http.Request) ([]byte, error) {
+	body := io.Reader(http.MaxBytesReader(nil, r.Body, maxPushBytes))
+	switch encoding := strings.ToLower(r.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return nil, fmt.Errorf("неподдерживаемый Content-Encoding: %s", encoding)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxPushBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPushBytes {
+		return nil, fmt.Errorf("тело запроса больше %d МБ", maxPushBytes>>20)
+	}
+	return data, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storageLimits - ограничения объема хранимых логов (0 - без ограничения)
+type storageLimits struct {
+	MaxEntries      int           // записей в одной сессии
+	MaxSessionBytes int64         // объем одной сессии
+	MaxTotalBytes   int64         // объем всех сессий
+	SessionTTL      time.Duration // время жизни новой сессии
+}
+
+// limitLowWater - доля ограничения записей и объема, до которой вытесняются
+// данные при его превышении: следующее вытеснение понадобится только после
+// прироста на 10%, а не на каждой записи
+const limitLowWater = 0.9
+
+// lowWater - уровень, до которого вытесняются данные при превышении limit
+func lowWater(limit int64) int64 {
+	return max(int64(float64(limit)*limitLowWater), 1)
+}
+
+// byteSize - флаг с объемом вида 512MB, 2GiB или числом байт
+type byteSize struct {
+	value *int64
+}
+
+func (b byteSize) String() string {
+	if b.value == nil || *b.value == 0 {
+		return ""
+	}
+	return strconv.FormatInt(*b.value, 10)
+}
+
+func (b byteSize) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b.value = size
+	return nil
+}
+
+// parseByteSize - объем с необязательным суффиксом: B, KB, MB, GB (степени 1024, KiB и т.п. тоже)
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("неверный объем: %s", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// registerLimitFlags - флаги ограничений хранилища для командной строки
+func registerLimitFlags(fs *flag.FlagSet) *storageLimits {
+	limits := &storageLimits{}
+
+	fs.IntVar(&limits.MaxEntries, "max-entries", 0, "максимум записей в сессии, при превышении старые вытесняются до 90% (0 - без ограничения)")
+	fs.Var(byteSize{&limits.MaxSessionBytes}, "max-session-bytes", "максимальный объем сессии, например 256MB")
+	fs.Var(byteSize{&limits.MaxTotalBytes}, "max-total-bytes", "максимальный объем всех сессий; вытесняются самые старые записи всех сессий")
+	fs.Func("session-ttl", "время жизни сессии, например 12h или 7d (0 - бессрочно)", func(value string) error {
+		ttl, err := parseDurationWithDays(value)
+		if err != nil {
+			return fmt.Errorf("неверное время жизни: %s", value)
+		}
+		limits.SessionTTL = ttl
+		return nil
+	})
+
+	return limits
+}
+
+// limitedStore - хранилище сессии, после каждой записи применяющее ограничения реестра
+type limitedStore struct {
+	LogStore
+	registry *SessionRegistry
+	id       string
+}
+
+func (s *limitedStore) Append(result ParseResult) (int, error) {
+	if _, err := s.LogStore.Append(result); err != nil {
+		return 0, err
+	}
+	if err := s.registry.enforceLimits(s.id); err != nil {
+		return 0, err
+	}
+	return s.Usage().Entries, nil
+}
+
+func (s *limitedStore) Replace(result ParseResult) error {
+	if err := s.LogStore.Replace(result); err != nil {
+		return err
+	}
+	return s.registry.enforceLimits(s.id)
+}
+
+func (s *limitedStore) Restore(result ParseResult, firstID int) error {
+	if err := s.LogStore.Restore(result, firstID); err != nil {
+		return err
+	}
+	return s.registry.enforceLimits(s.id)
+}
+
+// RegistryUsage - объем данных всех сессий
+type RegistryUsage struct {
+	Sessions int
+	Entries  int
+	Bytes    int64
+}
+
+// Usage - суммарный объем данных всех сессий
+func (r *SessionRegistry) Usage() RegistryUsage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.usageLocked()
+}
+
+func (r *SessionRegistry) usageLocked() RegistryUsage {
+	usage := RegistryUsage{Sessions: len(r.sessions)}
+	for _, session := range r.sessions {
+		storeUsage := session.Store.Usage()
+		usage.Entries += storeUsage.Entries
+		usage.Bytes += storeUsage.Bytes
+	}
+	return usage
+}
+
+// enforceLimits - вытеснение после записи в сессию current: сначала старые
+// записи самой сессии по ее ограничениям, затем, если превышен общий объем,
+// самые старые по времени записи всех сессий (см. evictionPlan). Сессии не
+// удаляются: пропадают только вытесненные записи и их пометки. Записи
+// вытесняются до limitLowWater от ограничения. Trim хранилища на диске пишет
+// манифест, поэтому вытеснение идет без блокировки реестра.
+func (r *SessionRegistry) enforceLimits(current string) error {
+	r.mu.RLock()
+	session, exists := r.sessions[current]
+	r.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+	usage := session.Store.Usage()
+	entriesExceeded := r.limits.MaxEntries > 0 && usage.Entries > r.limits.MaxEntries
+	bytesExceeded := r.limits.MaxSessionBytes > 0 && usage.Bytes > r.limits.MaxSessionBytes
+	if entriesExceeded || bytesExceeded {
+		var maxEntries int
+		var maxBytes int64
+		if r.limits.MaxEntries > 0 {
+			maxEntries = int(lowWater(int64(r.limits.MaxEntries)))
+		}
+		if r.limits.MaxSessionBytes > 0 {
+			maxBytes = lowWater(r.limits.MaxSessionBytes)
+		}
+		if _, err := session.Store.Trim(maxEntries, maxBytes); err != nil {
+			return err
+		}
+	}
+	if r.limits.MaxTotalBytes <= 0 || r.Usage().Bytes <= r.limits.MaxTotalBytes {
+		return nil
+	}
+
+	// Одновременные записи в разные сессии не вытесняют один и тот же избыток дважды
+	r.evictMu.Lock()
+	defer r.evictMu.Unlock()
+	r.mu.RLock()
+	total := r.usageLocked().Bytes
+	order := r.evictionOrder()
+	r.mu.RUnlock()
+	if total <= r.limits.MaxTotalBytes {
+		return nil
+	}
+
+	for i, keep := range evictionPlan(order, total-lowWater(r.limits.MaxTotalBytes)) {
+		candidate := order[i]
+		if keep < 0 {
+			continue
+		}
+		maxBytes := int64(0)
+		if keep == 0 {
+			maxBytes = 1 // Trim с 0 не ограничивает; 1 байт вытесняет все записи
+		}
+		evicted, err := candidate.Store.Trim(keep, maxBytes)
+		if err != nil {
+			if candidate == session {
+				return err
+			}
+			log.Printf("Сессия %s: не удалось вытеснить записи: %v", candidate.ID, err)
+			continue
+		}
+		if evicted > 0 {
+			log.Printf("Сессия %s (%s): вытеснено записей: %d, превышен общий объем хранилища", candidate.ID, candidate.Name, evicted)
+		}
+	}
+	return nil
+}
+
+// evictionPlan - сколько записей оставить в каждой сессии order, чтобы
+// вытеснить excess байт: вытесняются записи с самым ранним временем среди
+// первых записей сессий, при равном - из сессии раньше в order. -1 - из
+// сессии ничего не вытесняется.
+func evictionPlan(order []*Session, excess int64) []int {
+	logs := make([][]TerraformLog, len(order))
+	for i, session := range order {
+		if snapshot := session.Store.Snapshot(); snapshot != nil {
+			logs[i] = snapshot.Logs
+		}
+	}
+	evicted := make([]int, len(order))
+	for excess > 0 {
+		oldest := -1
+		for i := range logs {
+			if evicted[i] == len(logs[i]) {
+				continue
+			}
+			if oldest < 0 || logs[i][evicted[i]].Timestamp.Before(logs[oldest][evicted[oldest]].Timestamp) {
+				oldest = i
+			}
+		}
+		if oldest < 0 {
+			break
+		}
+		excess -= logSize(&logs[oldest][evicted[oldest]])
+		evicted[oldest]++
+	}
+
+	keep := make([]int, len(order))
+	for i := range keep {
+		keep[i] = -1
+		if evicted[i] > 0 {
+			keep[i] = len(logs[i]) - evicted[i]
+		}
+	}
+	return keep
+}
+
+// evictionOrder - сессии с данными от самой старой; вызывается под блокировкой
+func (r *SessionRegistry) evictionOrder() []*Session {
+	var order []*Session
+	for _, session := range r.sessions {
+		if session.Store.Usage().Entries > 0 {
+			order = append(order, session)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return order[i].CreatedAt.Before(order[j].CreatedAt)
+	})
+	return order
+}
+
+// expireSessions - удаление сессий с истекшим временем жизни
+func (r *SessionRegistry) expireSessions(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, session := range r.sessions {
+		if session.ExpiresAt == nil || session.ExpiresAt.After(now) {
+			continue
+		}
+		if err := r.deleteLocked(id); err != nil {
+			log.Printf("Не удалось удалить устаревшую сессию %s: %v", id, err)
+			continue
+		}
+		log.Printf("Сессия %s (%s) удалена: истекло время жизни", id, session.Name)
+	}
+}
+
+// runExpiry - периодическая проверка времени жизни сессий
+func (r *SessionRegistry) runExpiry(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		r.expireSessions(now)
+	}
+}
+
+// storageUsage - объем данных сессии и всего хранилища с ограничениями для /api/status
+func storageUsage(store LogStore) map[string]interface{} {
+	ttl := ""
+	if sessions.limits.SessionTTL > 0 {
+		ttl = sessions.limits.SessionTTL.String()
+	}
+	return map[string]interface{}{
+		"session": store.Usage(),
+		"total":   sessions.Usage(),
+		"limits": map[string]interface{}{
+			"max_entries":       sessions.limits.MaxEntries,
+			"max_session_bytes": sessions.limits.MaxSessionBytes,
+			"max_total_bytes":   sessions.limits.MaxTotalBytes,
+			"session_ttl":       ttl,
+		},
+	}
+}
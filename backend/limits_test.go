@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStoreTrimKeepsIDs(t *testing.T) {
+	store := NewMemoryStore()
+	store.Append(parseSample(t, 5))
+
+	if n, _ := store.Trim(3, 0); n != 2 {
+		t.Fatalf("вытеснено %d, ожидалось 2", n)
+	}
+	store.Append(parseSample(t, 1))
+
+	snapshot := store.Snapshot()
+	if len(snapshot.Logs) != 4 || snapshot.Logs[0].ID != 2 || snapshot.Logs[3].ID != 5 {
+		t.Errorf("неверные записи после вытеснения: %d, ID %d..%d", len(snapshot.Logs), snapshot.Logs[0].ID, snapshot.Logs[len(snapshot.Logs)-1].ID)
+	}
+	if snapshot.Stats.ByLevel["info"] != 4 {
+		t.Errorf("статистика не учитывает вытеснение: %+v", snapshot.Stats)
+	}
+	if findLogByID(snapshot.Logs, 4) == nil {
+		t.Error("запись не находится по ID после вытеснения")
+	}
+
+	usage := store.Usage()
+	if usage.Evicted != 2 || usage.Bytes <= 0 {
+		t.Errorf("неверный объем: %+v", usage)
+	}
+	if n, _ := store.Trim(0, 1); n != 4 || store.Usage().Bytes != 0 {
+		t.Errorf("ограничение объема должно вытеснить все записи: %d, %+v", n, store.Usage())
+	}
+}
+
+func TestRegistryEvictsOldestEntries(t *testing.T) {
+	probe := NewMemoryStore()
+	probe.Append(parseSample(t, 10))
+	size := probe.Usage().Bytes
+
+	dir := t.TempDir()
+	backend, err := NewDiskBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := storageLimits{MaxTotalBytes: 2 * size}
+	registry, err := NewSessionRegistry(backend, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		session, _ := registry.Create(fmt.Sprint("s", i), "test", nil)
+		if _, err := session.Store.Append(parseSample(t, 10)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.Annotations.Add(9, []string{"root-cause"}, ""); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+	}
+	if usage := registry.Usage(); usage.Bytes > 2*size {
+		t.Errorf("общий объем %d превышает ограничение %d", usage.Bytes, 2*size)
+	}
+
+	// Время записей одинаковое: вытесняются записи самой старой сессии, затем
+	// следующей; сессии остаются, пометки остаются у невытесненных записей
+	oldest, exists := registry.Get(ids[0])
+	if !exists {
+		t.Fatal("самая старая сессия удалена")
+	}
+	if n := oldest.Store.Usage().Entries; n != 0 || len(oldest.Annotations.List()) != 0 {
+		t.Errorf("в самой старой сессии осталось %d записей, пометок %d", n, len(oldest.Annotations.List()))
+	}
+	if middle, _ := registry.Get(ids[1]); middle.Store.Usage().Entries == 0 || len(middle.Annotations.List()) != 1 {
+		t.Errorf("вытеснено лишнее из второй сессии: %d записей, пометок %d", middle.Store.Usage().Entries, len(middle.Annotations.List()))
+	}
+	if latest, _ := registry.Get(ids[2]); latest.Store.Usage().Entries != 10 {
+		t.Errorf("вытеснены записи новой сессии: %d", latest.Store.Usage().Entries)
+	}
+	if err := registry.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewSessionRegistry(backend, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, exists := reloaded.Get(id); !exists {
+			t.Errorf("сессия %s не сохранилась на диске", id)
+		}
+	}
+}
+
+func TestRegistryEvictsByEntryTime(t *testing.T) {
+	timed := func(hour int) ParseResult {
+		var b strings.Builder
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&b, `{"@level":"info","@message":"line %d","@timestamp":"2025-09-09T%02d:00:%02d.000000Z"}`+"\n", i, hour, i)
+		}
+		return NewLogParser().ParseStream(strings.NewReader(b.String()))
+	}
+	probe := NewMemoryStore()
+	probe.Append(timed(12))
+	size := probe.Usage().Bytes
+
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{MaxTotalBytes: size * 3 / 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, _ := registry.Create("live", "test", nil)
+	live.Store.Append(timed(12))
+	// Сессия создана позже, но ее записи старше: вытесняются они
+	archive, _ := registry.Create("archive", "test", nil)
+	archive.Store.Append(timed(10))
+
+	if n := live.Store.Usage().Entries; n != 10 {
+		t.Errorf("вытеснены новые записи: осталось %d", n)
+	}
+	if n := archive.Store.Usage().Entries; n == 0 || n == 10 {
+		t.Errorf("в сессии со старыми записями осталось %d записей", n)
+	}
+	if usage := registry.Usage(); usage.Bytes > size*3/2 || usage.Sessions != 3 {
+		t.Errorf("общий объем %d (ограничение %d), сессий %d", usage.Bytes, size*3/2, usage.Sessions)
+	}
+}
+
+func TestMemoryStoreEvictionAmortized(t *testing.T) {
+	store := NewMemoryStore()
+	store.Append(parseSample(t, 8))
+	before := store.Snapshot()
+
+	// Вытеснение отрезает записи без копирования массива
+	store.Trim(7, 0)
+	after := store.Snapshot()
+	if &after.Logs[0] != &before.Logs[1] {
+		t.Error("вытеснение одной записи не должно копировать остальные")
+	}
+
+	// Когда вытесненных в массиве больше, чем оставшихся, массив копируется
+	store.Trim(3, 0)
+	if store.dead > len(store.result.Logs) {
+		t.Errorf("вытесненных в массиве %d при %d оставшихся", store.dead, len(store.result.Logs))
+	}
+	compacted := store.Snapshot()
+	if &compacted.Logs[0] == &before.Logs[5] {
+		t.Error("массив с вытесненными записями должен быть скопирован")
+	}
+
+	// Выданные снимки не меняются ни вытеснением, ни дозагрузкой
+	store.Append(parseSample(t, 4))
+	store.Trim(2, 0)
+	if len(before.Logs) != 8 || before.Logs[0].ID != 0 || len(after.Logs) != 7 || after.Logs[6].ID != 7 {
+		t.Errorf("снимки изменились: %d записей, %d записей", len(before.Logs), len(after.Logs))
+	}
+	logs := store.Snapshot().Logs
+	if len(logs) != 2 || logs[0].ID != 10 || logs[1].ID != 11 {
+		t.Errorf("после вытеснения и дозагрузки: %+v", logs)
+	}
+}
+
+func TestRegistryTrimsToLowWater(t *testing.T) {
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := registry.Create("stream", "test", nil)
+
+	tests := []struct {
+		append  int
+		entries int
+		evicted int
+	}{
+		{10, 10, 0},
+		// Превышение вытесняет до 90% ограничения
+		{1, 9, 2},
+		// Следующие записи до ограничения ничего не вытесняют
+		{1, 10, 2},
+		{1, 9, 4},
+		{25, 9, 29},
+	}
+	for i, tt := range tests {
+		if _, err := session.Store.Append(parseSample(t, tt.append)); err != nil {
+			t.Fatal(err)
+		}
+		if usage := session.Store.Usage(); usage.Entries != tt.entries || usage.Evicted != tt.evicted {
+			t.Errorf("шаг %d: записей %d, вытеснено %d; ожидалось %d и %d", i, usage.Entries, usage.Evicted, tt.entries, tt.evicted)
+		}
+	}
+
+	for limit, want := range map[int64]int64{1: 1, 5: 4, 10: 9, 1000: 900} {
+		if got := lowWater(limit); got != want {
+			t.Errorf("lowWater(%d) = %d, ожидалось %d", limit, got, want)
+		}
+	}
+}
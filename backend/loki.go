@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lokiSessionLabel - метка потока с ID или названием сессии; остальные метки
+// становятся полями записей
+const lokiSessionLabel = "session"
+
+// lokiStream - поток Loki: метки и строки
+type lokiStream struct {
+	Labels  map[string]string
+	Entries []lokiEntry
+}
+
+// lokiEntry - строка потока; Metadata - structured metadata Loki 3
+type lokiEntry struct {
+	Timestamp time.Time
+	Line      string
+	Metadata  map[string]string
+}
+
+// Обработчик Loki push API: POST /loki/api/v1/push от Promtail, Vector,
+// Grafana Alloy. Тело - JSON (application/json) или protobuf, сжатый snappy
+// (application/x-protobuf). Строки потока с меткой session попадают в сессию
+// с этим ID или названием (новая сессия создается), без метки - в сессию по умолчанию.
+func handleLokiPush(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := readPushBody(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	var streams []lokiStream
+	if strings.Contains(r.Header.Get("Content-Type"), "json") {
+		streams, err = decodeLokiJSON(body)
+	} else {
+		streams, err = decodeLokiProto(body)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	summaries, err := lokiBatch(streams).store(sessions, "loki push")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	for _, summary := range summaries {
+		fmt.Fprintf(requestOutput(r), " Loki push: сессия %s, записей %d, ошибок разбора %d\n", summary.Session, summary.Added, summary.Errors)
+	}
+	// Как Loki: успешный push без тела
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lokiBatch - строки потоков по сессиям из метки session; остальные метки
+// и метаданные строки становятся полями записей
+func lokiBatch(streams []lokiStream) *ingestBatch {
+	batch := newIngestBatch()
+	for _, stream := range streams {
+		session := stream.Labels[lokiSessionLabel]
+		for _, entry := range stream.Entries {
+			attributes := make(map[string]interface{}, len(stream.Labels)+len(entry.Metadata))
+			for name, value := range stream.Labels {
+				if name != lokiSessionLabel {
+					attributes[name] = value
+				}
+			}
+			for name, value := range entry.Metadata {
+				attributes[name] = value
+			}
+			batch.add(session, ingestLine{Line: entry.Line, Timestamp: entry.Timestamp, Attributes: attributes})
+		}
+	}
+	return batch
+}
+
+// decodeLokiJSON - {"streams": [{"stream": {метки}, "values": [["<unix ns>", "строка", {метаданные}]]}]}
+func decodeLokiJSON(body []byte) ([]lokiStream, error) {
+	var request struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("некорректный JSON: %v", err)
+	}
+
+	streams := make([]lokiStream, 0, len(request.Streams))
+	for _, item := range request.Streams {
+		stream := lokiStream{Labels: item.Stream}
+		for i, value := range item.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("values[%d]: ожидается [время, строка]", i)
+			}
+			var timestamp, line string
+			if err := json.Unmarshal(value[0], &timestamp); err != nil {
+				return nil, fmt.Errorf("values[%d]: время - строка с наносекундами Unix", i)
+			}
+			if err := json.Unmarshal(value[1], &line); err != nil {
+				return nil, fmt.Errorf("values[%d]: строка лога должна быть строкой", i)
+			}
+			nanos, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("values[%d]: некорректное время %q", i, timestamp)
+			}
+			entry := lokiEntry{Timestamp: time.Unix(0, nanos), Line: line}
+			if len(value) > 2 {
+				if err := json.Unmarshal(value[2], &entry.Metadata); err != nil {
+					return nil, fmt.Errorf("values[%d]: метаданные - объект со строковыми значениями", i)
+				}
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// decodeLokiProto - PushRequest из logproto, сжатый snappy:
+// PushRequest{streams=1}, Stream{labels=1, entries=2},
+// Entry{timestamp=1, line=2, structuredMetadata=3}, LabelPair{name=1, value=2}
+func decodeLokiProto(body []byte) ([]lokiStream, error) {
+	data, err := snappyDecode(body, maxPushBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []lokiStream
+	err = walkProto(data, func(field protoField) error {
+		if field.Num != 1 || field.Wire != protoBytes {
+			return nil
+		}
+		stream, err := decodeLokiProtoStream(field.Bytes)
+		if err != nil {
+			return err
+		}
+		streams = append(streams, stream)
+		return nil
+	})
+	return streams, err
+}
+
+func decodeLokiProtoStream(data []byte) (lokiStream, error) {
+	var stream lokiStream
+	err := walkProto(data, func(field protoField) error {
+		if field.Wire != protoBytes {
+			return nil
+		}
+		switch field.Num {
+		case 1:
+			labels, err := parseLokiLabels(field.String())
+			if err != nil {
+				return err
+			}
+			stream.Labels = labels
+		case 2:
+			entry, err := decodeLokiProtoEntry(field.Bytes)
+			if err != nil {
+				return err
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		return nil
+	})
+	return stream, err
+}
+
+func decodeLokiProtoEntry(data []byte) (lokiEntry, error) {
+	var entry lokiEntry
+	err := walkProto(data, func(field protoField) error {
+		if field.Wire != protoBytes {
+			return nil
+		}
+		switch field.Num {
+		case 1:
+			var seconds, nanos int64
+			err := walkProto(field.Bytes, func(part protoField) error {
+				switch part.Num {
+				case 1:
+					seconds = part.Int64()
+				case 2:
+					nanos = part.Int64()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			entry.Timestamp = time.Unix(seconds, nanos)
+		case 2:
+			entry.Line = field.String()
+		case 3:
+			var name, value string
+			err := walkProto(field.Bytes, func(part protoField) error {
+				switch part.Num {
+				case 1:
+					name = part.String()
+				case 2:
+					value = part.String()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if entry.Metadata == nil {
+				entry.Metadata = make(map[string]string)
+			}
+			entry.Metadata[name] = value
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// parseLokiLabels - метки в формате Prometheus: {job="terraform", env="prod"}
+func parseLokiLabels(text string) (map[string]string, error) {
+	labels := make(map[string]string)
+	rest := strings.TrimSpace(text)
+	if !strings.HasPrefix(rest, "{") || !strings.HasSuffix(rest, "}") {
+		return nil, fmt.Errorf("метки потока: ожидается {имя=\"значение\", ...}, получено %s", text)
+	}
+	rest = strings.TrimSpace(rest[1 : len(rest)-1])
+
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		quoted := ""
+		if eq > 0 {
+			quoted = strings.TrimLeft(rest[eq+1:], " ")
+		}
+		name := strings.TrimSpace(rest[:max(eq, 0)])
+		if name == "" || !strings.HasPrefix(quoted, `"`) {
+			return nil, fmt.Errorf("метки потока: некорректная метка в %s", text)
+		}
+		value, tail, err := unquoteLabelValue(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("метки потока: %s: %v", name, err)
+		}
+		labels[name] = value
+
+		rest = strings.TrimSpace(tail)
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("метки потока: ожидается запятая в %s", text)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+	return labels, nil
+}
+
+// unquoteLabelValue - значение в кавычках с экранированием \\, \" и \n
+// и остаток строки после закрывающей кавычки
+func unquoteLabelValue(text string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"':
+			return b.String(), text[i+1:], nil
+		case '\\':
+			i++
+			if i == len(text) {
+				return "", "", errors.New("незавершенное экранирование")
+			}
+			switch text[i] {
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("нет закрывающей кавычки")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLokiProtoPush(t *testing.T) {
+	timestamp := binary.AppendUvarint([]byte{1 << 3}, 1704103200)
+	metadata := protoAppend(protoAppend(nil, 1, []byte("trace_id")), 2, []byte("abc"))
+	entry := protoAppend(nil, 1, timestamp)
+	entry = protoAppend(entry, 2, []byte(`{"@level":"error","@message":"boom","env":"own"}`))
+	entry = protoAppend(entry, 3, metadata)
+	stream := protoAppend(nil, 1, []byte(`{job="terraform", env="prod", session="nightly", note="a \"quoted\" value"}`))
+	stream = protoAppend(stream, 2, entry)
+	request := protoAppend(nil, 1, stream)
+
+	streams, err := decodeLokiProto(snappyLiterals(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 1 || streams[0].Labels["note"] != `a "quoted" value` || len(streams[0].Entries) != 1 {
+		t.Fatalf("неверный разбор: %+v", streams)
+	}
+
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		summaries, err := lokiBatch(streams).store(registry, "loki push")
+		if err != nil || len(summaries) != 1 || summaries[0].Added != 1 {
+			t.Fatalf("сохранение: %+v, %v", summaries, err)
+		}
+	}
+	// Повторный push попадает в ту же сессию по названию
+	if got := len(registry.List()); got != 2 {
+		t.Fatalf("сессий %d, ожидалось 2 (default и nightly)", got)
+	}
+	session, _ := registry.FindOrCreate("nightly", "")
+	logs := session.Store.Snapshot().Logs
+	attrs := logAttributes(logs[1])
+	if logs[1].ID != 1 || logs[1].Message() != "boom" || attrs["job"] != "terraform" || attrs["env"] != "own" || attrs["trace_id"] != "abc" || attrs["session"] != nil {
+		t.Errorf("поля записи: %+v %v", logs[1], attrs)
+	}
+	if want := time.Unix(1704103200, 0); !logs[0].Timestamp.Equal(want) {
+		t.Errorf("время записи %v, ожидалось %v", logs[0].Timestamp, want)
+	}
+}
+
+func TestParseLokiLabels(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{`{}`, "map[]", ""},
+		{` { job="terraform" , env = "prod" } `, "map[env:prod job:terraform]", ""},
+		{`{note="a \"b\" \\ c\nd"}`, "map[note:a \"b\" \\ c\nd]", ""},
+		{`job="terraform"`, "", "ожидается {"},
+		{`{job=terraform}`, "", "некорректная метка"},
+		{`{="x"}`, "", "некорректная метка"},
+		{`{job="terraform}`, "", "нет закрывающей кавычки"},
+		{`{job="a\`, "", "ожидается {"},
+		{`{job="a\}`, "", "незавершенное экранирование"},
+		{`{ ="x"}`, "", "некорректная метка"},
+		{`{job="a" env="b"}`, "", "ожидается запятая"},
+		{`{job=}`, "", "некорректная метка"},
+	}
+	for _, test := range tests {
+		labels, err := parseLokiLabels(test.input)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: ошибка %v, ожидалось %q", test.input, err, test.err)
+			}
+			continue
+		}
+		if got := fmt.Sprint(labels); err != nil || got != test.want {
+			t.Errorf("%s: %q, %v", test.input, got, err)
+		}
+	}
+}
+
+func TestDecodeLokiJSONErrors(t *testing.T) {
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{`{"streams": [`, "некорректный JSON"},
+		{`{"streams": [{"stream": {"job": 1}}]}`, "некорректный JSON"},
+		{`{"streams": [{"values": [["1"]]}]}`, "ожидается [время, строка]"},
+		{`{"streams": [{"values": [[1, "line"]]}]}`, "строка с наносекундами"},
+		{`{"streams": [{"values": [["1", 2]]}]}`, "должна быть строкой"},
+		{`{"streams": [{"values": [["вчера", "line"]]}]}`, "некорректное время"},
+		{`{"streams": [{"values": [["99999999999999999999", "line"]]}]}`, "некорректное время"},
+		{`{"streams": [{"values": [["1", "line", {"a": 1}]]}]}`, "метаданные"},
+	}
+	for _, test := range tests {
+		if _, err := decodeLokiJSON([]byte(test.body)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: ошибка %v, ожидалось %q", test.body, err, test.err)
+		}
+	}
+
+	streams, err := decodeLokiJSON([]byte(`{"streams": [{"stream": {"session": "ci"}, "values": [["1704103200000000001", "line", {"trace_id": "abc"}]]}]}`))
+	if err != nil || len(streams) != 1 || streams[0].Entries[0].Timestamp.UnixNano() != 1704103200000000001 || streams[0].Entries[0].Metadata["trace_id"] != "abc" {
+		t.Errorf("корректный запрос: %+v, %v", streams, err)
+	}
+}
+
+func TestDecodeLokiProtoErrors(t *testing.T) {
+	badLabels := protoAppend(nil, 1, protoAppend(nil, 1, []byte(`job="x"`)))
+	badEntry := protoAppend(nil, 1, protoAppend(nil, 2, []byte{1 << 3, 0x80}))
+	for name, body := range map[string][]byte{
+		"не snappy":            []byte("plain"),
+		"обрезанный protobuf":  snappyLiterals([]byte{1<<3 | protoBytes, 10, 'a'}),
+		"некорректные метки":   snappyLiterals(badLabels),
+		"обрезанная строка":    snappyLiterals(badEntry),
+		"распакованное больше": binary.AppendUvarint(nil, maxPushBytes+1),
+	} {
+		if _, err := decodeLokiProto(body); err == nil {
+			t.Errorf("%s: ошибки нет", name)
+		}
+	}
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -37,7 +41,7 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		// Если источник разрешен, добавляем CORS заголовки
 		if allowed {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, DELETE")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, DELETE, PATCH")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		}
 
@@ -65,6 +69,9 @@ type ParseResult struct {
 	Stats  ParseStats
 	Logs   []TerraformLog
 	Errors []ParseError
+	// index - уже вычисленные производные данные Logs (пакет сессии); nil -
+	// хранилище вычисляет их при записи, см. entryIndex
+	index *entryIndex
 }
 
 type ParseError struct {
@@ -73,27 +80,23 @@ type ParseError struct {
 	Error      error
 }
 
+// TerraformLog - запись лога. Сообщение, уровень, модуль, caller, tf_req_id,
+// RPC, версия протокола, провайдер и тип записи читаются методами: у записей
+// хранилища они лежат в колонках блока, см. compact.go; исходная строка - rawJSON.
+// В JSON запись выглядит как прежде.
 type TerraformLog struct {
-	Level          string
-	Message        string
-	Module         string
-	Caller         string
-	Timestamp      time.Time
-	TfReqID        string
-	TfRPC          string
-	TfProtoVersion string
-	TfProviderAddr string
-	EntryType      string
-	RawJSON        string
+	ID        int // порядковый номер записи в наборе, не меняется при дозагрузке
+	Timestamp time.Time
+
+	pending *pendingLog // поля записи до передачи хранилищу
+
+	raw rawLine // заполняется хранилищем: строка записи в колонках блока, см. compact.go
 }
 
 type LogParser struct {
 	stats ParseStats
 }
 
-// Глобальная переменная для хранения последних результатов
-var currentResult *ParseResult
-
 func NewLogParser() *LogParser {
 	return &LogParser{
 		stats: ParseStats{
@@ -130,6 +133,7 @@ func (p *LogParser) ParseStream(reader io.Reader) ParseResult {
 			continue
 		}
 
+		logEntry.ID = p.stats.SuccessLines
 		result.Logs = append(result.Logs, logEntry)
 		p.stats.SuccessLines++
 		p.updateStats(logEntry)
@@ -180,14 +184,15 @@ func (p *LogParser) parseLine(line string) (TerraformLog, error) {
 	}
 
 	// Обрабатываем основные поля
-	logEntry.Level = getString(rawData, "@level")
-	logEntry.Message = getString(rawData, "@message")
-	logEntry.Module = getString(rawData, "@module")
-	logEntry.Caller = getString(rawData, "@caller")
-	logEntry.TfReqID = getString(rawData, "tf_req_id")
-	logEntry.TfRPC = getString(rawData, "tf_rpc")
-	logEntry.TfProtoVersion = getString(rawData, "tf_proto_version")
-	logEntry.TfProviderAddr = getString(rawData, "tf_provider_addr")
+	fields := logEntry.edit()
+	fields.names[nameLevel] = getString(rawData, "@level")
+	fields.texts[textMessage] = getString(rawData, "@message")
+	fields.names[nameModule] = getString(rawData, "@module")
+	fields.names[nameCaller] = getString(rawData, "@caller")
+	fields.texts[textTfReqID] = getString(rawData, "tf_req_id")
+	fields.names[nameTfRPC] = getString(rawData, "tf_rpc")
+	fields.names[nameTfProtoVersion] = getString(rawData, "tf_proto_version")
+	fields.names[nameTfProviderAddr] = getString(rawData, "tf_provider_addr")
 
 	// Парсим timestamp
 	if tsStr := getString(rawData, "@timestamp"); tsStr != "" {
@@ -197,10 +202,11 @@ func (p *LogParser) parseLine(line string) (TerraformLog, error) {
 	}
 
 	// Определяем тип записи
-	logEntry.EntryType = p.classifyEntry(logEntry, rawData)
+	fields.names[nameEntryType] = p.classifyEntry(logEntry, rawData)
 
-	// Сохраняем оригинальный JSON для ленивой загрузки
-	logEntry.RawJSON = line
+	// Сохраняем оригинальный JSON: атрибуты для запросов разбираются из него
+	// по требованию, см. logAttributes
+	fields.rawJSON = line
 
 	return logEntry, nil
 }
@@ -208,18 +214,18 @@ func (p *LogParser) parseLine(line string) (TerraformLog, error) {
 // classifyEntry - классификация типа записи
 func (p *LogParser) classifyEntry(log TerraformLog, rawData map[string]interface{}) string {
 	// HTTP запросы
-	if log.TfReqID != "" {
+	if log.TfReqID() != "" {
 		p.stats.HasHTTPRequests = true
 		return "http_request"
 	}
 
 	// GRPC запросы
-	if strings.Contains(log.Message, "GRPCProvider") || log.TfRPC != "" {
+	if strings.Contains(log.Message(), "GRPCProvider") || log.TfRPC() != "" {
 		return "grpc_request"
 	}
 
 	// Сообщения от провайдеров
-	if log.Module != "" && strings.Contains(log.Module, "provider") {
+	if log.Module() != "" && strings.Contains(log.Module(), "provider") {
 		return "provider"
 	}
 
@@ -228,9 +234,9 @@ func (p *LogParser) classifyEntry(log TerraformLog, rawData map[string]interface
 
 // updateStats - обновление статистики
 func (p *LogParser) updateStats(logEntry TerraformLog) {
-	p.stats.ByLevel[logEntry.Level]++
-	if logEntry.Module != "" {
-		p.stats.ByModule[logEntry.Module]++
+	p.stats.ByLevel[logEntry.Level()]++
+	if logEntry.Module() != "" {
+		p.stats.ByModule[logEntry.Module()]++
 	}
 }
 
@@ -245,23 +251,118 @@ func getString(data map[string]interface{}, key string) string {
 }
 
 // Серверные функции
+
+// sessionRoutes - эндпоинты, работающие с данными одной сессии
+var sessionRoutes = []struct {
+	path    string
+	handler http.HandlerFunc
+}{
+	{"/logs", handleAPILogs},
+	{"/logs/{id}/context", handleAPILogContext},
+	{"/logs/{id}/raw", handleAPILogRaw},
+	{"/status", handleAPIStatus},
+	{"/clear", handleAPIClear},
+	{"/requests/{id}", handleAPIRequest},
+	{"/timeline", handleAPITimeline},
+	{"/concurrency", handleAPIConcurrency},
+	{"/export", handleAPIExport},
+	{"/annotations", handleAPIAnnotations},
+	{"/annotations/{annotation}", handleAPIAnnotation},
+	{"/stream", handleAPIStream},
+	{"/traces", handleAPITraces},
+	{"/traces/{span}", handleAPITraceSpan},
+	{"/logs/{id}/span", handleAPILogSpan},
+}
+
 func startWebServer(port string) {
-	http.HandleFunc("/", handleMain)
-	http.HandleFunc("/upload", handleUpload)
-	http.HandleFunc("/api/logs", corsMiddleware(handleAPILogs))
-	http.HandleFunc("/api/status", corsMiddleware(handleAPIStatus))
-	http.HandleFunc("/api/clear", corsMiddleware(handleAPIClear))
+	registerRoutes()
+	go flushOnSignal()
 
 	fmt.Printf("Сервер запущен на http://localhost:%s\n", port)
 	fmt.Println("Веб-интерфейс: http://localhost:" + port)
 	fmt.Println("API эндпоинты:")
 	fmt.Println("   POST /api/logs    - отправить логи")
-	fmt.Println("   GET  /api/status  - получить статистику")
+	fmt.Println("   GET  /api/status  - получить статистику и объем хранилища")
+	fmt.Println("   GET  /api/logs/{id}/context - записи вокруг указанной (?before=&after=&same=)")
+	fmt.Println("   GET  /api/logs/{id}/raw - исходная строка записи (?decode=true)")
 	fmt.Println("   POST /api/clear   - очистить логи")
+	fmt.Println("   GET  /api/requests/{id} - все записи одного вызова (tf_req_id, tf_http_trans_id, ресурс)")
+	fmt.Println("   GET  /api/timeline - Gantt-данные и критический путь (?format=csv)")
+	fmt.Println("   GET  /api/concurrency - параллельность операций во времени (?step=, ?at=)")
+	fmt.Println("   GET  /api/sessions - список сессий, POST - создать сессию (?name=&source=&tags=)")
+	fmt.Println("   GET/PATCH/DELETE /api/sessions/{session} - описание, переименование, удаление")
+	fmt.Println("   GET  /api/annotations - пометки записей, POST - пометить запись; PATCH/DELETE /api/annotations/{annotation}")
+	fmt.Println("   GET  /api/stream  - новые записи по мере поступления (SSE или WebSocket, ?last_event_id=)")
+	fmt.Println("   GET  /api/export  - пакет сессии одним файлом, POST /api/sessions/import - загрузить пакет")
+	fmt.Println("   /api/sessions/{session}/logs, /status, ... - те же эндпоинты для выбранной сессии")
+	fmt.Println("   POST /loki/api/v1/push - прием логов от Promtail, Vector, Alloy (метка session - сессия)")
+	fmt.Println("   POST /v1/logs     - прием логов OTLP/HTTP (protobuf или JSON, атрибут session - сессия)")
+	fmt.Println("   POST /v1/traces   - прием спанов OTLP/HTTP; GET /api/traces, /api/traces/{span}, /api/logs/{id}/span - связь спанов и записей")
 
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
+// registerRoutes - маршруты веб-интерфейса и API и фоновое удаление
+// устаревших сессий; вызывается один раз перед запуском сервера
+func registerRoutes() {
+	addRoutes(http.DefaultServeMux)
+	go sessions.runExpiry(time.Minute)
+}
+
+// serverOutput - сообщения сервера о принятых данных; обработчики HTTP пишут
+// в вывод своего сервера, см. requestOutput
+var serverOutput io.Writer = os.Stdout
+
+// serverOutputKey - ключ контекста запроса с выводом сообщений сервера
+type serverOutputKey struct{}
+
+// withServerOutput - обработчики handler пишут сообщения о принятых данных в out
+func withServerOutput(handler http.Handler, out io.Writer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverOutputKey{}, out)))
+	})
+}
+
+// requestOutput - вывод сообщений сервера, принявшего запрос (по умолчанию serverOutput)
+func requestOutput(r *http.Request) io.Writer {
+	if out, ok := r.Context().Value(serverOutputKey{}).(io.Writer); ok {
+		return out
+	}
+	return serverOutput
+}
+
+// flushOnSignal - по Ctrl+C или SIGTERM отложенные записи сессий сохраняются
+// на диск перед выходом, см. LogStore.Flush
+func flushOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	if err := sessions.Flush(); err != nil {
+		log.Printf("Не удалось сохранить сессии: %v", err)
+	}
+	log.Printf("Сервер остановлен: %v", sig)
+	os.Exit(1)
+}
+
+// addRoutes - маршруты веб-интерфейса и API
+func addRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/", handleMain)
+	mux.HandleFunc("/upload", handleUpload)
+	mux.HandleFunc("/api/sessions", corsMiddleware(handleAPISessions))
+	mux.HandleFunc("/api/sessions/{session}", corsMiddleware(handleAPISession))
+	mux.HandleFunc("/api/sessions/import", corsMiddleware(handleAPIImport))
+	// Прием логов от сборщиков
+	mux.HandleFunc("/loki/api/v1/push", handleLokiPush)
+	mux.HandleFunc("/v1/logs", handleOTLPLogs)
+	mux.HandleFunc("/v1/traces", handleOTLPTraces)
+	// Маршруты данных работают с сессией по умолчанию (/api/logs)
+	// или с выбранной сессией (/api/sessions/{session}/logs)
+	for _, route := range sessionRoutes {
+		mux.HandleFunc("/api"+route.path, corsMiddleware(route.handler))
+		mux.HandleFunc("/api/sessions/{session}"+route.path, corsMiddleware(route.handler))
+	}
+}
+
 // Обработчик главной страницы
 func handleMain(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -319,8 +420,8 @@ curl -X POST http://localhost:8080/api/logs \<br>
     <hr>
 `)
 
-	if currentResult != nil {
-		displayWebResults(w, currentResult)
+	if snapshot := defaultStore().Snapshot(); snapshot != nil {
+		displayWebResults(w, snapshot)
 	}
 
 	fmt.Fprintf(w, `</body></html>`)
@@ -346,100 +447,52 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 
 	parser := NewLogParser()
 	result := parser.ParseStream(file)
-	currentResult = &result
+	if err := loadDefaultSession(result, "upload: "+header.Filename); err != nil {
+		fmt.Fprintf(w, "Ошибка сохранения логов: %v<br><a href='/'>Назад</a>", err)
+		return
+	}
 
 	displayWebResults(w, &result)
 }
-func filterLogs(logs []TerraformLog, levelFilter, sinceFilter, untilFilter, searchFilter, moduleFilter, limitStr string) []TerraformLog {
+
+// filterLogs - отбор записей по фильтру с учетом лимита
+func filterLogs(logs []TerraformLog, filter LogFilter) []TerraformLog {
 	if len(logs) == 0 {
 		return logs
 	}
 
 	var filtered []TerraformLog
 
-	// Отладочная информация
-	if sinceFilter != "" {
-		sinceTime, err := parseTimeFlexible(sinceFilter)
-		if err != nil {
-			fmt.Printf("Ошибка парсинга since фильтра '%s': %v\n", sinceFilter, err)
-		} else {
-			fmt.Printf("since фильтр '%s' -> %v\n", sinceFilter, sinceTime)
-		}
-	}
-
-	if untilFilter != "" {
-		untilTime, err := parseTimeFlexible(untilFilter)
-		if err != nil {
-			fmt.Printf("Ошибка парсинга until фильтра '%s': %v\n", untilFilter, err)
-		} else {
-			fmt.Printf("until фильтр '%s' -> %v\n", untilFilter, untilTime)
-		}
-	}
-
-	for _, log := range logs {
-		// Фильтр по уровню
-		if levelFilter != "" && !strings.EqualFold(log.Level, levelFilter) {
+	for i := range logs {
+		if !filter.Match(&logs[i]) {
 			continue
 		}
-		// Фильтр по модулю (регистронезависимый)
-		if moduleFilter != "" {
-			if !strings.EqualFold(log.Module, moduleFilter) {
-				continue
-			}
-		}
-
-		// Фильтр по времени (с)
-		if sinceFilter != "" {
-			sinceTime, err := parseTimeFlexible(sinceFilter)
-			if err == nil && log.Timestamp.Before(sinceTime) {
-				continue
-			}
-		}
-
-		// Фильтр по времени (по)
-		if untilFilter != "" {
-			untilTime, err := parseTimeFlexible(untilFilter)
-			if err == nil && log.Timestamp.After(untilTime) {
-				continue
-			}
-		}
-
-		// Поиск по сообщению (регистронезависимый)
-		if searchFilter != "" {
-			if !strings.Contains(strings.ToLower(log.Message), strings.ToLower(searchFilter)) {
-				continue
-			}
-		}
-
-		filtered = append(filtered, log)
-	}
+		filtered = append(filtered, logs[i])
 
-	// Применяем лимит
-	if limitStr != "" {
-		if limit, err := strconv.Atoi(limitStr); err == nil && limit > 0 && limit < len(filtered) {
-			filtered = filtered[:limit]
+		// Применяем лимит
+		if filter.Limit > 0 && len(filtered) >= filter.Limit {
+			break
 		}
 	}
 
-	fmt.Printf(" Фильтрация: из %d записей осталось %d\n", len(logs), len(filtered))
 	return filtered
 }
 
-func parseTimeFlexible(timeStr string) (time.Time, error) {
+// parseTimeFlexible - абсолютное время в одном из форматов;
+// значения без временной зоны считаются заданными в loc
+func parseTimeFlexible(timeStr string, loc *time.Location) (time.Time, error) {
 	// Пробуем разные форматы
 	formats := []string{
 		time.RFC3339,          // "2006-01-02T15:04:05Z07:00"
 		"2006-01-02T15:04:05", // без временной зоны
-		"2006-01-02T15:04",    // с буквой T, без секунд - ДОБАВЬ ЭТОТ ФОРМАТ
+		"2006-01-02T15:04",    // с буквой T, без секунд
 		"2006-01-02 15:04:05", // с пробелом вместо T
 		"2006-01-02 15:04",    // с пробелом, без секунд
 		"2006-01-02",          // только дата
-		"15:04:05",            // только время
-		"15:04",               // только время без секунд
 	}
 
 	for _, format := range formats {
-		if t, err := time.Parse(format, timeStr); err == nil {
+		if t, err := time.ParseInLocation(format, timeStr, loc); err == nil {
 			return t, nil
 		}
 	}
@@ -459,17 +512,17 @@ func calculateFilteredStats(logs []TerraformLog) ParseStats {
 		stats.SuccessLines++
 
 		// Считаем статистику по уровням
-		if log.Level != "" {
-			stats.ByLevel[log.Level]++
+		if log.Level() != "" {
+			stats.ByLevel[log.Level()]++
 		}
 
 		// Считаем статистику по модулям
-		if log.Module != "" {
-			stats.ByModule[log.Module]++
+		if log.Module() != "" {
+			stats.ByModule[log.Module()]++
 		}
 
 		// Проверяем наличие HTTP запросов
-		if log.TfReqID != "" {
+		if log.TfReqID() != "" {
 			stats.HasHTTPRequests = true
 		}
 	}
@@ -480,9 +533,15 @@ func calculateFilteredStats(logs []TerraformLog) ParseStats {
 // Обработчик API для приема логов
 func handleAPILogs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+	store := session.Store
 	// Обработка GET запроса - получение всех логов
 	if r.Method == "GET" {
-		if currentResult == nil {
+		snapshot := store.Snapshot()
+		if snapshot == nil {
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"status":  "no_data",
 				"message": "Нет данных логов",
@@ -491,34 +550,67 @@ func handleAPILogs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		query := r.URL.Query()
-		levelFilter := query.Get("level")   // Фильтр по уровню
-		sinceFilter := query.Get("since")   // Фильтр по времени (с)
-		untilFilter := query.Get("until")   // Фильтр по времени (по)
-		searchFilter := query.Get("search") // Поиск по сообщению
-		moduleFilter := query.Get("module") // Фильтр по модулю
-		limitStr := query.Get("limit")      // Лимит записей
+		filter, err := parseLogFilter(query, snapshot.Logs)
+		if err != nil {
+			writeFilterError(w, err)
+			return
+		}
+		filter.useAnnotations(session.Annotations)
+		spec, err := parseSortSpec(query.Get("sort"), query.Get("order"))
+		if err != nil {
+			writeFilterError(w, err)
+			return
+		}
 
-		// Фильтруем логи
-		filteredLogs := filterLogs(currentResult.Logs, levelFilter, sinceFilter, untilFilter, searchFilter, moduleFilter, limitStr)
+		// Фильтруем логи; limit задает размер страницы, поэтому отбираем все совпадения
+		pageSize := filter.Limit
+		filter.Limit = 0
+		filteredLogs := filterLogs(snapshot.Logs, filter)
+		sortLogs(filteredLogs, spec)
+
+		page, err := paginateLogs(filteredLogs, snapshot.Logs, spec, query.Get("cursor"), pageSize)
+		if err != nil {
+			writeFilterError(w, err)
+			return
+		}
+		filter.Time.renderLogs(page.Logs)
 
 		// Рассчитываем статистику по отфильтрованным логам
 		filteredStats := calculateFilteredStats(filteredLogs)
 
+		// Фасеты считаются по всем совпадениям, а не по странице
+		var facets map[string][]FacetBucket
+		if facetList := parseFacetList(query.Get("facets")); len(facetList) > 0 {
+			if query.Get("facet_relax") == "true" {
+				facets, err = relaxedFacets(snapshot.Logs, query, facetList, session.Annotations)
+				if err != nil {
+					writeFilterError(w, err)
+					return
+				}
+			} else {
+				facets = countFacets(filteredLogs, facetList)
+			}
+		}
+
 		response := map[string]interface{}{
 			"status":         "success",
-			"stats":          filteredStats,       // Используем отфильтрованную статистику
-			"original_stats": currentResult.Stats, // Сохраняем оригинальную статистику для сравнения
-			"filters": map[string]interface{}{
-				"level":  levelFilter,
-				"since":  sinceFilter,
-				"until":  untilFilter,
-				"search": searchFilter,
-				"module": moduleFilter,
-				"limit":  limitStr,
-			},
-			"logs":  filteredLogs,
-			"count": len(filteredLogs),
-			"total": len(currentResult.Logs),
+			"stats":          filteredStats,  // Используем отфильтрованную статистику
+			"original_stats": snapshot.Stats, // Сохраняем оригинальную статистику для сравнения
+			"filters":        filter.Params(),
+			"sort":           spec.String(),
+			"fields":         query.Get("fields"),
+			"logs":           projectLogs(page.Logs, query.Get("fields")),
+			"count":          len(page.Logs),
+			"total_matches":  page.Total,
+			"next_cursor":    page.NextCursor,
+			"prev_cursor":    page.PrevCursor,
+			"total":          len(snapshot.Logs),
+		}
+		if facets != nil {
+			response["facets"] = facets
+		}
+		if annotations := session.Annotations.ForEntries(page.Logs); len(annotations) > 0 {
+			response["annotations"] = annotations
 		}
 		json.NewEncoder(w).Encode(response)
 		return
@@ -527,7 +619,10 @@ func handleAPILogs(w http.ResponseWriter, r *http.Request) {
 	// Обработка DELETE запроса
 	if r.Method == "DELETE" {
 		// Очищаем все логи
-		currentResult = nil
+		if err := store.Clear(); err != nil {
+			writeStoreError(w, err)
+			return
+		}
 
 		response := map[string]interface{}{
 			"status":  "success",
@@ -543,6 +638,34 @@ func handleAPILogs(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
+	result, _, ok := readUploadedLogs(w, r)
+	if !ok {
+		return
+	}
+
+	// Добавляем к текущему результату одной операцией
+	total, err := store.Append(result)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": "Логи успешно обработаны",
+		"added":   len(result.Logs),
+		"errors":  len(result.Errors),
+		"total":   total,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// readUploadedLogs - разбор логов из тела запроса: файл формы (поле file),
+// JSON массив записей или текст построчно. Возвращает имя загруженного файла;
+// при ошибке ответ уже записан.
+func readUploadedLogs(w http.ResponseWriter, r *http.Request) (ParseResult, string, bool) {
+	var filename string
 	var body []byte
 	var err error
 
@@ -554,23 +677,24 @@ func handleAPILogs(w http.ResponseWriter, r *http.Request) {
 		file, header, err := r.FormFile("file")
 		if err != nil {
 			http.Error(w, `{"error": "Ошибка чтения файла"}`, http.StatusBadRequest)
-			return
+			return ParseResult{}, "", false
 		}
 		defer file.Close()
 
 		body, err = io.ReadAll(file)
 		if err != nil {
 			http.Error(w, `{"error": "Ошибка чтения содержимого файла"}`, http.StatusBadRequest)
-			return
+			return ParseResult{}, "", false
 		}
 
-		fmt.Printf("Получен файл: %s\n", header.Filename)
+		filename = header.Filename
+		fmt.Fprintf(requestOutput(r), "Получен файл: %s\n", header.Filename)
 	} else {
 		// Обработка обычного текста/JSON
 		body, err = io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, `{"error": "Ошибка чтения тела запроса"}`, http.StatusBadRequest)
-			return
+			return ParseResult{}, "", false
 		}
 	}
 
@@ -592,54 +716,33 @@ func handleAPILogs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	result := parser.ParseStream(strings.NewReader(string(body)))
-
-	// Обновляем текущий результат...
-	if currentResult == nil {
-		currentResult = &result
-	} else {
-		currentResult.Logs = append(currentResult.Logs, result.Logs...)
-		currentResult.Errors = append(currentResult.Errors, result.Errors...)
-		currentResult.Stats.TotalLines += result.Stats.TotalLines
-		currentResult.Stats.SuccessLines += result.Stats.SuccessLines
-		currentResult.Stats.ErrorLines += result.Stats.ErrorLines
-
-		for level, count := range result.Stats.ByLevel {
-			currentResult.Stats.ByLevel[level] += count
-		}
-		for module, count := range result.Stats.ByModule {
-			currentResult.Stats.ByModule[module] += count
-		}
-	}
-
-	response := map[string]interface{}{
-		"status":  "success",
-		"message": "Логи успешно обработаны",
-		"added":   len(result.Logs),
-		"errors":  len(result.Errors),
-		"total":   len(currentResult.Logs),
-	}
-
-	json.NewEncoder(w).Encode(response)
+	return parser.ParseStream(strings.NewReader(string(body))), filename, true
 }
 
 // Обработчик API для получения статуса
 func handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	store, ok := requestStore(w, r)
+	if !ok {
+		return
+	}
 
-	if currentResult == nil {
+	snapshot := store.Snapshot()
+	if snapshot == nil {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  "no_data",
 			"message": "Нет данных логов",
+			"usage":   storageUsage(store),
 		})
 		return
 	}
 
 	response := map[string]interface{}{
 		"status":       "success",
-		"stats":        currentResult.Stats,
-		"logs_count":   len(currentResult.Logs),
-		"errors_count": len(currentResult.Errors),
+		"stats":        snapshot.Stats,
+		"logs_count":   len(snapshot.Logs),
+		"errors_count": len(snapshot.Errors),
+		"usage":        storageUsage(store),
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -652,7 +755,14 @@ func handleAPIClear(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentResult = nil
+	store, ok := requestStore(w, r)
+	if !ok {
+		return
+	}
+	if err := store.Clear(); err != nil {
+		writeStoreError(w, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -691,7 +801,7 @@ func displayWebResults(w http.ResponseWriter, result *ParseResult) {
 
 	for i, logEntry := range logsToShow {
 		levelColor := "black"
-		switch strings.ToLower(logEntry.Level) {
+		switch strings.ToLower(logEntry.Level()) {
 		case "error":
 			levelColor = "red"
 		case "warn", "warning":
@@ -714,13 +824,13 @@ func displayWebResults(w http.ResponseWriter, result *ParseResult) {
 		</div>
 		`,
 			i+1,
-			levelColor, logEntry.Level,
+			levelColor, logEntry.Level(),
 			logEntry.Timestamp.Format("15:04:05"),
-			logEntry.EntryType,
-			logEntry.Message,
-			logEntry.Module,
-			logEntry.Caller,
-			logEntry.TfReqID,
+			logEntry.EntryType(),
+			logEntry.Message(),
+			logEntry.Module(),
+			logEntry.Caller(),
+			logEntry.TfReqID(),
 		)
 	}
 
@@ -754,9 +864,9 @@ func printResults(result ParseResult) {
 		fmt.Printf("[%d] %s %s: %s (%s)\n",
 			i+1,
 			log.Timestamp.Format("15:04:05"),
-			log.Level,
-			log.Message,
-			log.EntryType,
+			log.Level(),
+			log.Message(),
+			log.EntryType(),
 		)
 	}
 
@@ -793,31 +903,123 @@ func printResults(result ParseResult) {
 	}
 }
 
+// printFilteredResults - вывод результатов с учетом фильтров командной строки;
+// с -C/-B/-A совпадения выводятся вместе с соседними записями
+func printFilteredResults(result ParseResult, values url.Values, context *contextOptions) {
+	// Относительное время и время суток отсчитываются от записей сессии
+	filter, err := parseLogFilter(values, result.Logs)
+	if err != nil {
+		log.Fatalf("Ошибка в фильтре: %v", err)
+	}
+	if !filter.IsEmpty() {
+		matches := filterLogs(result.Logs, filter)
+		if context.enabled() {
+			logs := append([]TerraformLog(nil), result.Logs...)
+			filter.Time.renderLogs(logs)
+			if err := printContextMatches(logs, matches, *context); err != nil {
+				log.Fatalf("Ошибка: %v", err)
+			}
+			return
+		}
+		result.Logs = matches
+		filter.Time.renderLogs(result.Logs)
+		result.Stats = calculateFilteredStats(result.Logs)
+	}
+	printResults(result)
+}
+
 func main() {
+	filterValues := registerFilterFlags(flag.CommandLine)
+	contextOpts := registerContextFlags(flag.CommandLine)
+	limits := registerLimitFlags(flag.CommandLine)
+	follow := registerFollowFlags(flag.CommandLine)
+	syslog := registerSyslogFlags(flag.CommandLine)
+	forward := registerForwardFlags(flag.CommandLine)
+	dataDir := flag.String("data-dir", os.Getenv("DATA_DIR"), "каталог для хранения сессий между перезапусками (пусто - только в памяти)")
+	flag.Parse()
+
+	// Проверяем фильтры до чтения логов, чтобы не ждать разбора больших файлов
+	if _, err := parseLogFilter(filterValues(), nil); err != nil {
+		log.Fatalf("Ошибка в фильтре: %v", err)
+	}
+
+	if err := initSessions(*dataDir, *limits); err != nil {
+		log.Fatalf("Ошибка хранилища: %v", err)
+	}
+
+	// Запуск Terraform с записью логов в новую сессию
+	if args := flag.Args(); len(args) > 0 && args[0] == "run" {
+		code, err := runTerraform(args[1:], *dataDir, runStreams{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr, Server: io.Discard})
+		if err != nil {
+			log.Fatalf("Ошибка: %v", err)
+		}
+		os.Exit(code)
+	}
+
+	// Команды выгрузки и загрузки пакетов сессий
+	if args := flag.Args(); len(args) > 0 && (args[0] == "export" || args[0] == "import") {
+		serve, err := runBundleCommand(args[0], args[1:], *dataDir)
+		if err != nil {
+			log.Fatalf("Ошибка: %v", err)
+		}
+		if serve {
+			fmt.Println("\nЗапуск веб-сервера...")
+			startWebServer("8080")
+		}
+		return
+	}
+
+	// Прием syslog и Fluent Forward работает вместе с веб-сервером в любом режиме ниже
+	if err := startSyslog(syslog); err != nil {
+		log.Fatalf("Ошибка: %v", err)
+	}
+	if err := startForward(forward); err != nil {
+		log.Fatalf("Ошибка: %v", err)
+	}
+
 	// Проверяем аргументы командной строки
-	if len(os.Args) > 1 {
+	if args := flag.Args(); len(args) > 0 {
 		// Чтение из файла(ов)
 		parser := NewLogParser()
 
-		if os.Args[1] == "-" {
+		if follow.enabled {
+			// Файлы читаются в хранилище по мере записи, сервер видит новые строки
+			if args[0] == "-" {
+				log.Fatalf("Ошибка: --follow работает только с файлами")
+			}
+			fmt.Printf("Слежение за файлами: %v\n", args)
+			if err := loadDefaultSession(ParseResult{}, "follow: "+strings.Join(args, ", ")); err != nil {
+				log.Fatalf("Ошибка сохранения логов: %v", err)
+			}
+			if err := followFiles(args, defaultStore(), follow.interval); err != nil {
+				log.Fatalf("Ошибка: %v", err)
+			}
+			printFilteredResults(*defaultStore().Snapshot(), filterValues(), contextOpts)
+			fmt.Println("\nЗапуск веб-сервера...")
+			startWebServer("8080")
+		} else if args[0] == "-" {
 			// Чтение из stdin
 			fmt.Println("Чтение логов из stdin...")
 			result := parser.ParseStream(os.Stdin)
-			printResults(result)
-			currentResult = &result
+			printFilteredResults(result, filterValues(), contextOpts)
+			if err := loadDefaultSession(result, "stdin"); err != nil {
+				log.Fatalf("Ошибка сохранения логов: %v", err)
+			}
 			fmt.Println("\nЗапуск веб-сервера...")
 			startWebServer("8080")
 		} else {
 			// Чтение из файла(ов)
-			filenames := os.Args[1:]
+			filenames := args
 			fmt.Printf("Обработка файлов: %v\n", filenames)
 
 			result, err := parser.ParseFiles(filenames)
 			if err != nil {
 				log.Fatalf("Ошибка: %v", err)
 			}
-			printResults(result)
-			currentResult = &result
+			printFilteredResults(result, filterValues(), contextOpts)
+			if err := loadDefaultSession(result, "files: "+strings.Join(filenames, ", ")); err != nil {
+				log.Fatalf("Ошибка сохранения логов: %v", err)
+			}
 			fmt.Println("\nЗапуск веб-сервера...")
 			startWebServer("8080")
 		}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Чтение MessagePack, в котором Fluent Bit и Fluentd передают события по
+// протоколу Forward. Значения декодируются в типы encoding/json: nil, bool,
+// int64, uint64, float64, string, []interface{}, map[string]interface{};
+// bin - []byte, расширения - msgpackExt.
+
+// msgpackMaxDepth - ограничение вложенности массивов и словарей
+const msgpackMaxDepth = 64
+
+// msgpackExt - значение расширения (EventTime Fluentd - тип 0)
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// msgpackReader - чтение значений из потока; limit ограничивает размер
+// строк, bin и число элементов одного значения
+type msgpackReader struct {
+	r     *bufio.Reader
+	limit int
+}
+
+func newMsgpackReader(r io.Reader, limit int) *msgpackReader {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(r)
+	}
+	return &msgpackReader{r: reader, limit: limit}
+}
+
+// Read - следующее значение; io.EOF - поток закончился между значениями
+func (m *msgpackReader) Read() (interface{}, error) {
+	if _, err := m.r.Peek(1); err != nil {
+		return nil, err
+	}
+	value, err := m.read(0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return value, err
+}
+
+func (m *msgpackReader) read(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, errors.New("msgpack: слишком глубокая вложенность")
+	}
+	tag, err := m.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case tag <= 0x7f:
+		return int64(tag), nil
+	case tag >= 0xe0:
+		return int64(int8(tag)), nil
+	case tag&0xf0 == 0x80:
+		return m.readMap(int(tag&0x0f), depth)
+	case tag&0xf0 == 0x90:
+		return m.readArray(int(tag&0x0f), depth)
+	case tag&0xe0 == 0xa0:
+		data, err := m.readBytes(int(tag & 0x1f))
+		return string(data), err
+	}
+
+	switch tag {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := m.readLength(tag - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return m.readBytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := m.readLength(tag - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return m.readExt(n)
+	case 0xca:
+		bits, err := m.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := m.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return m.readUint(1 << (tag - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (tag - 0xd0)
+		value, err := m.readUint(size)
+		shift := 64 - 8*size
+		return int64(value<<shift) >> shift, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return m.readExt(1 << (tag - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := m.readLength(tag - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		data, err := m.readBytes(n)
+		return string(data), err
+	case 0xdc, 0xdd:
+		n, err := m.readLength(tag - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return m.readArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := m.readLength(tag - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return m.readMap(n, depth)
+	}
+	return nil, fmt.Errorf("msgpack: неизвестный тип 0x%02x", tag)
+}
+
+// readLength - длина из 1, 2 или 4 байт (sizeClass 0, 1, 2) с проверкой limit
+func (m *msgpackReader) readLength(sizeClass byte) (int, error) {
+	value, err := m.readUint(1 << sizeClass)
+	if err != nil {
+		return 0, err
+	}
+	if value > uint64(m.limit) {
+		return 0, fmt.Errorf("msgpack: длина %d больше допустимой %d", value, m.limit)
+	}
+	return int(value), nil
+}
+
+func (m *msgpackReader) readUint(size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(m.r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (m *msgpackReader) readBytes(n int) ([]byte, error) {
+	data := make([]byte, n)
+	_, err := io.ReadFull(m.r, data)
+	return data, err
+}
+
+func (m *msgpackReader) readExt(n int) (interface{}, error) {
+	kind, err := m.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.readBytes(n)
+	return msgpackExt{Type: int8(kind), Data: data}, err
+}
+
+func (m *msgpackReader) readArray(n int, depth int) (interface{}, error) {
+	if n > m.limit {
+		return nil, fmt.Errorf("msgpack: массив из %d элементов", n)
+	}
+	items := make([]interface{}, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		item, err := m.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readMap - словарь; ключи, не являющиеся строками, записываются через fmt.Sprint
+func (m *msgpackReader) readMap(n int, depth int) (interface{}, error) {
+	if n > m.limit {
+		return nil, fmt.Errorf("msgpack: словарь из %d элементов", n)
+	}
+	items := make(map[string]interface{}, min(n, 1024))
+	for i := 0; i < n; i++ {
+		key, err := m.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := m.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			if data, isBytes := key.([]byte); isBytes {
+				name = string(data)
+			} else {
+				name = fmt.Sprint(key)
+			}
+		}
+		items[name] = value
+	}
+	return items, nil
+}
+
+// appendMsgpackMap - словарь со строковыми ключами и значениями (ответ ack)
+func appendMsgpackMap(buf []byte, items map[string]string) []byte {
+	buf = append(buf, 0x80|byte(len(items)))
+	for key, value := range items {
+		buf = appendMsgpackString(buf, key)
+		buf = appendMsgpackString(buf, value)
+	}
+	return buf
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch {
+	case len(s) < 32:
+		buf = append(buf, 0xa0|byte(len(s)))
+	case len(s) < 1<<8:
+		buf = append(buf, 0xd9, byte(len(s)))
+	case len(s) < 1<<16:
+		buf = append(buf, 0xda)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	default:
+		buf = append(buf, 0xdb)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	}
+	return append(buf, s...)
+}
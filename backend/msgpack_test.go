@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackReader(t *testing.T) {
+	float32Bits := math.Float32bits(1.5)
+	type list = []interface{}
+	type object = map[string]interface{}
+	tests := []struct {
+		name  string
+		input []byte
+		want  interface{}
+	}{
+		{"positive fixint", []byte{0x7f}, int64(127)},
+		{"negative fixint", []byte{0xff}, int64(-1)},
+		{"nil и bool", []byte{0x93, 0xc0, 0xc2, 0xc3}, list{nil, false, true}},
+		{"uint8..uint64", []byte{0x94, 0xcc, 0xff, 0xcd, 0x01, 0x00, 0xce, 0, 1, 0, 0, 0xcf, 0, 0, 0, 1, 0, 0, 0, 0}, list{uint64(0xff), uint64(0x100), uint64(0x10000), uint64(1 << 32)}},
+		{"int8..int64", []byte{0x94, 0xd0, 0x80, 0xd1, 0xff, 0x00, 0xd2, 0xff, 0xff, 0xff, 0xff, 0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0}, list{int64(-128), int64(-256), int64(-1), int64(math.MinInt64)}},
+		{"float32", []byte{0xca, byte(float32Bits >> 24), byte(float32Bits >> 16), byte(float32Bits >> 8), byte(float32Bits)}, 1.5},
+		{"float64", []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5},
+		{"fixstr, str8 и str16", []byte{0x93, 0xa0, 0xd9, 2, 'a', 'b', 0xda, 0, 1, 'c'}, list{"", "ab", "c"}},
+		{"bin8", []byte{0xc4, 2, 0, 1}, []byte{0, 1}},
+		{"array16 и map16", []byte{0xdc, 0, 1, 0xde, 0, 1, 0xa1, 'k', 0x01}, list{object{"k": int64(1)}}},
+		{"ключи словаря не строки", []byte{0x82, 0x01, 0xa1, 'a', 0xc4, 1, 'b', 0x02}, object{"1": "a", "b": int64(2)}},
+		{"fixext8 (EventTime)", []byte{0xd7, 0x00, 0, 0, 0, 1, 0, 0, 0, 2}, msgpackExt{Type: 0, Data: []byte{0, 0, 0, 1, 0, 0, 0, 2}}},
+		{"ext8", []byte{0xc7, 1, 5, 0xaa}, msgpackExt{Type: 5, Data: []byte{0xaa}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := newMsgpackReader(bytes.NewReader(test.input), 1<<20).Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, test.want) {
+				t.Errorf("%#v, ожидалось %#v", value, test.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackReaderMalformed(t *testing.T) {
+	deep := append(bytes.Repeat([]byte{0x91}, msgpackMaxDepth+2), 0x01)
+	tests := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{"неизвестный тип", []byte{0xc1}, "неизвестный тип"},
+		{"обрезанный uint32", []byte{0xce, 0, 1}, io.ErrUnexpectedEOF.Error()},
+		{"обрезанная строка", []byte{0xa5, 'a', 'b'}, io.ErrUnexpectedEOF.Error()},
+		{"обрезанный массив", []byte{0x93, 0x01}, io.ErrUnexpectedEOF.Error()},
+		{"словарь без значения", []byte{0x81, 0xa1, 'k'}, io.ErrUnexpectedEOF.Error()},
+		{"обрезанное расширение", []byte{0xd7, 0x00, 1, 2}, io.ErrUnexpectedEOF.Error()},
+		{"строка больше ограничения", []byte{0xdb, 0x7f, 0xff, 0xff, 0xff}, "больше допустимой"},
+		{"bin больше ограничения", []byte{0xc6, 0, 0x10, 0, 1}, "больше допустимой"},
+		{"массив больше ограничения", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, "больше допустимой"},
+		{"словарь больше ограничения", []byte{0xdf, 0, 0x10, 0, 1}, "больше допустимой"},
+		{"глубокая вложенность", deep, "вложенность"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newMsgpackReader(bytes.NewReader(test.input), 1<<20).Read()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ошибка %v, ожидалось %q", err, test.err)
+			}
+		})
+	}
+
+	// Конец потока между значениями - io.EOF, а не ошибка формата
+	reader := newMsgpackReader(bytes.NewReader([]byte{0x01}), 16)
+	if _, err := reader.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("конец потока: %v", err)
+	}
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpSessionAttribute - атрибут ресурса или записи с ID или названием сессии
+const otlpSessionAttribute = "session"
+
+// otlpLogRecord - запись OTLP вместе с атрибутами ресурса и названием scope
+type otlpLogRecord struct {
+	Resource   map[string]interface{}
+	Scope      string
+	Timestamp  time.Time
+	Level      string // уровень Terraform по severity, "" - не задан
+	Body       interface{}
+	Attributes map[string]interface{}
+	TraceID    string // hex
+	SpanID     string // hex
+}
+
+// Обработчик OTLP/HTTP: POST /v1/logs от OpenTelemetry Collector и SDK.
+// Тело - ExportLogsServiceRequest в protobuf (application/x-protobuf) или
+// JSON (application/json), можно с Content-Encoding: gzip; ответ в той же кодировке.
+func handleOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := readPushBody(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	isJSON := strings.Contains(r.Header.Get("Content-Type"), "json")
+	var records []otlpLogRecord
+	if isJSON {
+		records, err = decodeOTLPLogsJSON(body)
+	} else {
+		records, err = decodeOTLPLogsProto(body)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	summaries, err := otlpLogsBatch(records).store(sessions, "otlp")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	for _, summary := range summaries {
+		fmt.Fprintf(requestOutput(r), " OTLP logs: сессия %s, записей %d, ошибок разбора %d\n", summary.Session, summary.Added, summary.Errors)
+	}
+	writeOTLPResponse(w, isJSON)
+}
+
+// writeOTLPResponse - пустой Export*ServiceResponse: все записи приняты
+func writeOTLPResponse(w http.ResponseWriter, isJSON bool) {
+	if isJSON {
+		w.Write([]byte("{}"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Обработчик OTLP/HTTP для трассировки: POST /v1/traces, например от Terraform
+// с OTEL_TRACES_EXPORTER=otlp. Спаны сохраняются в сессии из атрибута session
+// ресурса или спана и связываются с записями при запросе, см. linkSpans.
+func handleOTLPTraces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := readPushBody(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	isJSON := strings.Contains(r.Header.Get("Content-Type"), "json")
+	var spans []TraceSpan
+	if isJSON {
+		spans, err = decodeOTLPTracesJSON(body)
+	} else {
+		spans, err = decodeOTLPTracesProto(body)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	order, bySession := otlpSpanSessions(spans)
+	for _, key := range order {
+		session, err := sessions.FindOrCreate(key, "otlp")
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		total, err := session.Traces.Add(bySession[key])
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		fmt.Fprintf(requestOutput(r), " OTLP traces: сессия %s, спанов %d, всего %d\n", session.ID, len(bySession[key]), total)
+	}
+	writeOTLPResponse(w, isJSON)
+}
+
+// otlpSpanSessions - спаны по сессиям из атрибута session (атрибут спана важнее
+// атрибута ресурса); сам атрибут из спанов убирается
+func otlpSpanSessions(spans []TraceSpan) ([]string, map[string][]TraceSpan) {
+	var order []string
+	bySession := make(map[string][]TraceSpan)
+	for _, span := range spans {
+		session, _ := span.Resource[otlpSessionAttribute].(string)
+		if value, ok := span.Attributes[otlpSessionAttribute].(string); ok {
+			session = value
+		}
+		span.Resource = withoutAttribute(span.Resource, otlpSessionAttribute)
+		span.Attributes = withoutAttribute(span.Attributes, otlpSessionAttribute)
+
+		if _, exists := bySession[session]; !exists {
+			order = append(order, session)
+		}
+		bySession[session] = append(bySession[session], span)
+	}
+	return order, bySession
+}
+
+// withoutAttribute - копия атрибутов без name; атрибуты ресурса общие у его спанов
+func withoutAttribute(attributes map[string]interface{}, name string) map[string]interface{} {
+	if _, exists := attributes[name]; !exists {
+		return attributes
+	}
+	copied := make(map[string]interface{}, len(attributes))
+	for key, value := range attributes {
+		if key != name {
+			copied[key] = value
+		}
+	}
+	return copied
+}
+
+// otlpLogsBatch - записи OTLP как строки лога. Тело с JSON объектом (строка
+// Terraform с TF_LOG=json или kvlist) разбирается как строка лога, иначе тело
+// становится @message. Атрибуты ресурса и записи добавляются к полям (поля
+// строки не перезаписываются, атрибуты записи важнее атрибутов ресурса);
+// severity задает @level, scope - @module, если их нет в строке.
+func otlpLogsBatch(records []otlpLogRecord) *ingestBatch {
+	batch := newIngestBatch()
+	for _, record := range records {
+		attributes := make(map[string]interface{}, len(record.Resource)+len(record.Attributes)+4)
+		for name, value := range record.Resource {
+			attributes[name] = value
+		}
+		for name, value := range record.Attributes {
+			attributes[name] = value
+		}
+		session, _ := attributes[otlpSessionAttribute].(string)
+		delete(attributes, otlpSessionAttribute)
+
+		if record.Level != "" {
+			attributes["@level"] = record.Level
+		}
+		if record.Scope != "" {
+			attributes["@module"] = record.Scope
+		}
+		if record.TraceID != "" {
+			attributes["trace_id"] = record.TraceID
+		}
+		if record.SpanID != "" {
+			attributes["span_id"] = record.SpanID
+		}
+
+		batch.add(session, ingestLine{Line: otlpBodyLine(record.Body), Timestamp: record.Timestamp, Attributes: attributes})
+	}
+	return batch
+}
+
+// otlpBodyLine - тело записи как JSON объект строки лога
+func otlpBodyLine(body interface{}) string {
+	switch value := body.(type) {
+	case map[string]interface{}:
+		data, _ := json.Marshal(value)
+		return string(data)
+	case string:
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &object); err == nil && object != nil {
+			return value
+		}
+		data, _ := json.Marshal(map[string]interface{}{"@message": value})
+		return string(data)
+	case nil:
+		return "{}"
+	default:
+		message, _ := json.Marshal(value)
+		data, _ := json.Marshal(map[string]interface{}{"@message": string(message)})
+		return string(data)
+	}
+}
+
+// otlpSeverityLevel - уровень Terraform по SeverityNumber (1-4 trace, 5-8 debug,
+// 9-12 info, 13-16 warn, 17-24 error и fatal) или SeverityText
+func otlpSeverityLevel(number int64, text string) string {
+	switch {
+	case number >= 17:
+		return "error"
+	case number >= 13:
+		return "warn"
+	case number >= 9:
+		return "info"
+	case number >= 5:
+		return "debug"
+	case number >= 1:
+		return "trace"
+	}
+	switch level := normalizeLevel(text); level {
+	case "fatal", "critical", "crit", "panic":
+		return "error"
+	default:
+		return level
+	}
+}
+
+// otlpTime - время из наносекунд Unix; 0 - не задано
+func otlpTime(nanos uint64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(nanos))
+}
+
+// walkOTLP - элементы запроса OTLP в protobuf (записи или спаны) с атрибутами
+// ресурса и названием scope: Export*ServiceRequest{resource_*=1},
+// Resource*{resource=1, scope_*=2}, Scope*{scope=1, элементы=2}
+func walkOTLP(data []byte, fn func(resource map[string]interface{}, scope string, item []byte) error) error {
+	return walkProto(data, func(field protoField) error {
+		if field.Num != 1 || field.Wire != protoBytes {
+			return nil
+		}
+		resource := make(map[string]interface{})
+		var scopes [][]byte
+		err := walkProto(field.Bytes, func(field protoField) error {
+			if field.Wire != protoBytes {
+				return nil
+			}
+			switch field.Num {
+			case 1:
+				return decodeOTLPResource(field.Bytes, resource)
+			case 2:
+				scopes = append(scopes, field.Bytes)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, data := range scopes {
+			var scope string
+			var items [][]byte
+			err := walkProto(data, func(field protoField) error {
+				if field.Wire != protoBytes {
+					return nil
+				}
+				switch field.Num {
+				case 1:
+					name, err := decodeOTLPScope(field.Bytes)
+					scope = name
+					return err
+				case 2:
+					items = append(items, field.Bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				if err := fn(resource, scope, item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// decodeOTLPResource - атрибуты Resource{attributes=1}
+func decodeOTLPResource(data []byte, attributes map[string]interface{}) error {
+	return walkProto(data, func(field protoField) error {
+		if field.Num == 1 && field.Wire == protoBytes {
+			return decodeOTLPKeyValue(field.Bytes, attributes, 0)
+		}
+		return nil
+	})
+}
+
+// decodeOTLPScope - название InstrumentationScope{name=1, version=2}
+func decodeOTLPScope(data []byte) (string, error) {
+	var name string
+	err := walkProto(data, func(field protoField) error {
+		if field.Num == 1 && field.Wire == protoBytes {
+			name = field.String()
+		}
+		return nil
+	})
+	return name, err
+}
+
+// decodeOTLPLogsProto - записи ExportLogsServiceRequest: LogRecord{time_unix_nano=1,
+// severity_number=2, severity_text=3, body=5, attributes=6, trace_id=9, span_id=10,
+// observed_time_unix_nano=11}
+func decodeOTLPLogsProto(data []byte) ([]otlpLogRecord, error) {
+	var records []otlpLogRecord
+	err := walkOTLP(data, func(resource map[string]interface{}, scope string, item []byte) error {
+		record := otlpLogRecord{Resource: resource, Scope: scope, Attributes: make(map[string]interface{})}
+		var timestamp, observed uint64
+		var severity int64
+		var severityText string
+		err := walkProto(item, func(field protoField) error {
+			switch field.Num {
+			case 1:
+				timestamp = field.Value
+			case 11:
+				observed = field.Value
+			case 2:
+				severity = field.Int64()
+			case 3:
+				severityText = field.String()
+			case 5:
+				value, err := decodeOTLPAnyValue(field.Bytes, 0)
+				record.Body = value
+				return err
+			case 6:
+				return decodeOTLPKeyValue(field.Bytes, record.Attributes, 0)
+			case 9:
+				record.TraceID = hex.EncodeToString(field.Bytes)
+			case 10:
+				record.SpanID = hex.EncodeToString(field.Bytes)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if timestamp == 0 {
+			timestamp = observed
+		}
+		record.Timestamp = otlpTime(timestamp)
+		record.Level = otlpSeverityLevel(severity, severityText)
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// otlpSpanKinds и otlpStatusCodes - значения перечислений SpanKind и Status.code
+var (
+	otlpSpanKinds   = []string{"unspecified", "internal", "server", "client", "producer", "consumer"}
+	otlpStatusCodes = []string{"unset", "ok", "error"}
+)
+
+func otlpEnum(names []string, value int64) string {
+	if value >= 0 && value < int64(len(names)) {
+		return names[value]
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// newOTLPSpan - спан с длительностью по границам
+func newOTLPSpan(span TraceSpan, start, end uint64) TraceSpan {
+	span.Start, span.End = otlpTime(start), otlpTime(end)
+	if span.End.Before(span.Start) {
+		span.End = span.Start
+	}
+	span.DurationMs = float64(span.End.Sub(span.Start)) / float64(time.Millisecond)
+	return span
+}
+
+// decodeOTLPTracesProto - спаны ExportTraceServiceRequest: Span{trace_id=1, span_id=2,
+// parent_span_id=4, name=5, kind=6, start_time_unix_nano=7, end_time_unix_nano=8,
+// attributes=9, events=11, status=15}, Event{time_unix_nano=1, name=2, attributes=3},
+// Status{message=2, code=3}
+func decodeOTLPTracesProto(data []byte) ([]TraceSpan, error) {
+	var spans []TraceSpan
+	err := walkOTLP(data, func(resource map[string]interface{}, scope string, item []byte) error {
+		span := TraceSpan{Scope: scope, Resource: resource, Attributes: make(map[string]interface{}), Kind: otlpSpanKinds[0], Status: otlpStatusCodes[0]}
+		var start, end uint64
+		err := walkProto(item, func(field protoField) error {
+			switch field.Num {
+			case 1:
+				span.TraceID = hex.EncodeToString(field.Bytes)
+			case 2:
+				span.SpanID = hex.EncodeToString(field.Bytes)
+			case 4:
+				span.ParentSpanID = hex.EncodeToString(field.Bytes)
+			case 5:
+				span.Name = field.String()
+			case 6:
+				span.Kind = otlpEnum(otlpSpanKinds, field.Int64())
+			case 7:
+				start = field.Value
+			case 8:
+				end = field.Value
+			case 9:
+				return decodeOTLPKeyValue(field.Bytes, span.Attributes, 0)
+			case 11:
+				event := TraceSpanEvent{Attributes: make(map[string]interface{})}
+				err := walkProto(field.Bytes, func(field protoField) error {
+					switch field.Num {
+					case 1:
+						event.Time = otlpTime(field.Value)
+					case 2:
+						event.Name = field.String()
+					case 3:
+						return decodeOTLPKeyValue(field.Bytes, event.Attributes, 0)
+					}
+					return nil
+				})
+				span.Events = append(span.Events, event)
+				return err
+			case 15:
+				return walkProto(field.Bytes, func(field protoField) error {
+					switch field.Num {
+					case 2:
+						span.StatusMessage = field.String()
+					case 3:
+						span.Status = otlpEnum(otlpStatusCodes, field.Int64())
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if span.TraceID == "" || span.SpanID == "" {
+			return fmt.Errorf("спан %q без trace_id или span_id", span.Name)
+		}
+		spans = append(spans, newOTLPSpan(span, start, end))
+		return nil
+	})
+	return spans, err
+}
+
+// otlpMaxDepth - ограничение вложенности array и kvlist в значениях
+const otlpMaxDepth = 64
+
+// decodeOTLPKeyValue - KeyValue{key=1, value=2} в attributes; depth - вложенность
+// значения, в котором находится пара
+func decodeOTLPKeyValue(data []byte, attributes map[string]interface{}, depth int) error {
+	var key string
+	var value interface{}
+	err := walkProto(data, func(field protoField) error {
+		switch field.Num {
+		case 1:
+			key = field.String()
+		case 2:
+			var err error
+			value, err = decodeOTLPAnyValue(field.Bytes, depth)
+			return err
+		}
+		return nil
+	})
+	if err == nil && key != "" {
+		attributes[key] = value
+	}
+	return err
+}
+
+// decodeOTLPAnyValue - AnyValue{string=1, bool=2, int=3, double=4, array=5,
+// kvlist=6, bytes=7}; байты возвращаются в base64, как в OTLP JSON
+func decodeOTLPAnyValue(data []byte, depth int) (interface{}, error) {
+	if depth > otlpMaxDepth {
+		return nil, errors.New("OTLP: слишком глубокая вложенность значений")
+	}
+	var value interface{}
+	err := walkProto(data, func(field protoField) error {
+		switch field.Num {
+		case 1:
+			value = field.String()
+		case 2:
+			value = field.Value != 0
+		case 3:
+			value = field.Int64()
+		case 4:
+			value = field.Double()
+		case 5:
+			items := []interface{}{}
+			err := walkProto(field.Bytes, func(item protoField) error {
+				if item.Num != 1 || item.Wire != protoBytes {
+					return nil
+				}
+				decoded, err := decodeOTLPAnyValue(item.Bytes, depth+1)
+				items = append(items, decoded)
+				return err
+			})
+			value = items
+			return err
+		case 6:
+			object := make(map[string]interface{})
+			err := walkProto(field.Bytes, func(item protoField) error {
+				if item.Num != 1 || item.Wire != protoBytes {
+					return nil
+				}
+				return decodeOTLPKeyValue(item.Bytes, object, depth+1)
+			})
+			value = object
+			return err
+		case 7:
+			value = base64.StdEncoding.EncodeToString(field.Bytes)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// OTLP JSON: поля в lowerCamelCase, int64 и время - строки с числом,
+// trace_id и span_id - hex
+
+type otlpJSONAnyValue struct {
+	StringValue *string         `json:"stringValue"`
+	BoolValue   *bool           `json:"boolValue"`
+	IntValue    json.RawMessage `json:"intValue"`
+	DoubleValue *float64        `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpJSONAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJSONKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue *string `json:"bytesValue"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJSONAnyValue `json:"value"`
+}
+
+type otlpJSONResource struct {
+	Attributes []otlpJSONKeyValue `json:"attributes"`
+}
+
+type otlpJSONScope struct {
+	Name string `json:"name"`
+}
+
+type otlpJSONLogRecord struct {
+	TimeUnixNano         json.RawMessage    `json:"timeUnixNano"`
+	ObservedTimeUnixNano json.RawMessage    `json:"observedTimeUnixNano"`
+	SeverityNumber       int64              `json:"severityNumber"`
+	SeverityText         string             `json:"severityText"`
+	Body                 *otlpJSONAnyValue  `json:"body"`
+	Attributes           []otlpJSONKeyValue `json:"attributes"`
+	TraceID              string             `json:"traceId"`
+	SpanID               string             `json:"spanId"`
+}
+
+func decodeOTLPLogsJSON(body []byte) ([]otlpLogRecord, error) {
+	var request struct {
+		ResourceLogs []struct {
+			Resource  otlpJSONResource `json:"resource"`
+			ScopeLogs []struct {
+				Scope      otlpJSONScope       `json:"scope"`
+				LogRecords []otlpJSONLogRecord `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("некорректный JSON: %v", err)
+	}
+
+	var records []otlpLogRecord
+	for _, resourceLogs := range request.ResourceLogs {
+		resource, err := otlpJSONAttributes(resourceLogs.Resource.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			for _, item := range scopeLogs.LogRecords {
+				record := otlpLogRecord{
+					Resource: resource,
+					Scope:    scopeLogs.Scope.Name,
+					Level:    otlpSeverityLevel(item.SeverityNumber, item.SeverityText),
+					TraceID:  strings.ToLower(item.TraceID),
+					SpanID:   strings.ToLower(item.SpanID),
+				}
+				if record.Attributes, err = otlpJSONAttributes(item.Attributes); err != nil {
+					return nil, err
+				}
+				if item.Body != nil {
+					if record.Body, err = item.Body.decode(); err != nil {
+						return nil, err
+					}
+				}
+				timestamp, err := otlpJSONUint(item.TimeUnixNano)
+				if err == nil && timestamp == 0 {
+					timestamp, err = otlpJSONUint(item.ObservedTimeUnixNano)
+				}
+				if err != nil {
+					return nil, err
+				}
+				record.Timestamp = otlpTime(timestamp)
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+func otlpJSONAttributes(list []otlpJSONKeyValue) (map[string]interface{}, error) {
+	attributes := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		value, err := item.Value.decode()
+		if err != nil {
+			return nil, fmt.Errorf("атрибут %s: %v", item.Key, err)
+		}
+		attributes[item.Key] = value
+	}
+	return attributes, nil
+}
+
+func (v otlpJSONAnyValue) decode() (interface{}, error) {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue, nil
+	case v.BoolValue != nil:
+		return *v.BoolValue, nil
+	case v.IntValue != nil:
+		value, err := otlpJSONUint(v.IntValue)
+		return int64(value), err
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, nil
+	case v.ArrayValue != nil:
+		items := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			value, err := item.decode()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case v.KvlistValue != nil:
+		return otlpJSONAttributes(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return *v.BytesValue, nil
+	}
+	return nil, nil
+}
+
+// otlpJSONUint - 64-битное число, записанное строкой ("123") или числом
+func otlpJSONUint(raw json.RawMessage) (uint64, error) {
+	text := strings.Trim(strings.TrimSpace(string(raw)), `"`)
+	if text == "" || text == "null" {
+		return 0, nil
+	}
+	if value, err := strconv.ParseUint(text, 10, 64); err == nil {
+		return value, nil
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("некорректное число %s", text)
+	}
+	return uint64(value), nil
+}
+
+type otlpJSONSpan struct {
+	TraceID           string             `json:"traceId"`
+	SpanID            string             `json:"spanId"`
+	ParentSpanID      string             `json:"parentSpanId"`
+	Name              string             `json:"name"`
+	Kind              int64              `json:"kind"`
+	StartTimeUnixNano json.RawMessage    `json:"startTimeUnixNano"`
+	EndTimeUnixNano   json.RawMessage    `json:"endTimeUnixNano"`
+	Attributes        []otlpJSONKeyValue `json:"attributes"`
+	Events            []struct {
+		TimeUnixNano json.RawMessage    `json:"timeUnixNano"`
+		Name         string             `json:"name"`
+		Attributes   []otlpJSONKeyValue `json:"attributes"`
+	} `json:"events"`
+	Status struct {
+		Message string `json:"message"`
+		Code    int64  `json:"code"`
+	} `json:"status"`
+}
+
+func decodeOTLPTracesJSON(body []byte) ([]TraceSpan, error) {
+	var request struct {
+		ResourceSpans []struct {
+			Resource   otlpJSONResource `json:"resource"`
+			ScopeSpans []struct {
+				Scope otlpJSONScope  `json:"scope"`
+				Spans []otlpJSONSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("некорректный JSON: %v", err)
+	}
+
+	var spans []TraceSpan
+	for _, resourceSpans := range request.ResourceSpans {
+		resource, err := otlpJSONAttributes(resourceSpans.Resource.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, item := range scopeSpans.Spans {
+				if item.TraceID == "" || item.SpanID == "" {
+					return nil, fmt.Errorf("спан %q без traceId или spanId", item.Name)
+				}
+				span := TraceSpan{
+					TraceID:       strings.ToLower(item.TraceID),
+					SpanID:        strings.ToLower(item.SpanID),
+					ParentSpanID:  strings.ToLower(item.ParentSpanID),
+					Name:          item.Name,
+					Kind:          otlpEnum(otlpSpanKinds, item.Kind),
+					Scope:         scopeSpans.Scope.Name,
+					Status:        otlpEnum(otlpStatusCodes, item.Status.Code),
+					StatusMessage: item.Status.Message,
+					Resource:      resource,
+				}
+				if span.Attributes, err = otlpJSONAttributes(item.Attributes); err != nil {
+					return nil, err
+				}
+				for _, event := range item.Events {
+					at, err := otlpJSONUint(event.TimeUnixNano)
+					if err != nil {
+						return nil, err
+					}
+					attributes, err := otlpJSONAttributes(event.Attributes)
+					if err != nil {
+						return nil, err
+					}
+					span.Events = append(span.Events, TraceSpanEvent{Name: event.Name, Time: otlpTime(at), Attributes: attributes})
+				}
+				start, err := otlpJSONUint(item.StartTimeUnixNano)
+				if err != nil {
+					return nil, err
+				}
+				end, err := otlpJSONUint(item.EndTimeUnixNano)
+				if err != nil {
+					return nil, err
+				}
+				spans = append(spans, newOTLPSpan(span, start, end))
+			}
+		}
+	}
+	return spans, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// otlpLogsPayload - запрос otlphttp экспортера Collector: filelog читает файл
+// TF_LOG_PATH, тело записи - строка Terraform
+const otlpLogsPayload = `{"resourceLogs":[{"resource":{"attributes":[
+  {"key":"service.name","value":{"stringValue":"terraform"}},
+  {"key":"session","value":{"stringValue":"ci-apply"}}]},
+ "scopeLogs":[{"scope":{},"logRecords":[
+  {"observedTimeUnixNano":"1704103200000000000","body":{"stringValue":"{\"@level\":\"error\",\"@message\":\"Error: creating EC2 Instance\",\"@module\":\"terraform.ui\",\"@timestamp\":\"2024-01-01T10:00:00.000000Z\"}"},
+   "attributes":[{"key":"log.file.name","value":{"stringValue":"terraform.log"}}]},
+  {"timeUnixNano":"1704103201000000000","severityNumber":13,"body":{"stringValue":"plain text"},
+   "attributes":[{"key":"retries","value":{"intValue":"3"}},{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"boolValue":true}]}}}],
+   "traceId":"5B8EFFF798038103D269B633813FC60C","spanId":"EEE19B7EC3C1B174"}]}]}]}`
+
+func TestOTLPLogs(t *testing.T) {
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := decodeOTLPLogsJSON([]byte(otlpLogsPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otlpLogsBatch(records).store(registry, "otlp"); err != nil {
+		t.Fatal(err)
+	}
+	session, _ := registry.FindOrCreate("ci-apply", "")
+	logs := session.Store.Snapshot().Logs
+	if len(logs) != 2 {
+		t.Fatalf("записей %d, ожидалось 2", len(logs))
+	}
+	attrs := logAttributes(logs[0])
+	if logs[0].Level() != "error" || logs[0].Module() != "terraform.ui" || attrs["service.name"] != "terraform" || attrs["log.file.name"] != "terraform.log" || attrs["session"] != nil {
+		t.Errorf("строка Terraform в теле: %+v %v", logs[0], attrs)
+	}
+	attrs = logAttributes(logs[1])
+	if logs[1].Level() != "warn" || logs[1].Message() != "plain text" || attrs["retries"] != float64(3) || attrs["trace_id"] != "5b8efff798038103d269b633813fc60c" {
+		t.Errorf("текстовое тело: %+v %v", logs[1], attrs)
+	}
+	if want := time.Unix(1704103201, 0); !logs[1].Timestamp.Equal(want) {
+		t.Errorf("время %v, ожидалось %v", logs[1].Timestamp, want)
+	}
+
+	// Тот же формат в protobuf: kvlist в теле, severity_text, scope
+	keyValue := func(key string, value []byte) []byte {
+		return protoAppend(protoAppend(nil, 1, []byte(key)), 2, value)
+	}
+	stringValue := func(s string) []byte { return protoAppend(nil, 1, []byte(s)) }
+	kvlist := protoAppend(nil, 1, keyValue("@message", stringValue("from kvlist")))
+	record := binary.LittleEndian.AppendUint64([]byte{1<<3 | protoFixed64}, 1704103202000000000)
+	record = protoAppend(record, 3, []byte("ERROR"))
+	record = protoAppend(record, 5, protoAppend(nil, 6, kvlist))
+	record = protoAppend(record, 6, keyValue("attempt", []byte{3 << 3, 2}))
+	scopeLogs := protoAppend(protoAppend(nil, 1, protoAppend(nil, 1, []byte("provider.aws"))), 2, record)
+	resource := protoAppend(nil, 1, keyValue("session", stringValue("ci-apply")))
+	request := protoAppend(nil, 1, protoAppend(protoAppend(nil, 1, resource), 2, scopeLogs))
+
+	if records, err = decodeOTLPLogsProto(request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otlpLogsBatch(records).store(registry, "otlp"); err != nil {
+		t.Fatal(err)
+	}
+	logs = session.Store.Snapshot().Logs
+	if len(logs) != 3 || logs[2].Message() != "from kvlist" || logs[2].Level() != "error" || logs[2].Module() != "provider.aws" || logAttributes(logs[2])["attempt"] != float64(2) {
+		t.Errorf("protobuf: %+v", logs[len(logs)-1])
+	}
+}
+
+func TestOTLPLogsMalformed(t *testing.T) {
+	jsonTests := []struct {
+		body string
+		err  string
+	}{
+		{`{"resourceLogs": [`, "некорректный JSON"},
+		{`{"resourceLogs": [{"scopeLogs": [{"logRecords": [{"timeUnixNano": "вчера"}]}]}]}`, "некорректное число"},
+		{`{"resourceLogs": [{"resource": {"attributes": [{"key": "n", "value": {"intValue": "1.5"}}]}}]}`, "некорректное число"},
+		{`{"resourceLogs": [{"scopeLogs": [{"logRecords": [{"attributes": [{"key": "a", "value": {"arrayValue": {"values": [{"intValue": "x"}]}}}]}]}]}]}`, "некорректное число"},
+	}
+	for _, test := range jsonTests {
+		if _, err := decodeOTLPLogsJSON([]byte(test.body)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: ошибка %v, ожидалось %q", test.body, err, test.err)
+		}
+	}
+
+	// Вложенность значений ограничена: иначе запрос в 64 МБ исчерпал бы стек
+	value := protoAppend(nil, 1, []byte("лист"))
+	for i := 0; i <= otlpMaxDepth+1; i++ {
+		value = protoAppend(nil, 5, protoAppend(nil, 1, value))
+	}
+	record := protoAppend(nil, 6, protoAppend(protoAppend(nil, 1, []byte("deep")), 2, value))
+	deep := protoAppend(nil, 1, protoAppend(nil, 2, protoAppend(nil, 2, record)))
+	protoTests := map[string][]byte{
+		"обрезанный запрос":    {1<<3 | protoBytes, 10},
+		"обрезанный ресурс":    protoAppend(nil, 1, protoAppend(nil, 1, []byte{1<<3 | protoBytes, 5})),
+		"обрезанная запись":    protoAppend(nil, 1, protoAppend(nil, 2, protoAppend(nil, 2, []byte{1<<3 | protoFixed64, 1}))),
+		"неизвестный тип поля": protoAppend(nil, 1, []byte{1<<3 | 7}),
+	}
+	for name, body := range protoTests {
+		if _, err := decodeOTLPLogsProto(body); err == nil {
+			t.Errorf("%s: ошибки нет", name)
+		}
+	}
+	if _, err := decodeOTLPLogsProto(deep); err == nil || !strings.Contains(err.Error(), "вложенность") {
+		t.Errorf("вложенность: %v", err)
+	}
+}
+
+func TestOTLPSeverityLevel(t *testing.T) {
+	tests := []struct {
+		number int64
+		text   string
+		want   string
+	}{
+		{1, "", "trace"}, {5, "", "debug"}, {9, "", "info"}, {12, "", "info"},
+		{13, "", "warn"}, {17, "", "error"}, {21, "", "error"},
+		{0, "WARNING", "warn"}, {0, "Fatal", "error"}, {0, "", ""},
+		{9, "ERROR", "info"}, // SeverityNumber важнее текста
+	}
+	for _, test := range tests {
+		if got := otlpSeverityLevel(test.number, test.text); got != test.want {
+			t.Errorf("%d %q: %q, ожидалось %q", test.number, test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sortSpec - поле и направление сортировки записей
+type sortSpec struct {
+	field string // timestamp, level, module, id или атрибут
+	desc  bool
+}
+
+// pageCursor - граница страницы: запись, после (next) или до (prev) которой
+// продолжать, и сортировка, для которой курсор выдан
+type pageCursor struct {
+	dir  string
+	id   int
+	sort string // sortSpec.String()
+}
+
+// LogPage - страница отсортированных записей и курсоры соседних страниц
+type LogPage struct {
+	Logs       []TerraformLog
+	Total      int
+	NextCursor string
+	PrevCursor string
+}
+
+// parseSortSpec - sort=timestamp|level|module|<атрибут> (или -timestamp), order=asc|desc
+func parseSortSpec(sortStr, order string) (sortSpec, error) {
+	spec := sortSpec{field: "id"}
+
+	if strings.HasPrefix(sortStr, "-") {
+		spec.desc = true
+		sortStr = sortStr[1:]
+	}
+	if sortStr != "" {
+		spec.field = normalizeQueryField(sortStr)
+		if strings.EqualFold(sortStr, "id") {
+			spec.field = "id"
+		}
+	}
+
+	switch strings.ToLower(order) {
+	case "":
+	case "asc":
+		spec.desc = false
+	case "desc":
+		spec.desc = true
+	default:
+		return spec, fmt.Errorf("неверное направление сортировки: %s", order)
+	}
+
+	return spec, nil
+}
+
+// String - представление сортировки для ответа API
+func (s sortSpec) String() string {
+	field := strings.TrimPrefix(s.field, "attr.")
+	if s.desc {
+		return "-" + field
+	}
+	return field
+}
+
+// sortLogs - устойчивая сортировка; при равных ключах порядок определяет ID.
+// Ключи вычисляются один раз до сортировки: для атрибутов это разбор записи.
+func sortLogs(logs []TerraformLog, spec sortSpec) {
+	if spec.field == "id" && !spec.desc {
+		// Записи и так хранятся в порядке поступления
+		return
+	}
+
+	type keyedLog struct {
+		key   interface{}
+		index int
+	}
+	keyed := make([]keyedLog, len(logs))
+	for i := range logs {
+		keyed[i] = keyedLog{key: sortKey(&logs[i], spec.field), index: i}
+	}
+	sort.SliceStable(keyed, func(i, j int) bool {
+		a, b := keyed[i], keyed[j]
+		return compareKeyed(a.key, logs[a.index].ID, b.key, logs[b.index].ID, spec) < 0
+	})
+
+	sorted := make([]TerraformLog, len(logs))
+	for i, item := range keyed {
+		sorted[i] = logs[item.index]
+	}
+	copy(logs, sorted)
+}
+
+// compareKeyed - сравнение по готовым ключам и ID; записи без поля остаются
+// в конце при любом направлении
+func compareKeyed(aKey interface{}, aID int, bKey interface{}, bID int, spec sortSpec) int {
+	if (aKey == nil) != (bKey == nil) {
+		return compareSortKeys(aKey, bKey)
+	}
+	cmp := compareSortKeys(aKey, bKey)
+	if cmp == 0 {
+		cmp = aID - bID
+	}
+	if spec.desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// sortKey - значение поля записи для сортировки (nil - поле отсутствует)
+func sortKey(log *TerraformLog, field string) interface{} {
+	switch field {
+	case "id":
+		return float64(log.ID)
+	case "level":
+		if severity, known := levelSeverity[normalizeLevel(log.Level())]; known {
+			return float64(severity)
+		}
+		return nil
+	}
+
+	value, exists := queryFieldValue(log, field)
+	if !exists {
+		return nil
+	}
+	return value
+}
+
+// compareSortKeys - время и числа сравниваются по значению, остальное как строки;
+// записи без поля всегда оказываются в конце
+func compareSortKeys(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if na, ok := toFloat(a); ok {
+		if nb, ok := toFloat(b); ok {
+			switch {
+			case na < nb:
+				return -1
+			case na > nb:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// encodeCursor - непрозрачный курсор для клиента: направление, ID границы и сортировка
+func encodeCursor(cursor pageCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor.dir + ":" + strconv.Itoa(cursor.id) + ":" + cursor.sort))
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("неверный курсор")
+	}
+	parts := strings.SplitN(string(data), ":", 3)
+	if len(parts) != 3 || (parts[0] != "next" && parts[0] != "prev") || parts[2] == "" {
+		return pageCursor{}, fmt.Errorf("неверный курсор")
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("неверный курсор")
+	}
+	return pageCursor{dir: parts[0], id: id, sort: parts[2]}, nil
+}
+
+// paginateLogs - страница из отсортированных записей. Граница курсора ищется
+// по ключу записи с этим ID, поэтому страницы не сдвигаются при дозагрузке логов.
+// all - весь набор записей, в котором ищется граничная запись.
+func paginateLogs(sorted, all []TerraformLog, spec sortSpec, cursorStr string, limit int) (LogPage, error) {
+	page := LogPage{Total: len(sorted)}
+	if limit <= 0 {
+		limit = len(sorted)
+	}
+
+	start := 0
+	if cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr)
+		if err != nil {
+			return page, err
+		}
+		if cursor.sort != spec.String() {
+			return page, fmt.Errorf("курсор выдан для sort=%s, а запрошен sort=%s", cursor.sort, spec)
+		}
+		boundary := findLogByID(all, cursor.id)
+		if boundary == nil {
+			return page, fmt.Errorf("курсор указывает на удаленную запись")
+		}
+
+		boundaryKey := sortKey(boundary, spec.field)
+		compareBoundary := func(i int) int {
+			return compareKeyed(sortKey(&sorted[i], spec.field), sorted[i].ID, boundaryKey, boundary.ID, spec)
+		}
+		if cursor.dir == "next" {
+			start = sort.Search(len(sorted), func(i int) bool {
+				return compareBoundary(i) > 0
+			})
+		} else {
+			end := sort.Search(len(sorted), func(i int) bool {
+				return compareBoundary(i) >= 0
+			})
+			start = end - limit
+			if start < 0 {
+				start = 0
+			}
+			limit = end - start
+		}
+	}
+
+	end := start + limit
+	if end > len(sorted) {
+		end = len(sorted)
+	}
+	page.Logs = sorted[start:end]
+
+	if len(page.Logs) > 0 {
+		if end < len(sorted) {
+			page.NextCursor = encodeCursor(pageCursor{"next", page.Logs[len(page.Logs)-1].ID, spec.String()})
+		}
+		if start > 0 {
+			page.PrevCursor = encodeCursor(pageCursor{"prev", page.Logs[0].ID, spec.String()})
+		}
+	}
+	return page, nil
+}
+
+// findLogByID - поиск записи по ID; записи упорядочены по возрастанию ID
+func findLogByID(logs []TerraformLog, id int) *TerraformLog {
+	if idx := findLogIndex(logs, id); idx >= 0 {
+		return &logs[idx]
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sizedLines - записи с атрибутом size; nil - атрибута нет
+func sizedLines(sizes ...interface{}) string {
+	var b strings.Builder
+	for i, size := range sizes {
+		if size == nil {
+			fmt.Fprintf(&b, `{"@level":"info","@message":"entry %d"}`+"\n", i)
+			continue
+		}
+		fmt.Fprintf(&b, `{"@level":"info","@message":"entry %d","size":%v}`+"\n", i, size)
+	}
+	return b.String()
+}
+
+// logPage - страница GET /api/logs: ID записей и курсоры
+type logPage struct {
+	IDs        []int
+	NextCursor string
+	PrevCursor string
+}
+
+func fetchPage(t *testing.T, server http.Handler, query url.Values) (int, logPage) {
+	t.Helper()
+	var response struct {
+		Logs       []TerraformLog `json:"logs"`
+		NextCursor string         `json:"next_cursor"`
+		PrevCursor string         `json:"prev_cursor"`
+	}
+	code := serveJSON(t, server, "GET", "/api/logs?"+query.Encode(), "", &response)
+	page := logPage{NextCursor: response.NextCursor, PrevCursor: response.PrevCursor}
+	for _, entry := range response.Logs {
+		page.IDs = append(page.IDs, entry.ID)
+	}
+	return code, page
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, want := range []pageCursor{{"next", 42, "-timestamp"}, {"prev", 0, "attr.a:b"}} {
+		cursor, err := decodeCursor(encodeCursor(want))
+		if err != nil || cursor != want {
+			t.Errorf("%+v: %+v, %v", want, cursor, err)
+		}
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	for _, bad := range []string{"", "!!!", encode([]byte("next")), encode([]byte("next:x:id")), encode([]byte("up:1:id")), encode([]byte(":1:id")), encode([]byte("next:1")), encode([]byte("next:1:"))} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("курсор %q должен быть отвергнут", bad)
+		}
+	}
+}
+
+func TestSortLogsByAttribute(t *testing.T) {
+	logs := NewLogParser().ParseStream(strings.NewReader(sizedLines(3, 1, 2, 1, nil, 2))).Logs
+
+	tests := []struct {
+		sort, order string
+		ids         []int
+	}{
+		{"size", "", []int{1, 3, 2, 5, 0, 4}},
+		// Обратный порядок переворачивает и равные ключи, записи без поля остаются в конце
+		{"-size", "", []int{0, 5, 2, 3, 1, 4}},
+		{"size", "desc", []int{0, 5, 2, 3, 1, 4}},
+		{"-id", "", []int{5, 4, 3, 2, 1, 0}},
+		{"missing", "", []int{0, 1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		spec, err := parseSortSpec(tt.sort, tt.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted := append([]TerraformLog(nil), logs...)
+		sortLogs(sorted, spec)
+		var ids []int
+		for _, entry := range sorted {
+			ids = append(ids, entry.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("sort=%s order=%s: %v, ожидалось %v", tt.sort, tt.order, ids, tt.ids)
+		}
+	}
+
+	if _, err := parseSortSpec("size", "sideways"); err == nil {
+		t.Error("неизвестное направление должно быть ошибкой")
+	}
+}
+
+func TestPaginationAPI(t *testing.T) {
+	registry, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", sizedLines(3, 1, 2, 1, nil, 2), nil)
+
+	// Обход вперед по курсорам дает тот же порядок, что и одна страница
+	var walked []int
+	query := url.Values{"sort": {"size"}, "limit": {"2"}}
+	var pages []logPage
+	for {
+		code, page := fetchPage(t, server, query)
+		if code != http.StatusOK {
+			t.Fatalf("код %d", code)
+		}
+		pages = append(pages, page)
+		walked = append(walked, page.IDs...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	if !reflect.DeepEqual(walked, []int{1, 3, 2, 5, 0, 4}) || len(pages) != 3 {
+		t.Fatalf("обход страниц: %v", walked)
+	}
+	if pages[0].PrevCursor != "" || pages[2].PrevCursor == "" {
+		t.Errorf("курсоры назад: %+v", pages)
+	}
+
+	// Назад от последней страницы - предыдущая
+	query.Set("cursor", pages[2].PrevCursor)
+	if _, page := fetchPage(t, server, query); !reflect.DeepEqual(page.IDs, pages[1].IDs) {
+		t.Errorf("страница назад %v, ожидалось %v", page.IDs, pages[1].IDs)
+	}
+
+	// Дозагрузка между страницами: следующая страница продолжается с той же
+	// границы, новые записи встают на свои места по ключу
+	serveJSON(t, server, "POST", "/api/logs", sizedLines(2, 0), nil)
+	query.Set("cursor", pages[0].NextCursor)
+	if _, page := fetchPage(t, server, query); !reflect.DeepEqual(page.IDs, []int{2, 5}) {
+		t.Errorf("после дозагрузки: %v, ожидалось [2 5]", page.IDs)
+	}
+	query.Set("cursor", pages[1].NextCursor)
+	if _, page := fetchPage(t, server, query); !reflect.DeepEqual(page.IDs, []int{6, 0}) {
+		t.Errorf("после дозагрузки: %v, ожидалось [6 0]", page.IDs)
+	}
+
+	// Курсор действует только для сортировки, с которой выдан
+	for _, sort := range []string{"-size", "id", "timestamp"} {
+		other := url.Values{"sort": {sort}, "limit": {"2"}, "cursor": {pages[0].NextCursor}}
+		if code, _ := fetchPage(t, server, other); code != http.StatusBadRequest {
+			t.Errorf("курсор sort=size при sort=%s: код %d", sort, code)
+		}
+	}
+	if code, _ := fetchPage(t, server, url.Values{"order": {"asc"}, "sort": {"size"}, "cursor": {pages[0].NextCursor}}); code != http.StatusOK {
+		t.Errorf("курсор с той же сортировкой: код %d", code)
+	}
+
+	// Граничная запись вытеснена - курсор больше не действует
+	session, _ := registry.Get(defaultSessionID)
+	session.Store.Trim(3, 0)
+	query.Set("cursor", pages[0].NextCursor)
+	if code, _ := fetchPage(t, server, query); code != http.StatusBadRequest {
+		t.Errorf("курсор на удаленную запись: код %d", code)
+	}
+
+	for _, cursor := range []string{"bm9wZQ", "%%%"} {
+		query.Set("cursor", cursor)
+		if code, _ := fetchPage(t, server, query); code != http.StatusBadRequest {
+			t.Errorf("курсор %q: код %d", cursor, code)
+		}
+	}
+}
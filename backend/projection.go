@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// logFieldNames - поля TerraformLog, доступные в fields=, по имени в нижнем регистре
+var logFieldNames = map[string]string{
+	"id":               "ID",
+	"level":            "Level",
+	"message":          "Message",
+	"module":           "Module",
+	"caller":           "Caller",
+	"timestamp":        "Timestamp",
+	"tf_req_id":        "TfReqID",
+	"tfreqid":          "TfReqID",
+	"tf_rpc":           "TfRPC",
+	"tfrpc":            "TfRPC",
+	"tf_proto_version": "TfProtoVersion",
+	"tfprotoversion":   "TfProtoVersion",
+	"tf_provider_addr": "TfProviderAddr",
+	"tfprovideraddr":   "TfProviderAddr",
+	"entry_type":       "EntryType",
+	"entrytype":        "EntryType",
+	"raw_json":         "RawJSON",
+	"rawjson":          "RawJSON",
+}
+
+// logFieldValue - значение стандартного поля записи по имени Go-структуры
+func logFieldValue(log *TerraformLog, name string) interface{} {
+	switch name {
+	case "ID":
+		return log.ID
+	case "Level":
+		return log.Level()
+	case "Message":
+		return log.Message()
+	case "Module":
+		return log.Module()
+	case "Caller":
+		return log.Caller()
+	case "Timestamp":
+		return log.Timestamp
+	case "TfReqID":
+		return log.TfReqID()
+	case "TfRPC":
+		return log.TfRPC()
+	case "TfProtoVersion":
+		return log.TfProtoVersion()
+	case "TfProviderAddr":
+		return log.TfProviderAddr()
+	case "EntryType":
+		return log.EntryType()
+	case "RawJSON":
+		return rawJSON(log)
+	}
+	return nil
+}
+
+// projectLogs - записи для ответа API. Без fields= возвращаются все поля, кроме
+// исходной строки (она доступна через /api/logs/{id}/raw); с fields= - только
+// перечисленные поля и атрибуты (ID включается всегда, он нужен для ссылок).
+func projectLogs(logs []TerraformLog, fieldsParam string) interface{} {
+	fields := splitFilterList([]string{fieldsParam})
+	if len(fields) == 0 {
+		projected := make([]TerraformLog, len(logs))
+		for i, log := range logs {
+			projected[i] = log.withoutRawJSON()
+		}
+		return projected
+	}
+
+	projected := make([]map[string]interface{}, len(logs))
+	for i := range logs {
+		item := map[string]interface{}{"ID": logs[i].ID}
+		for _, field := range fields {
+			if name, known := logFieldNames[strings.ToLower(field)]; known {
+				item[name] = logFieldValue(&logs[i], name)
+				continue
+			}
+			if value, exists := attributePath(logAttributes(logs[i]), strings.TrimPrefix(field, "attr.")); exists {
+				item[field] = value
+			}
+		}
+		projected[i] = item
+	}
+	return projected
+}
+
+// decodeNestedJSON - строки, содержащие JSON (например тела HTTP запросов), заменяются разобранными значениями
+func decodeNestedJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		decoded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			decoded[key] = decodeNestedJSON(item)
+		}
+		return decoded
+	case []interface{}:
+		decoded := make([]interface{}, len(v))
+		for i, item := range v {
+			decoded[i] = decodeNestedJSON(item)
+		}
+		return decoded
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if len(trimmed) < 2 || (trimmed[0] != '{' && trimmed[0] != '[') {
+			return v
+		}
+		nested, err := unmarshalExact([]byte(trimmed))
+		if err != nil {
+			return v
+		}
+		return decodeNestedJSON(nested)
+	}
+	return value
+}
+
+// unmarshalExact - разбор JSON без потери точности: числа остаются json.Number,
+// поэтому ID и размеры больше 2^53 не округляются
+func unmarshalExact(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("лишние данные после JSON")
+	}
+	return value, nil
+}
+
+// Обработчик API для исходной строки записи: GET /api/logs/{id}/raw?decode=true&pretty=true
+func handleAPILogRaw(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := requestStore(w, r)
+	if !ok {
+		return
+	}
+	snapshot := store.Snapshot()
+	if snapshot == nil {
+		http.Error(w, `{"error": "Нет данных логов"}`, http.StatusNotFound)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error": "Неверный идентификатор записи"}`, http.StatusBadRequest)
+		return
+	}
+	entry := findLogByID(snapshot.Logs, id)
+	if entry == nil {
+		http.Error(w, `{"error": "Запись не найдена"}`, http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	decode := query.Get("decode") == "true"
+	pretty := decode || query.Get("pretty") == "true"
+
+	raw := rawJSON(entry)
+	if !pretty {
+		w.Write([]byte(raw))
+		return
+	}
+
+	data, err := unmarshalExact([]byte(raw))
+	if err != nil {
+		w.Write([]byte(raw))
+		return
+	}
+	if decode {
+		data = decodeNestedJSON(data)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(data)
+	w.Write(buf.Bytes())
+}
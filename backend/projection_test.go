@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const projectionSample = `{"@level":"debug","@message":"request","@module":"provider","tf_req_id":"r1","http":{"status":200},"tf_http_req_body":"{\"name\":\"web\"}"}
+`
+
+func TestProjectionAPI(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", projectionSample, nil)
+
+	var full struct {
+		Logs []map[string]interface{} `json:"logs"`
+	}
+	serveJSON(t, server, "GET", "/api/logs", "", &full)
+	if len(full.Logs) != 1 || full.Logs[0]["Message"] != "request" {
+		t.Fatalf("записи без fields: %+v", full.Logs)
+	}
+	if _, exists := full.Logs[0]["RawJSON"]; exists {
+		t.Errorf("исходная строка не должна отдаваться в списке: %+v", full.Logs[0])
+	}
+
+	var projected struct {
+		Logs []map[string]interface{} `json:"logs"`
+	}
+	serveJSON(t, server, "GET", "/api/logs?fields=level,tf_req_id,attr.http.status,missing", "", &projected)
+	want := map[string]interface{}{"ID": 0.0, "Level": "debug", "TfReqID": "r1", "attr.http.status": 200.0}
+	if len(projected.Logs) != 1 || !reflect.DeepEqual(projected.Logs[0], want) {
+		t.Errorf("проекция %+v, ожидалось %+v", projected.Logs, want)
+	}
+}
+
+func TestLogRawAPI(t *testing.T) {
+	_, server := testServer(t)
+	if code := serveJSON(t, server, "GET", "/api/logs/0/raw", "", nil); code != http.StatusNotFound {
+		t.Errorf("пустая сессия: код %d", code)
+	}
+	serveJSON(t, server, "POST", "/api/logs", projectionSample, nil)
+
+	raw := func(target string) (int, string) {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+	if code, body := raw("/api/logs/0/raw"); code != http.StatusOK || body != strings.TrimSpace(projectionSample) {
+		t.Errorf("исходная строка: код %d, %s", code, body)
+	}
+	if _, body := raw("/api/logs/0/raw?pretty=true"); !strings.Contains(body, "\n  \"@level\": \"debug\"") || !strings.Contains(body, `"{\"name\":\"web\"}"`) {
+		t.Errorf("pretty: %s", body)
+	}
+	if _, body := raw("/api/logs/0/raw?decode=true"); !strings.Contains(body, "\"tf_http_req_body\": {\n    \"name\": \"web\"") {
+		t.Errorf("decode: %s", body)
+	}
+
+	for target, code := range map[string]int{
+		"/api/logs/1/raw": http.StatusNotFound,
+		"/api/logs/x/raw": http.StatusBadRequest,
+	} {
+		if got, _ := raw(target); got != code {
+			t.Errorf("%s: код %d, ожидался %d", target, got, code)
+		}
+	}
+}
+
+func TestLogRawAPIKeepsLargeNumbers(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", `{"@level":"info","@message":"big","object_id":9007199254740993,"tf_http_req_body":"{\"size\":12345678901234567890,\"ratio\":0.1}","tf_http_res_body":"{\"a\":1} tail"}`+"\n", nil)
+
+	for _, target := range []string{"/api/logs/0/raw?pretty=true", "/api/logs/0/raw?decode=true"} {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if body := recorder.Body.String(); !strings.Contains(body, `"object_id": 9007199254740993`) {
+			t.Errorf("%s: число округлено: %s", target, body)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/logs/0/raw?decode=true", nil))
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"size": 12345678901234567890`) || !strings.Contains(body, `"ratio": 0.1`) {
+		t.Errorf("вложенный JSON: числа изменены: %s", body)
+	}
+	if !strings.Contains(body, `"tf_http_res_body": "{\"a\":1} tail"`) {
+		t.Errorf("строка с JSON и хвостом должна остаться строкой: %s", body)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Разбор сообщений protobuf без сгенерированного кода: поля читаются по номерам,
+// неизвестные пропускаются. Достаточно для протоколов сборщиков (Loki, OTLP).
+
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("protobuf: сообщение обрезано")
+
+// protoField - поле сообщения: Value для varint и fixed, Bytes для строк,
+// байтов и вложенных сообщений
+type protoField struct {
+	Num   int
+	Wire  int
+	Value uint64
+	Bytes []byte
+}
+
+func (f protoField) String() string { return string(f.Bytes) }
+
+// Int64 - значение int64/int32 (varint в дополнительном коде)
+func (f protoField) Int64() int64 { return int64(f.Value) }
+
+// Double - значение double (fixed64)
+func (f protoField) Double() float64 { return math.Float64frombits(f.Value) }
+
+// walkProto - вызов fn для каждого поля сообщения по порядку
+func walkProto(data []byte, fn func(field protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+		field := protoField{Num: int(key >> 3), Wire: int(key & 7)}
+		if field.Num == 0 {
+			return errors.New("protobuf: поле с номером 0")
+		}
+
+		switch field.Wire {
+		case protoVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errProtoTruncated
+			}
+			field.Value, data = value, data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			field.Value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			field.Value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return errProtoTruncated
+			}
+			field.Bytes, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return fmt.Errorf("protobuf: неподдерживаемый тип поля %d", field.Wire)
+		}
+
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// protoAppend - поле protobuf с байтами или вложенным сообщением
+func protoAppend(buf []byte, num int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(num<<3|protoBytes))
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func TestWalkProto(t *testing.T) {
+	valid := binary.AppendUvarint([]byte{1 << 3}, 300)
+	valid = binary.LittleEndian.AppendUint64(append(valid, 2<<3|protoFixed64), math.Float64bits(1.5))
+	valid = binary.LittleEndian.AppendUint32(append(valid, 3<<3|protoFixed32), 7)
+	valid = protoAppend(valid, 4, []byte("текст"))
+	valid = append(valid, 0x80, 0x01, 0x01) // поле 16: двухбайтовый ключ
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+		err   string
+	}{
+		{"все типы полей", valid, "1:0=300 2:1=1.5 3:5=7 4:2=текст 16:0=1", ""},
+		{"пустое сообщение", nil, "", ""},
+		{"обрезанный ключ", []byte{0x80}, "", "обрезано"},
+		{"обрезанный varint", []byte{1 << 3, 0x80, 0x80}, "", "обрезано"},
+		{"слишком длинный varint", append([]byte{1 << 3}, bytes.Repeat([]byte{0xff}, 11)...), "", "обрезано"},
+		{"обрезанный fixed64", []byte{2<<3 | protoFixed64, 1, 2, 3}, "", "обрезано"},
+		{"обрезанный fixed32", []byte{3<<3 | protoFixed32, 1}, "", "обрезано"},
+		{"длина больше данных", []byte{4<<3 | protoBytes, 5, 'a', 'b'}, "", "обрезано"},
+		{"огромная длина", append([]byte{4<<3 | protoBytes}, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01), "", "обрезано"},
+		{"поле с номером 0", []byte{protoVarint, 1}, "", "номером 0"},
+		{"группа (тип 3)", []byte{1<<3 | 3}, "", "неподдерживаемый тип"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fields []string
+			err := walkProto(test.input, func(field protoField) error {
+				value := fmt.Sprint(field.Value)
+				switch field.Wire {
+				case protoBytes:
+					value = field.String()
+				case protoFixed64:
+					value = fmt.Sprint(field.Double())
+				}
+				fields = append(fields, fmt.Sprintf("%d:%d=%s", field.Num, field.Wire, value))
+				return nil
+			})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("ошибка %v, ожидалось %q", err, test.err)
+				}
+				return
+			}
+			if got := strings.Join(fields, " "); err != nil || got != test.want {
+				t.Fatalf("поля %q, %v", got, err)
+			}
+		})
+	}
+
+	// Ошибка обработчика прерывает разбор
+	stop := errors.New("stop")
+	calls := 0
+	if err := walkProto(valid, func(protoField) error { calls++; return stop }); err != stop || calls != 1 {
+		t.Errorf("ошибка обработчика: %v после %d полей", err, calls)
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Язык запросов для параметра q= в GET /api/logs:
+//
+//	level:(error OR warn) AND module:provider.* AND NOT message~"retrying" AND tf_http_res_status_code>=500
+//
+// Операторы: ':' и '=' (равенство без учета регистра, для message - подстрока,
+// поддерживаются шаблоны * и ?), '!=' , '~' (регулярное выражение), '>', '>=', '<', '<='.
+// Слово без поля ищется в сообщении. Неизвестные поля берутся из атрибутов
+// записи, вложенные - через точку (hook.resource.addr или attr.hook.resource.addr).
+// Значение timestamp задается как в since/until: timestamp>=start+5m, timestamp:10:00:02.
+
+// QueryError - ошибка разбора запроса с позицией (с 1) проблемного места
+type QueryError struct {
+	Pos     int
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("ошибка в запросе на позиции %d: %s", e.Pos, e.Message)
+}
+
+// queryNode - узел дерева разобранного запроса
+type queryNode interface {
+	match(log *TerraformLog) bool
+}
+
+type andNode struct{ left, right queryNode }
+type orNode struct{ left, right queryNode }
+type notNode struct{ inner queryNode }
+
+func (n andNode) match(log *TerraformLog) bool { return n.left.match(log) && n.right.match(log) }
+func (n orNode) match(log *TerraformLog) bool  { return n.left.match(log) || n.right.match(log) }
+func (n notNode) match(log *TerraformLog) bool { return !n.inner.match(log) }
+
+// predicateNode - сравнение одного поля записи со значением
+type predicateNode struct {
+	field   string
+	op      string
+	value   string
+	pattern *regexp.Regexp // для '~' и шаблонов с * и ?
+	number  float64
+	numeric bool
+	time    time.Time
+	isTime  bool
+}
+
+// queryParser - рекурсивный спуск по строке запроса
+type queryParser struct {
+	input string
+	pos   int
+	time  timeContext
+}
+
+// parseQuery - разбор строки запроса в дерево условий
+func parseQuery(input string, ctx timeContext) (queryNode, error) {
+	p := &queryParser{input: input, time: ctx}
+	p.skipSpaces()
+	if p.eof() {
+		return nil, &QueryError{Pos: 1, Message: "пустой запрос"}
+	}
+
+	node, err := p.parseOr("", "")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if !p.eof() {
+		return nil, p.errorf("неожиданный символ %q", p.input[p.pos])
+	}
+	return node, nil
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return &QueryError{Pos: p.pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) skipSpaces() {
+	for !p.eof() && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// keyword - проверка ключевого слова (AND, OR, NOT, &&, ||, !) без его поглощения
+func (p *queryParser) keyword(words ...string) (string, bool) {
+	p.skipSpaces()
+	for _, word := range words {
+		if !strings.HasPrefix(p.input[p.pos:], word) {
+			continue
+		}
+		end := p.pos + len(word)
+		// Словесные операторы должны стоять отдельно: "ORDER" - это не OR
+		if unicode.IsLetter(rune(word[0])) && end < len(p.input) && !isQueryBoundary(p.input[end]) {
+			continue
+		}
+		return word, true
+	}
+	return "", false
+}
+
+func isQueryBoundary(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '(' || c == ')'
+}
+
+// parseOr - expr := and (OR and)*
+// field и op не пусты внутри группы значений вида level:(error OR warn)
+func (p *queryParser) parseOr(field, op string) (queryNode, error) {
+	left, err := p.parseAnd(field, op)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		word, ok := p.keyword("OR", "||")
+		if !ok {
+			return left, nil
+		}
+		p.pos += len(word)
+		right, err := p.parseAnd(field, op)
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+// parseAnd - and := not ((AND)? not)*, соседние условия объединяются через AND
+func (p *queryParser) parseAnd(field, op string) (queryNode, error) {
+	left, err := p.parseNot(field, op)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if word, ok := p.keyword("AND", "&&"); ok {
+			p.pos += len(word)
+		} else if _, ok := p.keyword("OR", "||"); ok || p.eof() || p.input[p.pos] == ')' {
+			return left, nil
+		}
+		right, err := p.parseNot(field, op)
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+// parseNot - not := (NOT | !) not | primary
+func (p *queryParser) parseNot(field, op string) (queryNode, error) {
+	if word, ok := p.keyword("NOT", "!"); ok {
+		// "!=" - это оператор сравнения, а не отрицание
+		if word != "!" || !strings.HasPrefix(p.input[p.pos:], "!=") {
+			p.pos += len(word)
+			inner, err := p.parseNot(field, op)
+			if err != nil {
+				return nil, err
+			}
+			return notNode{inner}, nil
+		}
+	}
+	return p.parsePrimary(field, op)
+}
+
+// parsePrimary - primary := '(' expr ')' | field op value | field op '(' values ')' | term
+func (p *queryParser) parsePrimary(field, op string) (queryNode, error) {
+	p.skipSpaces()
+	if p.eof() {
+		return nil, p.errorf("ожидалось условие, а запрос закончился")
+	}
+
+	if p.input[p.pos] == '(' {
+		open := p.pos
+		p.pos++
+		node, err := p.parseOr(field, op)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.eof() || p.input[p.pos] != ')' {
+			return nil, &QueryError{Pos: open + 1, Message: "незакрытая скобка"}
+		}
+		p.pos++
+		return node, nil
+	}
+	if p.input[p.pos] == ')' {
+		return nil, p.errorf("лишняя закрывающая скобка")
+	}
+
+	// Внутри группы значений каждое слово сравнивается с полем группы
+	if field != "" {
+		valuePos := p.pos
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+		return p.newPredicate(field, op, value, valuePos)
+	}
+
+	start := p.pos
+	if p.input[p.pos] != '"' {
+		name := p.readFieldName()
+		if name != "" {
+			if fieldOp := p.readOperator(); fieldOp != "" {
+				p.skipSpaces()
+				if p.eof() {
+					return nil, p.errorf("ожидалось значение после %q", fieldOp)
+				}
+				if p.input[p.pos] == '(' {
+					return p.parsePrimary(name, fieldOp)
+				}
+				valuePos := p.pos
+				value, err := p.readValue()
+				if err != nil {
+					return nil, err
+				}
+				if normalizeQueryField(name) == "timestamp" {
+					value = p.timeValue(value, valuePos)
+				}
+				return p.newPredicate(name, fieldOp, value, valuePos)
+			}
+		}
+		p.pos = start
+	}
+
+	// Просто слово - поиск по сообщению
+	value, err := p.readValue()
+	if err != nil {
+		return nil, err
+	}
+	return p.newPredicate("message", ":", value, start)
+}
+
+func (p *queryParser) readFieldName() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.input[p.pos]
+		if c == '_' || c == '.' || c == '@' || c == '-' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *queryParser) readOperator() string {
+	for _, op := range []string{">=", "<=", "!=", ":", "=", "~", ">", "<"} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// readValue - слово до пробела или скобки, либо строка в кавычках с экранированием \"
+func (p *queryParser) readValue() (string, error) {
+	p.skipSpaces()
+	if p.eof() {
+		return "", p.errorf("ожидалось значение")
+	}
+
+	if p.input[p.pos] == '"' {
+		open := p.pos
+		p.pos++
+		var b strings.Builder
+		for !p.eof() {
+			c := p.input[p.pos]
+			switch {
+			case c == '\\' && p.pos+1 < len(p.input):
+				b.WriteByte(p.input[p.pos+1])
+				p.pos += 2
+			case c == '"':
+				p.pos++
+				return b.String(), nil
+			default:
+				b.WriteByte(c)
+				p.pos++
+			}
+		}
+		return "", &QueryError{Pos: open + 1, Message: "незакрытая кавычка"}
+	}
+
+	start := p.pos
+	for !p.eof() && !isQueryBoundary(p.input[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("ожидалось значение, найдено %q", p.input[p.pos])
+	}
+	return p.input[start:p.pos], nil
+}
+
+// timeValue - значение времени с "+", пришедшим пробелом из неэкранированной
+// строки запроса: "timestamp>=start 5m" и "timestamp>= 5m" читаются как
+// start+5m и +5m, а не как условие и отдельное слово для поиска
+func (p *queryParser) timeValue(value string, valuePos int) string {
+	if valuePos > 0 && p.input[valuePos-1] == ' ' && p.input[valuePos] != '"' {
+		if restored := restorePlus(" " + value); restored != " "+value {
+			return restored
+		}
+	}
+	next := p.pos
+	p.skipSpaces()
+	if p.pos > next && !p.eof() && p.input[p.pos] != '"' && !isQueryBoundary(p.input[p.pos]) {
+		word := p.pos
+		for !p.eof() && !isQueryBoundary(p.input[p.pos]) {
+			p.pos++
+		}
+		if restored := restorePlus(value + " " + p.input[word:p.pos]); restored != value+" "+p.input[word:p.pos] {
+			return restored
+		}
+	}
+	p.pos = next
+	return value
+}
+
+// newPredicate - подготовка условия: компиляция шаблонов, разбор чисел и времени
+func (p *queryParser) newPredicate(field, op, value string, pos int) (queryNode, error) {
+	field = normalizeQueryField(field)
+	pred := predicateNode{field: field, op: op, value: value}
+
+	switch op {
+	case "~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, &QueryError{Pos: pos + 1, Message: fmt.Sprintf("неверное регулярное выражение: %v", err)}
+		}
+		pred.pattern = re
+	case ":", "=", "!=":
+		if strings.ContainsAny(value, "*?") {
+			pred.pattern = wildcardPattern(value)
+		} else if number, err := strconv.ParseFloat(value, 64); err == nil && strings.HasPrefix(field, "attr.") {
+			// Числа атрибутов равны по значению: size:1000000 и size:1e6
+			pred.number, pred.numeric = number, true
+		}
+	default:
+		if number, err := strconv.ParseFloat(value, 64); err == nil && field != "timestamp" {
+			pred.number, pred.numeric = number, true
+		}
+	}
+
+	// Время сравнивается как время при всех операторах, кроме '~' и шаблонов:
+	// они проверяют запись времени в формате RFC 3339
+	if field == "timestamp" && pred.pattern == nil {
+		t, err := p.time.parse(value)
+		if err != nil {
+			return nil, &QueryError{Pos: pos + 1, Message: err.Error()}
+		}
+		pred.time, pred.isTime = t, true
+	}
+
+	return pred, nil
+}
+
+// normalizeQueryField - синонимы полей и префикс attr. для атрибутов
+func normalizeQueryField(field string) string {
+	lower := strings.ToLower(strings.TrimPrefix(field, "@"))
+	switch lower {
+	case "level", "module", "caller", "message", "timestamp":
+		return lower
+	case "msg":
+		return "message"
+	case "time", "ts":
+		return "timestamp"
+	case "type", "entry_type", "entrytype":
+		return "entry_type"
+	}
+	if strings.HasPrefix(field, "attr.") {
+		return field
+	}
+	return "attr." + field
+}
+
+// wildcardPattern - шаблон с * и ? в регулярное выражение без учета регистра
+func wildcardPattern(value string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range value {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (n predicateNode) match(log *TerraformLog) bool {
+	if n.isTime {
+		return compareOrdered(log.Timestamp.Compare(n.time), n.op)
+	}
+
+	raw, exists := queryFieldValue(log, n.field)
+	if !exists {
+		return n.op == "!="
+	}
+
+	if n.numeric {
+		if number, ok := toFloat(raw); ok {
+			switch {
+			case number < n.number:
+				return compareOrdered(-1, n.op)
+			case number > n.number:
+				return compareOrdered(1, n.op)
+			}
+			return compareOrdered(0, n.op)
+		}
+	}
+
+	var value string
+	switch v := raw.(type) {
+	case time.Time:
+		value = v.Format(time.RFC3339Nano)
+	case float64:
+		// Без экспоненты: 1000000, а не 1e+06
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		value = fmt.Sprint(raw)
+	}
+	switch n.op {
+	case "~":
+		return n.pattern.MatchString(value)
+	case ":", "=":
+		return n.matchText(value)
+	case "!=":
+		return !n.matchText(value)
+	}
+	return compareOrdered(strings.Compare(value, n.value), n.op)
+}
+
+// matchText - равенство без учета регистра, шаблон или подстрока для сообщения
+func (n predicateNode) matchText(value string) bool {
+	if n.pattern != nil {
+		return n.pattern.MatchString(value)
+	}
+	if n.field == "message" {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(n.value))
+	}
+	return strings.EqualFold(value, n.value)
+}
+
+func compareOrdered(cmp int, op string) bool {
+	switch op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	}
+	return cmp == 0
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// queryFieldValue - значение поля записи по имени из запроса
+func queryFieldValue(log *TerraformLog, field string) (interface{}, bool) {
+	switch field {
+	case "level":
+		return log.Level(), true
+	case "module":
+		return log.Module(), true
+	case "caller":
+		return log.Caller(), true
+	case "message":
+		return log.Message(), true
+	case "entry_type":
+		return log.EntryType(), true
+	case "timestamp":
+		return log.Timestamp, true
+	}
+	return attributePath(logAttributes(*log), strings.TrimPrefix(field, "attr."))
+}
+
+// attributePath - значение вложенного атрибута по пути через точку
+func attributePath(attrs map[string]interface{}, path string) (interface{}, bool) {
+	// Сначала ищем ключ целиком: в логах Terraform бывают ключи с точками
+	if value, exists := attrs[path]; exists {
+		return value, true
+	}
+
+	var current interface{} = attrs
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const querySample = `{"@level":"info","@message":"Starting apply","@module":"terraform","@timestamp":"2025-09-09T10:00:00Z"}
+{"@level":"warn","@message":"retrying request","@module":"provider.terraform-provider-aws","@timestamp":"2025-09-09T10:00:01.5Z","tf_http_res_status_code":503,"hook":{"resource":{"addr":"aws_instance.web"}}}
+{"@level":"error","@message":"Request failed","@module":"provider.terraform-provider-aws","@timestamp":"2025-09-09T10:00:02Z","tf_http_res_status_code":500}
+{"@level":"debug","@message":"ok","@module":"provider.terraform-provider-google","@timestamp":"2025-09-09T10:00:03Z","tf_http_res_status_code":"200"}
+`
+
+func TestParseQueryMatches(t *testing.T) {
+	logs := NewLogParser().ParseStream(strings.NewReader(querySample)).Logs
+	ctx, err := newTimeContext(logs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		ids   []int
+	}{
+		{"level:error", []int{2}},
+		{"LEVEL=ERROR", []int{2}},
+		{"level:(error OR warn)", []int{1, 2}},
+		{"level!=info", []int{1, 2, 3}},
+		// AND связывает сильнее OR, NOT - сильнее AND, соседние условия - через AND
+		{"level:error OR level:warn AND module:*google", []int{2}},
+		{"(level:error OR level:warn) AND module:*aws", []int{1, 2}},
+		{"NOT level:info module:provider.*", []int{1, 2, 3}},
+		{"!level:info && !module:*aws", []int{3}},
+		{"level:warn || level:debug", []int{1, 3}},
+		{"NOT (level:info OR level:debug)", []int{1, 2}},
+		// Шаблоны * и ? без учета регистра, регулярные выражения - как есть
+		{"module:provider.terraform-provider-???", []int{1, 2}},
+		{"module:PROVIDER*", []int{1, 2, 3}},
+		{"module!=*aws", []int{0, 3}},
+		{"message~^Re", []int{2}},
+		{`message~"(?i)^re"`, []int{1, 2}},
+		{"module~google$", []int{3}},
+		// Слово без поля - подстрока сообщения; ORDER - слово, а не оператор OR
+		{"request", []int{1, 2}},
+		{`"request failed"`, []int{2}},
+		{"ORDER", nil},
+		// Числа сравниваются как числа, в том числе записанные строкой
+		{"tf_http_res_status_code>=500", []int{1, 2}},
+		{"tf_http_res_status_code<500", []int{3}},
+		{"tf_http_res_status_code:503", []int{1}},
+		{"hook.resource.addr:aws_instance.web", []int{1}},
+		{"attr.hook.resource.addr=aws_instance.*", []int{1}},
+		{"missing!=x", []int{0, 1, 2, 3}},
+		{"missing:x", nil},
+		// Время разбирается как в since/until при любом операторе
+		{"timestamp>=2025-09-09T10:00:02Z", []int{2, 3}},
+		{"timestamp:2025-09-09T10:00:01.5Z", []int{1}},
+		{"timestamp:2025-09-09T13:00:02+03:00", []int{2}},
+		{"timestamp=10:00:02", []int{2}},
+		{"timestamp!=start", []int{1, 2, 3}},
+		{"ts<start+2s", []int{0, 1}},
+		{"@timestamp>-1s", []int{3}},
+		{"timestamp:2025-09-09T10:00:0?Z", []int{0, 2, 3}},
+		{"timestamp~\\.5Z$", []int{1}},
+	}
+	for _, tt := range tests {
+		node, err := parseQuery(tt.query, ctx)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		var ids []int
+		for i := range logs {
+			if node.match(&logs[i]) {
+				ids = append(ids, logs[i].ID)
+			}
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: записи %v, ожидались %v", tt.query, ids, tt.ids)
+		}
+	}
+}
+
+func TestQueryLargeNumbers(t *testing.T) {
+	logs := NewLogParser().ParseStream(strings.NewReader(`{"@message":"a","size":1000000}
+{"@message":"b","size":"1000000"}
+{"@message":"c","size":1e6}
+{"@message":"d","size":10000000}
+{"@message":"1000000"}
+`)).Logs
+
+	tests := []struct {
+		query string
+		ids   []int
+	}{
+		{"size:1000000", []int{0, 1, 2}},
+		{"size=1e6", []int{0, 1, 2}},
+		{"size!=1000000", []int{3, 4}},
+		{"size~^1000000$", []int{0, 1, 2}},
+		{"size:10000*", []int{0, 1, 2, 3}},
+		{"message:100000", []int{4}},
+	}
+	for _, tt := range tests {
+		node, err := parseQuery(tt.query, timeContext{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var ids []int
+		for i := range logs {
+			if node.match(&logs[i]) {
+				ids = append(ids, logs[i].ID)
+			}
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: записи %v, ожидались %v", tt.query, ids, tt.ids)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		pos     int
+		message string
+	}{
+		{"", 1, "пустой запрос"},
+		{"   ", 1, "пустой запрос"},
+		{"level:(error OR warn", 7, "незакрытая скобка"},
+		{"(level:error", 1, "незакрытая скобка"},
+		{"level:error)", 12, "неожиданный символ"},
+		{"()", 2, "лишняя закрывающая скобка"},
+		{`message:"abc`, 9, "незакрытая кавычка"},
+		{"message~[", 9, "регулярное выражение"},
+		{"level:", 7, "ожидалось значение после"},
+		{"level:error AND", 16, "запрос закончился"},
+		{"NOT", 4, "запрос закончился"},
+		{"timestamp>вчера", 11, "неверный формат времени"},
+		{"timestamp:start+5x", 11, "неверный интервал"},
+	}
+	for _, tt := range tests {
+		_, err := parseQuery(tt.query, timeContext{})
+		queryErr, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("%q: ожидалась ошибка разбора, получено %v", tt.query, err)
+			continue
+		}
+		if queryErr.Pos != tt.pos || !strings.Contains(queryErr.Message, tt.message) {
+			t.Errorf("%q: позиция %d, %q; ожидалось %d, %q", tt.query, queryErr.Pos, queryErr.Message, tt.pos, tt.message)
+		}
+	}
+}
+
+func TestQueryErrorPositionAPI(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", querySample, nil)
+
+	var response struct {
+		Status   string `json:"status"`
+		Position int    `json:"position"`
+	}
+	code := serveJSON(t, server, "GET", "/api/logs?q=level:(error", "", &response)
+	if code != http.StatusBadRequest || response.Status != "error" || response.Position != 7 {
+		t.Errorf("код %d, ответ %+v", code, response)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RequestNode - узел дерева вызова: RPC -> вызовы SDK -> HTTP обмены
+type RequestNode struct {
+	Kind       string // request, resource, rpc, sdk, http
+	ID         string
+	Label      string
+	Start      time.Time
+	End        time.Time
+	DurationMs int64
+	EntryIDs   []int // записи, относящиеся непосредственно к узлу
+	Children   []*RequestNode
+}
+
+// correlationIDs - идентификаторы корреляции записи: tf_req_id, tf_http_trans_id
+// и прочие атрибуты вида tf_*_id. У записей хранилища они вычислены при записи,
+// см. compactLogs; результат нельзя изменять.
+func correlationIDs(log *TerraformLog) []string {
+	if ids, ok := log.raw.correlation(); ok {
+		return ids
+	}
+
+	var ids []string
+	if log.TfReqID() != "" {
+		ids = append(ids, log.TfReqID())
+	}
+	for key, value := range logAttributes(*log) {
+		if key == "tf_req_id" || !strings.HasPrefix(key, "tf_") || !strings.HasSuffix(key, "_id") {
+			continue
+		}
+		if id, ok := value.(string); ok && id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// collectCorrelated - все записи, связанные с id через общие идентификаторы;
+// идентификаторы найденных записей тоже участвуют в поиске (обход в ширину
+// по индексу идентификатор -> записи)
+func collectCorrelated(logs []TerraformLog, id string) []TerraformLog {
+	entries := make(map[string][]int)
+	for i := range logs {
+		for _, key := range correlationIDs(&logs[i]) {
+			entries[key] = append(entries[key], i)
+		}
+	}
+
+	included := make([]bool, len(logs))
+	known := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		for _, i := range entries[key] {
+			if included[i] {
+				continue
+			}
+			included[i] = true
+			for _, related := range correlationIDs(&logs[i]) {
+				if !known[related] {
+					known[related] = true
+					queue = append(queue, related)
+				}
+			}
+		}
+	}
+
+	var related []TerraformLog
+	for i := range logs {
+		if included[i] {
+			related = append(related, logs[i])
+		}
+	}
+	return related
+}
+
+// buildRequestTree - дерево вызовов по связанным записям
+func buildRequestTree(root *RequestNode, entries []TerraformLog) {
+	rpcs := make(map[string]*RequestNode)
+	sdks := make(map[string]*RequestNode)
+	https := make(map[string]*RequestNode)
+
+	for i := range entries {
+		entry := &entries[i]
+		attrs := logAttributes(*entry)
+		transID := getString(attrs, "tf_http_trans_id")
+
+		// Продолжение уже начатого HTTP обмена остается в его узле
+		if exchange, exists := https[transID]; exists {
+			exchange.EntryIDs = append(exchange.EntryIDs, entry.ID)
+			extendNode(exchange, entry.Timestamp)
+			continue
+		}
+
+		parent := root
+		if entry.TfReqID() != "" {
+			rpc, exists := rpcs[entry.TfReqID()]
+			if !exists {
+				rpc = &RequestNode{Kind: "rpc", ID: entry.TfReqID()}
+				rpcs[entry.TfReqID()] = rpc
+				root.Children = append(root.Children, rpc)
+			}
+			if rpc.Label == "" && entry.TfRPC() != "" {
+				rpc.Label = entry.TfRPC()
+			}
+			parent = rpc
+
+			// Вызовы SDK - записи подсистем провайдера внутри RPC
+			if entry.Module() != "" {
+				key := entry.TfReqID() + "|" + entry.Module()
+				sdk, exists := sdks[key]
+				if !exists {
+					sdk = &RequestNode{Kind: "sdk", ID: key, Label: entry.Module()}
+					sdks[key] = sdk
+					rpc.Children = append(rpc.Children, sdk)
+				}
+				parent = sdk
+			}
+		}
+
+		if transID != "" {
+			exchange := &RequestNode{Kind: "http", ID: transID}
+			if method := getString(attrs, "tf_http_req_method"); method != "" {
+				exchange.Label = strings.TrimSpace(method + " " + getString(attrs, "tf_http_req_uri"))
+			}
+			https[transID] = exchange
+			parent.Children = append(parent.Children, exchange)
+			parent = exchange
+		}
+
+		parent.EntryIDs = append(parent.EntryIDs, entry.ID)
+		extendNode(parent, entry.Timestamp)
+	}
+
+	finishNode(root)
+}
+
+func extendNode(node *RequestNode, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if node.Start.IsZero() || t.Before(node.Start) {
+		node.Start = t
+	}
+	if t.After(node.End) {
+		node.End = t
+	}
+}
+
+// finishNode - время узла охватывает все дочерние узлы; дети упорядочены по началу
+func finishNode(node *RequestNode) {
+	for _, child := range node.Children {
+		finishNode(child)
+		extendNode(node, child.Start)
+		extendNode(node, child.End)
+	}
+	sort.SliceStable(node.Children, func(i, j int) bool {
+		return node.Children[i].Start.Before(node.Children[j].Start)
+	})
+	node.DurationMs = node.End.Sub(node.Start).Milliseconds()
+}
+
+// collectResourceEntries - записи операций над ресурсом: события hook и обхода графа
+// с этим адресом, а также RPC провайдера для того же типа ресурса внутри этих интервалов
+func collectResourceEntries(logs []TerraformLog, addr string) []TerraformLog {
+	var spans []OperationSpan
+	for _, span := range buildTimeline(logs).Spans {
+		if span.Resource == addr {
+			spans = append(spans, span)
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	resourceType := resourceTypeOf(addr)
+	rpcIDs := make(map[string]bool)
+	for i := range logs {
+		log := &logs[i]
+		if log.TfReqID() == "" || getString(logAttributes(*log), "tf_resource_type") != resourceType {
+			continue
+		}
+		for _, span := range spans {
+			if !log.Timestamp.Before(span.Start) && !log.Timestamp.After(span.End) {
+				rpcIDs[log.TfReqID()] = true
+			}
+		}
+	}
+
+	var related []TerraformLog
+	for i := range logs {
+		log := &logs[i]
+		name, _, isVertex := parseVertexMessage(log.Message())
+		switch {
+		case isVertex && name == addr,
+			strings.HasPrefix(log.Message(), addr+":"),
+			log.TfReqID() != "" && rpcIDs[log.TfReqID()]:
+			related = append(related, *log)
+		}
+	}
+	return related
+}
+
+// resourceTypeOf - тип ресурса из адреса: module.net.aws_subnet.a[0] -> aws_subnet
+func resourceTypeOf(addr string) string {
+	parts := strings.Split(resourceBase(addr), ".")
+	for len(parts) >= 2 && parts[0] == "module" {
+		parts = parts[2:]
+	}
+	if len(parts) > 0 && parts[0] == "data" {
+		parts = parts[1:]
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[0]
+}
+
+// Обработчик API для всех записей одного вызова: GET /api/requests/{id}
+// id - tf_req_id, tf_http_trans_id или адрес ресурса
+func handleAPIRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := requestStore(w, r)
+	if !ok {
+		return
+	}
+	snapshot := store.Snapshot()
+	if snapshot == nil {
+		http.Error(w, `{"error": "Нет данных логов"}`, http.StatusNotFound)
+		return
+	}
+
+	id := r.PathValue("id")
+	root := &RequestNode{Kind: "request", ID: id, Label: id}
+	entries := collectCorrelated(snapshot.Logs, id)
+	if len(entries) == 0 {
+		root.Kind = "resource"
+		entries = collectResourceEntries(snapshot.Logs, id)
+	}
+	if len(entries) == 0 {
+		http.Error(w, `{"error": "Записи с таким идентификатором не найдены"}`, http.StatusNotFound)
+		return
+	}
+
+	buildRequestTree(root, entries)
+
+	ctx, err := newTimeContext(snapshot.Logs, r.URL.Query().Get("tz"))
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	ctx.renderLogs(entries)
+	renderRequestNode(ctx, root)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"id":     id,
+		"tree":   root,
+		"logs":   projectLogs(entries, r.URL.Query().Get("fields")),
+		"count":  len(entries),
+	})
+}
+
+func renderRequestNode(ctx timeContext, node *RequestNode) {
+	node.Start = ctx.render(node.Start)
+	node.End = ctx.render(node.End)
+	for _, child := range node.Children {
+		renderRequestNode(ctx, child)
+	}
+}
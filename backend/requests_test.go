@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// requestsSample - RPC r1 с HTTP обменом h1; ответ HTTP связан с r1 только через h1
+const requestsSample = `{"@level":"trace","@message":"vertex \"aws_instance.web\": starting visit (*terraform.NodeApplyableResourceInstance)","@timestamp":"2025-09-09T10:00:00Z"}
+{"@level":"debug","@message":"Received request","@module":"provider","@timestamp":"2025-09-09T10:00:01Z","tf_req_id":"r1","tf_rpc":"ApplyResourceChange","tf_resource_type":"aws_instance"}
+{"@level":"debug","@message":"HTTP Request Sent","@module":"provider.terraform-provider-aws","@timestamp":"2025-09-09T10:00:02Z","tf_req_id":"r1","tf_http_trans_id":"h1","tf_http_req_method":"POST","tf_http_req_uri":"/ec2"}
+{"@level":"debug","@message":"HTTP Response Received","@module":"provider.terraform-provider-aws","@timestamp":"2025-09-09T10:00:03Z","tf_http_trans_id":"h1"}
+{"@level":"debug","@message":"Received request","@module":"provider","@timestamp":"2025-09-09T10:00:03Z","tf_req_id":"r2","tf_rpc":"ReadResource"}
+{"@level":"debug","@message":"Served request","@module":"provider","@timestamp":"2025-09-09T10:00:04Z","tf_req_id":"r1"}
+{"@level":"trace","@message":"vertex \"aws_instance.web\": visit complete","@timestamp":"2025-09-09T10:00:05Z"}
+`
+
+// treeShape - узлы дерева вызова в виде kind:label[записи] в порядке обхода
+func treeShape(node *RequestNode) []string {
+	shape := []string{node.Kind + ":" + node.Label + fmt.Sprint(node.EntryIDs)}
+	for _, child := range node.Children {
+		shape = append(shape, treeShape(child)...)
+	}
+	return shape
+}
+
+func TestRequestAPI(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", requestsSample, nil)
+
+	tests := []struct {
+		id    string
+		ids   []int
+		shape []string
+	}{
+		{"r1", []int{1, 2, 3, 5}, []string{
+			"request:r1[]",
+			"rpc:ApplyResourceChange[]",
+			"sdk:provider[1 5]",
+			"sdk:provider.terraform-provider-aws[]",
+			"http:POST /ec2[2 3]",
+		}},
+		// Поиск по HTTP обмену находит RPC через общую запись и остальные записи RPC
+		{"h1", []int{1, 2, 3, 5}, nil},
+		{"r2", []int{4}, nil},
+		// Адрес ресурса: вершины графа и RPC того же типа ресурса внутри интервала
+		{"aws_instance.web", []int{0, 1, 2, 5, 6}, nil},
+	}
+	for _, tt := range tests {
+		var response struct {
+			Tree RequestNode    `json:"tree"`
+			Logs []TerraformLog `json:"logs"`
+		}
+		if code := serveJSON(t, server, "GET", "/api/requests/"+tt.id, "", &response); code != http.StatusOK {
+			t.Errorf("%s: код %d", tt.id, code)
+			continue
+		}
+		var ids []int
+		for _, entry := range response.Logs {
+			ids = append(ids, entry.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: записи %v, ожидались %v", tt.id, ids, tt.ids)
+		}
+		if shape := treeShape(&response.Tree); tt.shape != nil && !reflect.DeepEqual(shape, tt.shape) {
+			t.Errorf("%s: дерево %v, ожидалось %v", tt.id, shape, tt.shape)
+		}
+	}
+
+	if code := serveJSON(t, server, "GET", "/api/requests/missing", "", nil); code != http.StatusNotFound {
+		t.Errorf("неизвестный идентификатор: код %d", code)
+	}
+}
+
+func TestCorrelationIDsCachedInBlock(t *testing.T) {
+	store := NewMemoryStore()
+	store.Append(NewLogParser().ParseStream(strings.NewReader(requestsSample)))
+	logs := store.Snapshot().Logs
+
+	entry := &logs[2]
+	if _, cached := entry.raw.correlation(); !cached {
+		t.Fatal("идентификаторы корреляции должны вычисляться при записи")
+	}
+	ids := correlationIDs(entry)
+	if len(ids) != 2 || ids[0] != "r1" || ids[1] != "h1" {
+		t.Errorf("идентификаторы записи %v", ids)
+	}
+	if got := collectCorrelated(logs, "h1"); len(got) != 4 {
+		t.Errorf("связанных записей %d, ожидалось 4", len(got))
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RunInfo - запуск Terraform командой run
+type RunInfo struct {
+	Command     []string
+	Dir         string
+	StartedAt   time.Time
+	FinishedAt  *time.Time
+	WallTime    string // длительность от запуска до завершения процесса
+	ExitCode    *int
+	Status      string // running, succeeded, failed, killed (завершен сигналом), interrupted
+	Interrupted bool   // запуск прерван: Ctrl+C или SIGTERM
+	Signal      string // сигнал, которым завершен процесс
+}
+
+const runIngestInterval = 250 * time.Millisecond
+
+// runStreams - ввод и вывод команды run: Terraform пишет в Stdout и Stderr
+// без изменений, свои сообщения run пишет в Stderr, сообщения веб-сервера о
+// принятых данных - в Server
+type runStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Server io.Writer
+}
+
+// logPipe - канал, в который Terraform пишет логи через TF_LOG_PATH;
+// чтение заканчивается после Close, когда Terraform закроет свой конец
+type logPipe interface {
+	io.Reader
+	Path() string
+	Close() error
+}
+
+// traceEnv - переменные OpenTelemetry, с которыми Terraform отправляет спаны
+// в /v1/traces сервера endpoint; атрибут ресурса session выбирает сессию
+func traceEnv(endpoint, sessionID string) []string {
+	resource := "session=" + sessionID
+	if existing := os.Getenv("OTEL_RESOURCE_ATTRIBUTES"); existing != "" {
+		resource = existing + "," + resource
+	}
+	return []string{
+		"OTEL_TRACES_EXPORTER=otlp",
+		"OTEL_EXPORTER_OTLP_ENDPOINT=" + endpoint,
+		"OTEL_EXPORTER_OTLP_PROTOCOL=http/protobuf",
+		"OTEL_RESOURCE_ATTRIBUTES=" + resource,
+	}
+}
+
+// runTerraform - команда run [-name ...] [-tags ...] [-traces] [-addr ...] [-serve] -- terraform apply ...:
+// Terraform запускается с TF_LOG=json, его логи читаются в новую сессию по мере
+// записи, вывод в терминал не меняется. Возвращает код завершения Terraform;
+// с -serve - после того, как веб-сервер остановят Ctrl+C или SIGTERM.
+func runTerraform(args []string, dataDir string, streams runStreams) (int, error) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(streams.Stderr)
+	name := fs.String("name", "", "название сессии (по умолчанию - команда)")
+	tags := fs.String("tags", "", "теги сессии через запятую")
+	traces := fs.Bool("traces", false, "включить трассировку OTLP Terraform: спаны попадают в ту же сессию")
+	addr := fs.String("addr", ":8080", "адрес веб-сервера на время запуска (порт 0 - любой свободный)")
+	serve := fs.Bool("serve", false, "после завершения Terraform оставить веб-сервер работать до Ctrl+C")
+	if err := fs.Parse(args); err != nil {
+		return 0, err
+	}
+
+	command := fs.Args()
+	if len(command) == 0 {
+		return 0, errors.New("укажите команду: run -- terraform apply")
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return 0, err
+	}
+	commandLine := strings.Join(command, " ")
+	if *name == "" {
+		*name = commandLine
+	}
+
+	// Адрес занимается до запуска Terraform: при ошибке запуска не будет
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return 0, fmt.Errorf("веб-сервер: %v", err)
+	}
+	defer listener.Close()
+	// Вывод Terraform идет в терминал без изменений, сообщения сервера - в streams.Server
+	mux := http.NewServeMux()
+	addRoutes(mux)
+	server := &http.Server{Handler: withServerOutput(mux, streams.Server)}
+	defer server.Close()
+	endpoint := serverURL(listener.Addr())
+
+	tmp, err := os.MkdirTemp("", "tflog-run-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	pipe, err := openLogPipe(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось создать канал для логов: %v", err)
+	}
+
+	session, err := sessions.Create(*name, "run: "+commandLine, splitFilterList([]string{*tags}))
+	if err != nil {
+		pipe.Close()
+		return 0, err
+	}
+	started := time.Now()
+	if session, err = sessions.Update(session.ID, func(s *Session) {
+		s.Run = &RunInfo{Command: command, Dir: dir, StartedAt: started, Status: "running"}
+	}); err != nil {
+		pipe.Close()
+		return 0, err
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = streams.Stdin, streams.Stdout, streams.Stderr
+	cmd.Env = append(os.Environ(), "TF_LOG=json", "TF_LOG_PATH="+pipe.Path())
+	if *traces {
+		cmd.Env = append(cmd.Env, traceEnv(endpoint, session.ID)...)
+	}
+
+	ingested := make(chan struct{})
+	go func() {
+		streamLines(pipe, session.Store, runIngestInterval)
+		close(ingested)
+	}()
+
+	go sessions.runExpiry(time.Minute)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+	fmt.Fprintf(streams.Stderr, "Логи Terraform пишутся в сессию %s: %s/api/sessions/%s/logs\n", session.ID, endpoint, session.ID)
+
+	// Ctrl+C терминал отправляет всей группе процессов, Terraform получает его
+	// сам; SIGTERM приходит только сюда и передается Terraform
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var interruptMu sync.Mutex
+	interrupted := false
+
+	if err := cmd.Start(); err != nil {
+		signal.Stop(signals)
+		pipe.Close()
+		<-ingested
+		sessions.Delete(session.ID)
+		return 0, fmt.Errorf("не удалось запустить %s: %v", command[0], err)
+	}
+	go func() {
+		for sig := range signals {
+			interruptMu.Lock()
+			interrupted = true
+			interruptMu.Unlock()
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	waitErr := cmd.Wait()
+	finished := time.Now()
+	signal.Stop(signals)
+	pipe.Close()
+	<-ingested
+	if err := session.Store.Flush(); err != nil {
+		log.Printf("Не удалось сохранить логи сессии: %v", err)
+	}
+
+	var exitErr *exec.ExitError
+	if waitErr != nil && !errors.As(waitErr, &exitErr) {
+		log.Printf("Ошибка ожидания %s: %v", command[0], waitErr)
+	}
+	interruptMu.Lock()
+	info := runResult(*session.Run, cmd.ProcessState, interrupted, started, finished)
+	interruptMu.Unlock()
+	if session, err = sessions.Update(session.ID, func(s *Session) { s.Run = &info }); err != nil {
+		log.Printf("Не удалось сохранить итог запуска: %v", err)
+	}
+
+	entries := 0
+	if snapshot := session.Store.Snapshot(); snapshot != nil {
+		entries = len(snapshot.Logs)
+	}
+	fmt.Fprintf(streams.Stderr, "%s: %s, код %d за %s; записей в сессии %s: %d\n", command[0], info.Status, *info.ExitCode, info.WallTime, session.ID, entries)
+
+	if *serve {
+		// Ctrl+C здесь останавливает только веб-сервер: run завершается с кодом Terraform
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		fmt.Fprintf(streams.Stderr, "Веб-сервер работает до Ctrl+C: %s\n", endpoint)
+		select {
+		case <-stop:
+		case err := <-serverErr:
+			log.Printf("Веб-сервер: %v", err)
+		}
+	} else if dataDir == "" {
+		// Без --data-dir сессия живет только в памяти этого процесса
+		fmt.Fprintln(streams.Stderr, "Сессия не сохранена: -serve оставляет веб-сервер после завершения, --data-dir сохраняет сессии")
+	}
+	return *info.ExitCode, nil
+}
+
+// serverURL - адрес веб-сервера для ссылок и OTLP; если сервер слушает все
+// интерфейсы - через localhost
+func serverURL(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "http://" + addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// runResult - итог запуска по состоянию завершенного процесса
+func runResult(info RunInfo, state *os.ProcessState, interrupted bool, started, finished time.Time) RunInfo {
+	code := state.ExitCode()
+	if code < 0 {
+		code = signalExitCode(state)
+	}
+	info.ExitCode = &code
+	info.FinishedAt = &finished
+	info.WallTime = finished.Sub(started).Round(time.Millisecond).String()
+	info.Interrupted = interrupted
+
+	switch {
+	case interrupted:
+		info.Status = "interrupted"
+	case !state.Exited():
+		info.Status = "killed"
+	case code == 0:
+		info.Status = "succeeded"
+	default:
+		info.Status = "failed"
+	}
+	if !state.Exited() {
+		info.Signal = strings.TrimPrefix(state.String(), "signal: ")
+	}
+	return info
+}
+
+// streamLines - строки из r в store порциями не реже interval; возвращается,
+// когда поток закончился и последняя порция сохранена
+func streamLines(r io.Reader, store LogStore, interval time.Duration) {
+	var mu sync.Mutex
+	var pending []byte
+	done := make(chan struct{})
+	go func() {
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				mu.Lock()
+				pending = append(pending, line...)
+				mu.Unlock()
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("Ошибка чтения логов: %v", err)
+				}
+				close(done)
+				return
+			}
+		}
+	}()
+
+	lines := 0
+	flush := func() {
+		mu.Lock()
+		data := pending
+		pending = nil
+		mu.Unlock()
+		if len(data) == 0 {
+			return
+		}
+		result := parseLines(data, lines)
+		lines += strings.Count(string(data), "\n")
+		if _, err := store.Append(result); err != nil {
+			log.Printf("Ошибка сохранения логов: %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-done:
+			flush()
+			return
+		}
+	}
+}
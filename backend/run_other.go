@@ -0,0 +1,58 @@
+//go:build !unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// filePipe - без именованных каналов Terraform пишет логи во временный файл,
+// который дочитывается по мере записи до Close
+type filePipe struct {
+	*os.File
+	path   string
+	closed atomic.Bool
+}
+
+func openLogPipe(dir string) (logPipe, error) {
+	path := filepath.Join(dir, "terraform.log")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &filePipe{File: file, path: path}, nil
+}
+
+func (p *filePipe) Read(buf []byte) (int, error) {
+	for {
+		n, err := p.File.Read(buf)
+		if err != io.EOF || n > 0 {
+			return n, err
+		}
+		if p.closed.Load() {
+			// После завершения Terraform файл дочитывается до конца
+			if n, err = p.File.Read(buf); n > 0 {
+				return n, nil
+			}
+			p.File.Close()
+			return 0, io.EOF
+		}
+		time.Sleep(runIngestInterval)
+	}
+}
+
+func (p *filePipe) Path() string { return p.path }
+
+func (p *filePipe) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+// signalExitCode - код завершения процесса, остановленного не по exit
+func signalExitCode(state *os.ProcessState) int {
+	return 1
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRunHelperProcess - не тест: процесс, который run запускает вместо
+// Terraform (см. runHelper). Пишет две записи в TF_LOG_PATH, строку в stdout
+// и завершается с кодом 3.
+func TestRunHelperProcess(t *testing.T) {
+	if os.Getenv("TFLOG_RUN_HELPER") != "1" {
+		return
+	}
+	if marker := os.Getenv("TFLOG_RUN_MARKER"); marker != "" {
+		os.WriteFile(marker, nil, 0o644)
+	}
+	f, err := os.OpenFile(os.Getenv("TF_LOG_PATH"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		os.Exit(2)
+	}
+	fmt.Fprintf(f, `{"@level":"info","@message":"otel %s","@module":"terraform"}`+"\n", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+	fmt.Fprintln(f, `{"@level":"error","@message":"apply failed","@module":"terraform"}`)
+	f.Close()
+	fmt.Println("Apply complete!")
+	os.Exit(3)
+}
+
+// runHelper - аргументы run, запускающие TestRunHelperProcess вместо Terraform
+func runHelper(t *testing.T, args ...string) []string {
+	t.Helper()
+	t.Setenv("TFLOG_RUN_HELPER", "1")
+	return append(args, "--", os.Args[0], "-test.run=^TestRunHelperProcess$")
+}
+
+func TestRunTerraform(t *testing.T) {
+	registry := useTestSessions(t)
+	stdout := os.Stdout
+	var out, errs, server bytes.Buffer
+	streams := runStreams{Stdout: &out, Stderr: &errs, Server: &server}
+
+	// Без --data-dir и -serve run завершается вместе с Terraform
+	code, err := runTerraform(runHelper(t, "-name", "apply", "-traces", "-addr", "127.0.0.1:0"), "", streams)
+	if err != nil || code != 3 {
+		t.Fatalf("код %d, ошибка %v; stderr: %s", code, err, errs.String())
+	}
+	if os.Stdout != stdout || serverOutput != stdout {
+		t.Error("run не должен подменять os.Stdout и вывод сервера")
+	}
+	if !strings.Contains(out.String(), "Apply complete!") {
+		t.Errorf("вывод Terraform не передан: %q", out.String())
+	}
+
+	var session Session
+	for _, s := range registry.List() {
+		if s.Name == "apply" {
+			session = s
+		}
+	}
+	if session.Run == nil || session.Run.Status != "failed" || *session.Run.ExitCode != 3 {
+		t.Fatalf("итог запуска: %+v", session.Run)
+	}
+	logs := session.Store.Snapshot().Logs
+	if len(logs) != 2 {
+		t.Fatalf("записей %d, ожидалось 2", len(logs))
+	}
+
+	// OTLP и ссылка в stderr указывают на адрес, который слушал сервер
+	endpoint := strings.TrimPrefix(logs[0].Message(), "otel ")
+	if !strings.HasPrefix(endpoint, "http://127.0.0.1:") || strings.HasSuffix(endpoint, ":0") {
+		t.Fatalf("OTEL_EXPORTER_OTLP_ENDPOINT: %q", endpoint)
+	}
+	if link := endpoint + "/api/sessions/" + session.ID + "/logs"; !strings.Contains(errs.String(), link) {
+		t.Errorf("в stderr нет ссылки %s: %s", link, errs.String())
+	}
+	if _, err := http.Get(endpoint + "/api/status"); err == nil {
+		t.Error("веб-сервер без -serve должен остановиться вместе с run")
+	}
+}
+
+// syncBuffer - вывод, который пишет run и читает тест
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunTerraformServe(t *testing.T) {
+	useTestSessions(t)
+	errs := &syncBuffer{}
+	server := &syncBuffer{}
+	type result struct {
+		code int
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := runTerraform(runHelper(t, "-serve", "-addr", "127.0.0.1:0"), "", runStreams{Stdout: &bytes.Buffer{}, Stderr: errs, Server: server})
+		done <- result{code, err}
+	}()
+
+	// После завершения Terraform сервер работает и пишет сообщения в streams.Server
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(errs.String(), "до Ctrl+C: ") {
+		if time.Now().After(deadline) {
+			t.Fatalf("сервер не остался работать: %s", errs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	endpoint := strings.TrimSpace(errs.String()[strings.LastIndex(errs.String(), "до Ctrl+C: ")+len("до Ctrl+C: "):])
+	push := `{"streams":[{"stream":{"session":"pushed"},"values":[["1","{\"@message\":\"a\"}"]]}]}`
+	response, err := http.Post(endpoint+"/loki/api/v1/push", "application/json", strings.NewReader(push))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent || !strings.Contains(server.String(), "Loki push: сессия") {
+		t.Errorf("push: код %d, вывод сервера %q", response.StatusCode, server.String())
+	}
+
+	// Ctrl+C останавливает сервер, run возвращает код Terraform
+	process, _ := os.FindProcess(os.Getpid())
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Skipf("сигнал не отправлен: %v", err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil || r.code != 3 {
+			t.Errorf("код %d, ошибка %v", r.code, r.err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run не завершился после Ctrl+C")
+	}
+}
+
+func TestRunTerraformErrors(t *testing.T) {
+	registry := useTestSessions(t)
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	marker := filepath.Join(t.TempDir(), "started")
+	t.Setenv("TFLOG_RUN_MARKER", marker)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"адрес занят", runHelper(t, "-addr", busy.Addr().String()), "веб-сервер"},
+		{"нет команды", []string{"-name", "x"}, "укажите команду"},
+		{"неизвестный флаг", []string{"-bogus"}, "bogus"},
+	}
+	for _, tt := range tests {
+		var errs bytes.Buffer
+		_, err := runTerraform(tt.args, t.TempDir(), runStreams{Stdout: &bytes.Buffer{}, Stderr: &errs, Server: &bytes.Buffer{}})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ошибка %v", tt.name, err)
+		}
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Terraform запущен, хотя адрес сервера занят")
+	}
+	if count := len(registry.List()); count != 1 {
+		t.Errorf("сессий %d: при ошибке сессия не должна создаваться", count)
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 8080}, "http://localhost:8080"},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 9000}, "http://localhost:9000"},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 41000}, "http://127.0.0.1:41000"},
+		{&net.TCPAddr{IP: net.IPv6loopback, Port: 80}, "http://[::1]:80"},
+	}
+	for _, tt := range tests {
+		if got := serverURL(tt.addr); got != tt.want {
+			t.Errorf("serverURL(%s) = %s, ожидалось %s", tt.addr, got, tt.want)
+		}
+	}
+}
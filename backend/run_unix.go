@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fifoPipe - именованный канал. Он открывается на чтение без ожидания писателя
+// и удерживается собственным писателем, чтобы чтение не закончилось раньше,
+// чем Terraform откроет канал; после Close поток заканчивается, когда Terraform
+// закроет свой конец.
+type fifoPipe struct {
+	*os.File
+	path   string
+	writer *os.File
+}
+
+func openLogPipe(dir string) (logPipe, error) {
+	path := filepath.Join(dir, "terraform.log")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		return nil, err
+	}
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &fifoPipe{File: reader, path: path, writer: writer}, nil
+}
+
+func (p *fifoPipe) Path() string { return p.path }
+
+func (p *fifoPipe) Close() error { return p.writer.Close() }
+
+// signalExitCode - код завершения процесса, убитого сигналом, как в shell: 128+номер
+func signalExitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return 1
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Сессия по умолчанию: с ней работают маршруты без /api/sessions/{session},
+// веб-форма и логи, переданные в командной строке
+const defaultSessionID = "default"
+
+// Session - именованный набор логов со своим хранилищем
+type Session struct {
+	ID          string
+	Name        string
+	CreatedAt   time.Time
+	Source      string // откуда получены логи: файл, stdin, API
+	Tags        []string
+	ExpiresAt   *time.Time       // сессия удаляется после этого времени; nil - бессрочно
+	Run         *RunInfo         // запуск Terraform командой run; nil для остальных сессий
+	Store       LogStore         `json:"-"`
+	Annotations *AnnotationStore `json:"-"`
+	Traces      *TraceStore      `json:"-"`
+}
+
+// SessionSummary - сессия с размером данных для списка сессий
+type SessionSummary struct {
+	Session
+	LogsCount   int
+	ErrorsCount int
+	Bytes       int64
+}
+
+// SessionBackend - способ хранения сессий: в памяти или на диске
+type SessionBackend interface {
+	// Open возвращает хранилище данных сессии, загружая сохраненные данные
+	Open(id string) (LogStore, error)
+	// SaveSession сохраняет описание сессии
+	SaveSession(session Session) error
+	// LoadSessions возвращает сессии, сохраненные при прошлых запусках
+	LoadSessions() ([]Session, error)
+	// DeleteSession удаляет сессию вместе с данными
+	DeleteSession(id string) error
+	// LoadAnnotations возвращает сохраненные пометки записей сессии
+	LoadAnnotations(id string) ([]Annotation, error)
+	// SaveAnnotations сохраняет все пометки записей сессии
+	SaveAnnotations(id string, annotations []Annotation) error
+	// LoadTraces возвращает сохраненные спаны трассировки сессии
+	LoadTraces(id string) ([]TraceSpan, error)
+	// SaveTraces сохраняет все спаны трассировки сессии
+	SaveTraces(id string, spans []TraceSpan) error
+}
+
+// memoryBackend - сессии живут только до перезапуска
+type memoryBackend struct{}
+
+func (memoryBackend) Open(id string) (LogStore, error)  { return NewMemoryStore(), nil }
+func (memoryBackend) SaveSession(session Session) error { return nil }
+func (memoryBackend) LoadSessions() ([]Session, error)  { return nil, nil }
+func (memoryBackend) DeleteSession(id string) error     { return nil }
+
+func (memoryBackend) LoadAnnotations(id string) ([]Annotation, error)           { return nil, nil }
+func (memoryBackend) SaveAnnotations(id string, annotations []Annotation) error { return nil }
+func (memoryBackend) LoadTraces(id string) ([]TraceSpan, error)                 { return nil, nil }
+func (memoryBackend) SaveTraces(id string, spans []TraceSpan) error             { return nil }
+
+var errSessionNotFound = errors.New("сессия не найдена")
+
+// SessionRegistry - потокобезопасный реестр сессий
+type SessionRegistry struct {
+	mu       sync.RWMutex
+	evictMu  sync.Mutex // вытеснение по общему объему, см. enforceLimits
+	backend  SessionBackend
+	limits   storageLimits
+	sessions map[string]*Session
+}
+
+// Глобальный реестр, с которым работают обработчики; создается в initSessions
+var sessions *SessionRegistry
+
+// initSessions - реестр в памяти или, если задан каталог данных, на диске
+func initSessions(dataDir string, limits storageLimits) error {
+	var backend SessionBackend = memoryBackend{}
+	if dataDir != "" {
+		disk, err := NewDiskBackend(dataDir)
+		if err != nil {
+			return err
+		}
+		backend = disk
+	}
+
+	registry, err := NewSessionRegistry(backend, limits)
+	if err != nil {
+		return err
+	}
+	sessions = registry
+	return nil
+}
+
+// NewSessionRegistry - реестр с сессиями, сохраненными в backend;
+// сессия по умолчанию создается, если ее еще нет
+func NewSessionRegistry(backend SessionBackend, limits storageLimits) (*SessionRegistry, error) {
+	registry := &SessionRegistry{backend: backend, limits: limits, sessions: make(map[string]*Session)}
+
+	loaded, err := backend.LoadSessions()
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить сессии: %v", err)
+	}
+	for i := range loaded {
+		session := &loaded[i]
+		if err := registry.attach(session, session.Store); err != nil {
+			return nil, fmt.Errorf("сессия %s: %v", session.ID, err)
+		}
+		registry.sessions[session.ID] = session
+	}
+	// Сессии, устаревшие, пока сервер был остановлен
+	registry.expireSessions(time.Now())
+
+	if _, exists := registry.sessions[defaultSessionID]; !exists {
+		if _, err := registry.create(defaultSessionID, "По умолчанию", "", nil); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// Create - новая пустая сессия
+func (r *SessionRegistry) Create(name, source string, tags []string) (Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.create(r.unusedID(), name, source, tags)
+}
+
+// unusedID - случайный ID, которого еще нет в реестре; вызывается под блокировкой
+func (r *SessionRegistry) unusedID() string {
+	id := newSessionID()
+	for r.sessions[id] != nil {
+		id = newSessionID()
+	}
+	return id
+}
+
+func (r *SessionRegistry) create(id, name, source string, tags []string) (Session, error) {
+	store, err := r.backend.Open(id)
+	if err != nil {
+		return Session{}, err
+	}
+	session := &Session{
+		ID:        id,
+		Name:      name,
+		CreatedAt: time.Now(),
+		Source:    source,
+		Tags:      tags,
+	}
+	if err := r.attach(session, store); err != nil {
+		return Session{}, err
+	}
+	if r.limits.SessionTTL > 0 && id != defaultSessionID {
+		expires := session.CreatedAt.Add(r.limits.SessionTTL)
+		session.ExpiresAt = &expires
+	}
+	if err := r.backend.SaveSession(*session); err != nil {
+		return Session{}, err
+	}
+	r.sessions[id] = session
+	return *session, nil
+}
+
+// attach - хранилище сессии с ограничениями реестра, ее пометки и спаны
+func (r *SessionRegistry) attach(session *Session, store LogStore) error {
+	annotations, err := r.backend.LoadAnnotations(session.ID)
+	if err != nil {
+		return err
+	}
+	spans, err := r.backend.LoadTraces(session.ID)
+	if err != nil {
+		return err
+	}
+	id, backend := session.ID, r.backend
+	session.Annotations = newAnnotationStore(annotations, func(list []Annotation) error {
+		return backend.SaveAnnotations(id, list)
+	})
+	session.Traces = newTraceStore(spans, func(list []TraceSpan) error {
+		return backend.SaveTraces(id, list)
+	})
+	session.Store = &annotatedStore{
+		LogStore:    &limitedStore{LogStore: store, registry: r, id: id},
+		annotations: session.Annotations,
+		traces:      session.Traces,
+	}
+	return nil
+}
+
+// Get - копия описания сессии; хранилище и пометки общие
+func (r *SessionRegistry) Get(id string) (Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return Session{}, false
+	}
+	return *session, true
+}
+
+// List - все сессии в порядке создания
+func (r *SessionRegistry) List() []Session {
+	r.mu.RLock()
+	list := make([]Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		list = append(list, *session)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Flush - запись на диск отложенных изменений всех сессий, см. LogStore.Flush
+func (r *SessionRegistry) Flush() error {
+	var errs []error
+	for _, session := range r.List() {
+		if err := session.Store.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("сессия %s: %w", session.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Update - изменение описания сессии; в реестре оно меняется только
+// после успешного сохранения
+func (r *SessionRegistry) Update(id string, apply func(*Session)) (Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return Session{}, errSessionNotFound
+	}
+	updated := *session
+	apply(&updated)
+	if err := r.backend.SaveSession(updated); err != nil {
+		return Session{}, err
+	}
+	*session = updated
+	return updated, nil
+}
+
+// Delete - удаление сессии вместе с ее логами
+func (r *SessionRegistry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.sessions[id]; !exists {
+		return errSessionNotFound
+	}
+	return r.deleteLocked(id)
+}
+
+func (r *SessionRegistry) deleteLocked(id string) error {
+	if err := r.backend.DeleteSession(id); err != nil {
+		return err
+	}
+	delete(r.sessions, id)
+	return nil
+}
+
+func newSessionID() string {
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// summarize - описание сессии с текущим размером данных
+func summarize(session Session) SessionSummary {
+	summary := SessionSummary{Session: session}
+	if snapshot := session.Store.Snapshot(); snapshot != nil {
+		summary.LogsCount = len(snapshot.Logs)
+		summary.ErrorsCount = len(snapshot.Errors)
+	}
+	summary.Bytes = session.Store.Usage().Bytes
+	return summary
+}
+
+// defaultStore - хранилище сессии по умолчанию
+func defaultStore() LogStore {
+	session, _ := sessions.Get(defaultSessionID)
+	return session.Store
+}
+
+// requestSession - сессия из пути запроса (/api/sessions/{session}/...);
+// маршруты без сессии работают с сессией по умолчанию. Если сессии нет, ответ уже записан.
+func requestSession(w http.ResponseWriter, r *http.Request) (Session, bool) {
+	id := r.PathValue("session")
+	if id == "" {
+		id = defaultSessionID
+	}
+	session, exists := sessions.Get(id)
+	if !exists {
+		http.Error(w, `{"error": "Сессия не найдена"}`, http.StatusNotFound)
+		return Session{}, false
+	}
+	return session, true
+}
+
+// requestStore - хранилище сессии из пути запроса, см. requestSession
+func requestStore(w http.ResponseWriter, r *http.Request) (LogStore, bool) {
+	session, ok := requestSession(w, r)
+	return session.Store, ok
+}
+
+// Обработчик API для списка сессий (GET) и создания сессии (POST).
+// POST /api/sessions?name=&source=&tags=a,b&ttl=7d - тело запроса, если есть,
+// разбирается как в POST /api/logs и загружается в новую сессию.
+func handleAPISessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		list := sessions.List()
+		summaries := make([]SessionSummary, len(list))
+		for i, session := range list {
+			summaries[i] = summarize(session)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "success",
+			"sessions": summaries,
+			"count":    len(summaries),
+		})
+
+	case "POST":
+		result, filename, ok := readUploadedLogs(w, r)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		name := query.Get("name")
+		if name == "" {
+			name = filename
+		}
+		source := query.Get("source")
+		if source == "" {
+			source = "api"
+			if filename != "" {
+				source = "upload: " + filename
+			}
+		}
+
+		var ttl *time.Duration
+		if value := query.Get("ttl"); value != "" {
+			parsed, err := parseDurationWithDays(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": %q}`, "Неверное время жизни: "+value), http.StatusBadRequest)
+				return
+			}
+			ttl = &parsed
+		}
+
+		session, err := sessions.Create(name, source, splitFilterList(query["tags"]))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if ttl != nil {
+			if session, err = sessions.Update(session.ID, expireAfter(*ttl)); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+		if result.Stats.TotalLines > 0 {
+			if _, err := session.Store.Append(result); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"session": summarize(session),
+			"added":   len(result.Logs),
+			"errors":  len(result.Errors),
+		})
+
+	default:
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// Обработчик API для одной сессии: GET - описание, PATCH - переименование,
+// теги и время жизни ({"name": "...", "tags": [...], "ttl": "7d"}), DELETE - удаление вместе с логами
+func handleAPISession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := r.PathValue("session")
+
+	switch r.Method {
+	case "GET":
+		session, exists := sessions.Get(id)
+		if !exists {
+			http.Error(w, `{"error": "Сессия не найдена"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"session": summarize(session),
+		})
+
+	case "PATCH":
+		var update struct {
+			Name *string   `json:"name"`
+			Tags *[]string `json:"tags"`
+			TTL  *string   `json:"ttl"` // от текущего момента; "" или "0" - бессрочно
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "Неверное тело запроса: "+err.Error()), http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if update.TTL != nil && *update.TTL != "" && *update.TTL != "0" {
+			parsed, err := parseDurationWithDays(*update.TTL)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": %q}`, "Неверное время жизни: "+*update.TTL), http.StatusBadRequest)
+				return
+			}
+			ttl = parsed
+		}
+		session, err := sessions.Update(id, func(session *Session) {
+			if update.Name != nil {
+				session.Name = *update.Name
+			}
+			if update.Tags != nil {
+				session.Tags = *update.Tags
+			}
+			if update.TTL != nil {
+				expireAfter(ttl)(session)
+			}
+		})
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"session": summarize(session),
+		})
+
+	case "DELETE":
+		if id == defaultSessionID {
+			http.Error(w, `{"error": "Сессию по умолчанию нельзя удалить, используйте /api/clear"}`, http.StatusBadRequest)
+			return
+		}
+		if err := sessions.Delete(id); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Сессия удалена",
+		})
+
+	default:
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// loadDefaultSession - замена логов сессии по умолчанию (веб-форма, командная строка)
+func loadDefaultSession(result ParseResult, source string) error {
+	if err := defaultStore().Replace(result); err != nil {
+		return err
+	}
+	_, err := sessions.Update(defaultSessionID, func(session *Session) {
+		session.Source = source
+	})
+	return err
+}
+
+// expireAfter - время жизни сессии от текущего момента (0 - бессрочно);
+// у сессии по умолчанию времени жизни нет
+func expireAfter(ttl time.Duration) func(*Session) {
+	return func(session *Session) {
+		if ttl <= 0 || session.ID == defaultSessionID {
+			session.ExpiresAt = nil
+			return
+		}
+		expires := time.Now().Add(ttl)
+		session.ExpiresAt = &expires
+	}
+}
+
+// writeStoreError - ошибка хранилища: 404 для отсутствующей сессии, иначе 500
+func writeStoreError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errSessionNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), status)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testServer - маршруты API поверх реестра в памяти, см. useTestSessions
+func testServer(t *testing.T) (*SessionRegistry, http.Handler) {
+	t.Helper()
+	registry := useTestSessions(t)
+	mux := http.NewServeMux()
+	addRoutes(mux)
+	return registry, mux
+}
+
+// serveJSON - запрос к обработчику; JSON ответа разбирается в out (nil - не разбирается)
+func serveJSON(t *testing.T, handler http.Handler, method, target, body string, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, target, reader))
+	if out != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: ответ %d не JSON: %s", method, target, recorder.Code, recorder.Body)
+		}
+	}
+	return recorder.Code
+}
+
+// sampleLines - строки parseSample для тела запроса
+func sampleLines(t *testing.T, lines int) string {
+	t.Helper()
+	var b strings.Builder
+	for _, entry := range parseSample(t, lines).Logs {
+		b.WriteString(rawJSON(&entry) + "\n")
+	}
+	return b.String()
+}
+
+func TestSessionsAPI(t *testing.T) {
+	_, server := testServer(t)
+
+	var created struct {
+		Session SessionSummary `json:"session"`
+		Added   int            `json:"added"`
+	}
+	if code := serveJSON(t, server, "POST", "/api/sessions?name=plan&tags=ci,prod&ttl=7d", sampleLines(t, 3), &created); code != http.StatusCreated {
+		t.Fatalf("создание сессии: код %d", code)
+	}
+	id := created.Session.ID
+	if created.Added != 3 || created.Session.Name != "plan" || len(created.Session.Tags) != 2 || created.Session.ExpiresAt == nil {
+		t.Fatalf("созданная сессия: %+v", created)
+	}
+
+	var list struct {
+		Count int `json:"count"`
+	}
+	serveJSON(t, server, "GET", "/api/sessions", "", &list)
+	if list.Count != 2 {
+		t.Errorf("сессий %d, ожидалось 2 (с сессией по умолчанию)", list.Count)
+	}
+
+	// Данные сессии не видны через маршруты сессии по умолчанию
+	var logs struct {
+		Count int `json:"count"`
+	}
+	serveJSON(t, server, "GET", "/api/sessions/"+id+"/logs", "", &logs)
+	if logs.Count != 3 {
+		t.Errorf("в сессии %d записей, ожидалось 3", logs.Count)
+	}
+	var empty struct {
+		Status string `json:"status"`
+	}
+	serveJSON(t, server, "GET", "/api/logs", "", &empty)
+	if empty.Status != "no_data" {
+		t.Errorf("сессия по умолчанию должна быть пустой: %+v", empty)
+	}
+
+	var renamed struct {
+		Session SessionSummary `json:"session"`
+	}
+	serveJSON(t, server, "PATCH", "/api/sessions/"+id, `{"name": "apply", "ttl": "0"}`, &renamed)
+	if renamed.Session.Name != "apply" || renamed.Session.ExpiresAt != nil || renamed.Session.LogsCount != 3 {
+		t.Errorf("сессия после PATCH: %+v", renamed.Session)
+	}
+
+	tests := []struct {
+		method, target, body string
+		code                 int
+	}{
+		{"POST", "/api/sessions?ttl=вчера", "", http.StatusBadRequest},
+		{"PATCH", "/api/sessions/" + id, `{"ttl": "неделя"}`, http.StatusBadRequest},
+		{"PATCH", "/api/sessions/" + id, `{`, http.StatusBadRequest},
+		{"DELETE", "/api/sessions/" + defaultSessionID, "", http.StatusBadRequest},
+		{"PUT", "/api/sessions", "", http.StatusMethodNotAllowed},
+		{"GET", "/api/sessions/missing", "", http.StatusNotFound},
+		{"GET", "/api/sessions/missing/logs", "", http.StatusNotFound},
+		{"DELETE", "/api/sessions/" + id, "", http.StatusOK},
+		{"GET", "/api/sessions/" + id, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if code := serveJSON(t, server, tt.method, tt.target, tt.body, nil); code != tt.code {
+			t.Errorf("%s %s: код %d, ожидался %d", tt.method, tt.target, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errSnappyCorrupt = errors.New("snappy: поврежденные данные")
+
+// snappyDecode - распаковка блока snappy (без фреймов потокового формата),
+// в котором Promtail и другие сборщики отправляют protobuf; maxLen ограничивает
+// объявленный размер распакованных данных
+func snappyDecode(src []byte, maxLen int) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errSnappyCorrupt
+	}
+	if length > uint64(maxLen) {
+		return nil, fmt.Errorf("snappy: распакованные данные больше %d байт", maxLen)
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var size, offset int
+		switch tag & 3 {
+		case 0: // литерал: длина в теге или в 1-4 следующих байтах
+			size = int(tag >> 2)
+			src = src[1:]
+			if size >= 60 {
+				extra := size - 59
+				if len(src) < extra {
+					return nil, errSnappyCorrupt
+				}
+				size = 0
+				for i := extra - 1; i >= 0; i-- {
+					size = size<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			size++
+			if size <= 0 || size > len(src) || len(dst)+size > int(length) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+
+		case 1: // копия с 11-битным смещением
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			size = 4 + int(tag>>2&7)
+			offset = int(tag&0xE0)<<3 | int(src[1])
+			src = src[2:]
+
+		case 2: // копия с 16-битным смещением
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+
+		case 3: // копия с 32-битным смещением
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+
+		if offset <= 0 || offset > len(dst) || len(dst)+size > int(length) {
+			return nil, errSnappyCorrupt
+		}
+		// Копия может перекрываться с собственным результатом: побайтово
+		start := len(dst) - offset
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if len(dst) != int(length) {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// snappyLiterals - блок snappy из одних литералов: корректен для любого декодера
+func snappyLiterals(data []byte) []byte {
+	block := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := min(len(data), 1<<16)
+		block = append(block, 61<<2, byte(n-1), byte((n-1)>>8))
+		block = append(block, data[:n]...)
+		data = data[n:]
+	}
+	return block
+}
+
+func TestSnappyDecode(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 100)
+	tests := []struct {
+		name   string
+		input  []byte
+		maxLen int
+		want   string
+		err    string
+	}{
+		{"литерал и перекрывающаяся копия", []byte{12, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x04}, 0, "abcdabcdabcd", ""},
+		{"копия с 16-битным смещением", []byte{8, 0x0c, 'a', 'b', 'c', 'd', 0x0e, 0x04, 0x00}, 0, "abcdabcd", ""},
+		{"длинный литерал", append([]byte{100, 60 << 2, 99}, long...), 0, string(long), ""},
+		{"литералы по 64 КБ", snappyLiterals(bytes.Repeat([]byte("y"), 70000)), 0, strings.Repeat("y", 70000), ""},
+		{"пустой вход", nil, 0, "", "поврежденные данные"},
+		{"пустые данные", []byte{0}, 0, "", ""},
+		{"копия за пределами данных", []byte{12, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x05}, 0, "", "поврежденные данные"},
+		{"копия с нулевым смещением", []byte{8, 0x0c, 'a', 'b', 'c', 'd', 0x01, 0x00}, 0, "", "поврежденные данные"},
+		{"литерал длиннее данных", []byte{4, 0x0c, 'a', 'b'}, 0, "", "поврежденные данные"},
+		{"литерал длиннее объявленного", []byte{2, 0x0c, 'a', 'b', 'c', 'd'}, 0, "", "поврежденные данные"},
+		{"данных меньше объявленного", []byte{12, 0x0c, 'a', 'b', 'c', 'd'}, 0, "", "поврежденные данные"},
+		{"обрезанная длина литерала", []byte{10, 61 << 2, 5}, 0, "", "поврежденные данные"},
+		{"обрезанная копия", []byte{8, 0x0c, 'a', 'b', 'c', 'd', 0x0e, 0x04}, 0, "", "поврежденные данные"},
+		{"обрезанная 32-битная копия", []byte{8, 0x0c, 'a', 'b', 'c', 'd', 0x0f, 0x04, 0, 0}, 0, "", "поврежденные данные"},
+		{"некорректная длина", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, 0, "", "поврежденные данные"},
+		{"больше ограничения", []byte{0xe9, 0x07}, 1000, "", "больше 1000 байт"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxLen := test.maxLen
+			if maxLen == 0 {
+				maxLen = maxPushBytes
+			}
+			data, err := snappyDecode(test.input, maxLen)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("ошибка %v, ожидалось %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || string(data) != test.want {
+				t.Fatalf("результат: %d байт, ожидалось %d: %v", len(data), len(test.want), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import "sync"
+
+// LogStore - хранилище разобранных логов, общее для всех обработчиков
+type LogStore interface {
+	// Append атомарно добавляет результат разбора, назначая записям ID
+	// после уже сохраненных; возвращает общее число записей
+	Append(result ParseResult) (int, error)
+	// Replace заменяет все содержимое новым результатом
+	Replace(result ParseResult) error
+	// Restore заменяет содержимое, как Replace, но ID записей отсчитываются
+	// от firstID: так восстанавливается сессия, у которой часть записей вытеснена
+	Restore(result ParseResult, firstID int) error
+	// Snapshot возвращает неизменяемый снимок или nil, если данных нет
+	Snapshot() *ParseResult
+	// Clear удаляет все данные
+	Clear() error
+	// Trim вытесняет самые старые записи, пока число записей и их объем
+	// превышают ограничения (0 - без ограничения); возвращает число вытесненных
+	Trim(maxEntries int, maxBytes int64) (int, error)
+	// Usage возвращает текущий объем данных
+	Usage() StoreUsage
+	// Watch возвращает канал, который закроется при следующем изменении данных
+	Watch() <-chan struct{}
+	// Flush сохраняет изменения, отложенные хранилищем для записи пакетом
+	Flush() error
+}
+
+// StoreUsage - объем данных хранилища
+type StoreUsage struct {
+	Entries int
+	Bytes   int64 // приблизительный объем записей в памяти
+	Evicted int   // записей вытеснено ограничениями с момента загрузки
+	// Generation увеличивается, когда содержимое заменяется целиком (Replace,
+	// Restore, Clear): ID записей после этого могут повторять прежние
+	Generation int
+}
+
+// memoryStore - потокобезопасное хранилище в памяти
+type memoryStore struct {
+	mu         sync.RWMutex
+	result     *ParseResult
+	nextID     int // ID следующей записи: после вытеснения не равен числу записей
+	bytes      int64
+	evicted    int
+	generation int
+	dead       int // вытесненные записи перед result.Logs в том же массиве, см. evict
+	open       int // записи в конце result.Logs, лежащие в открытом блоке, см. compactLogs
+	names      *interner
+	changed    chan struct{} // закрывается при изменении, см. Watch
+}
+
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{names: newInterner()}
+}
+
+func (s *memoryStore) Append(result ParseResult) (int, error) {
+	return s.appendIndexed(result, result.index)
+}
+
+// appendIndexed - Append с уже вычисленными производными данными записей
+// (nil - вычислить), см. entryIndex
+func (s *memoryStore) appendIndexed(result ParseResult, index *entryIndex) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.result == nil {
+		s.result = &ParseResult{Stats: newParseStats()}
+	}
+
+	s.add(result.Logs, s.nextID, index)
+	s.result.Errors = append(s.result.Errors, result.Errors...)
+	mergeStats(&s.result.Stats, result.Stats)
+
+	return len(s.result.Logs), nil
+}
+
+func (s *memoryStore) Replace(result ParseResult) error {
+	return s.Restore(result, 0)
+}
+
+func (s *memoryStore) Restore(result ParseResult, firstID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := ParseResult{
+		Errors: result.Errors,
+		Stats:  newParseStats(),
+	}
+	mergeStats(&stored.Stats, result.Stats)
+	s.result = &stored
+	s.bytes = 0
+	s.dead = 0
+	s.open = 0
+	s.names = newInterner()
+	s.generation++
+	s.add(result.Logs, firstID, result.index)
+	return nil
+}
+
+// add - копии записей с ID, сдвинутыми на offset, в компактном виде;
+// вызывается под блокировкой
+func (s *memoryStore) add(logs []TerraformLog, offset int, index *entryIndex) {
+	start, capacity := len(s.result.Logs), cap(s.result.Logs)
+	for _, log := range logs {
+		log.ID += offset
+		s.result.Logs = append(s.result.Logs, log)
+	}
+	if cap(s.result.Logs) != capacity {
+		// append перенес записи в новый массив без вытесненных
+		s.dead = 0
+	}
+
+	// Новые записи лежат за пределами выданных снимков, их можно менять на месте.
+	// Записи открытого блока не меняются, но их доля блока растет: их объем
+	// пересчитывается.
+	tail := s.result.Logs[start-s.open:]
+	for i := range tail[:s.open] {
+		s.bytes -= logSize(&tail[i])
+	}
+	s.open = compactLogs(tail, s.open, s.names, index)
+	for i := range tail {
+		s.bytes += logSize(&tail[i])
+	}
+	s.nextID = offset + len(logs)
+	s.notify()
+}
+
+// indexTail - производные данные последних n записей
+func (s *memoryStore) indexTail(n int) *entryIndex {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return newEntryIndex(s.result.Logs[len(s.result.Logs)-n:])
+}
+
+func (s *memoryStore) Snapshot() *ParseResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.result == nil {
+		return nil
+	}
+
+	// Срезы ограничены по емкости: последующие Append пишут только за их
+	// пределами или в новый массив, поэтому снимок не меняется. Карты
+	// статистики изменяются при Append и копируются.
+	snapshot := ParseResult{
+		Logs:   s.result.Logs[:len(s.result.Logs):len(s.result.Logs)],
+		Errors: s.result.Errors[:len(s.result.Errors):len(s.result.Errors)],
+		Stats:  newParseStats(),
+	}
+	mergeStats(&snapshot.Stats, s.result.Stats)
+	return &snapshot
+}
+
+func (s *memoryStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.result = nil
+	s.nextID = 0
+	s.bytes = 0
+	s.dead = 0
+	s.open = 0
+	s.names = newInterner()
+	s.generation++
+	s.notify()
+	return nil
+}
+
+func (s *memoryStore) Trim(maxEntries int, maxBytes int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.evictable(maxEntries, maxBytes)
+	s.evict(n)
+	return n, nil
+}
+
+func (s *memoryStore) Usage() StoreUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := StoreUsage{Bytes: s.bytes, Evicted: s.evicted, Generation: s.generation}
+	if s.result != nil {
+		usage.Entries = len(s.result.Logs)
+	}
+	return usage
+}
+
+// evictable - сколько самых старых записей нужно вытеснить, чтобы уложиться
+// в ограничения; вызывается под блокировкой
+func (s *memoryStore) evictable(maxEntries int, maxBytes int64) int {
+	if s.result == nil {
+		return 0
+	}
+	n := 0
+	if maxEntries > 0 && len(s.result.Logs) > maxEntries {
+		n = len(s.result.Logs) - maxEntries
+	}
+	if maxBytes > 0 {
+		bytes := s.bytes
+		for i := 0; i < n; i++ {
+			bytes -= logSize(&s.result.Logs[i])
+		}
+		for ; bytes > maxBytes && n < len(s.result.Logs); n++ {
+			bytes -= logSize(&s.result.Logs[n])
+		}
+	}
+	return n
+}
+
+// evict - удаление n самых старых записей с поправкой статистики;
+// вызывается под блокировкой
+func (s *memoryStore) evict(n int) {
+	if n <= 0 || s.result == nil {
+		return
+	}
+
+	// Статистика копируется: ее карты могут быть у выданных снимков
+	stats := newParseStats()
+	mergeStats(&stats, s.result.Stats)
+	for i := 0; i < n; i++ {
+		log := &s.result.Logs[i]
+		s.bytes -= logSize(log)
+		stats.TotalLines--
+		stats.SuccessLines--
+		stats.ByLevel[log.Level()]--
+		if stats.ByLevel[log.Level()] <= 0 {
+			delete(stats.ByLevel, log.Level())
+		}
+		if log.Module() != "" {
+			stats.ByModule[log.Module()]--
+			if stats.ByModule[log.Module()] <= 0 {
+				delete(stats.ByModule, log.Module())
+			}
+		}
+	}
+
+	// Записи отрезаются без копирования, массив копируется, только когда
+	// вытесненных в нем больше, чем оставшихся: при вытеснении на каждой записи
+	// копирование амортизируется, а память занята не более чем вдвое. Снимки
+	// держат старый массив и не меняются.
+	logs := s.result.Logs[n:]
+	s.dead += n
+	s.open = min(s.open, len(logs))
+	if s.dead > len(logs) {
+		logs = append([]TerraformLog(nil), logs...)
+		s.dead = 0
+	}
+	s.result = &ParseResult{
+		Logs:   logs,
+		Errors: s.result.Errors,
+		Stats:  stats,
+	}
+	s.evicted += n
+	s.notify()
+}
+
+func (s *memoryStore) Watch() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.changed
+}
+
+// Flush - хранилищу в памяти нечего сохранять
+func (s *memoryStore) Flush() error {
+	return nil
+}
+
+// notify - пробуждение ожидающих Watch; вызывается под блокировкой
+func (s *memoryStore) notify() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// logSize - приблизительный объем записи в памяти: строка записи и доля
+// колонок блока (вместе с сообщениями и tf_req_id). Для еще не
+// перенесенной в блок записи - все ее строки, исходная строка и разобранные атрибуты.
+func logSize(log *TerraformLog) int64 {
+	const overhead = 128
+	size := overhead + rawSize(log)
+	if log.raw.block == nil {
+		size += len(log.Message()) + len(log.TfReqID()) + len(rawJSON(log))
+		for field := nameField(0); field < nameFields; field++ {
+			size += len(log.name(field))
+		}
+	}
+	return int64(size)
+}
+
+func newParseStats() ParseStats {
+	return ParseStats{
+		ByLevel:  make(map[string]int),
+		ByModule: make(map[string]int),
+	}
+}
+
+// mergeStats - добавление статистики src к dst
+func mergeStats(dst *ParseStats, src ParseStats) {
+	dst.TotalLines += src.TotalLines
+	dst.SuccessLines += src.SuccessLines
+	dst.ErrorLines += src.ErrorLines
+	dst.HasHTTPRequests = dst.HasHTTPRequests || src.HasHTTPRequests
+
+	for level, count := range src.ByLevel {
+		dst.ByLevel[level] += count
+	}
+	for module, count := range src.ByModule {
+		dst.ByModule[module] += count
+	}
+}
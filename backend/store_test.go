@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func parseSample(t *testing.T, lines int) ParseResult {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, `{"@level":"info","@message":"line %d","@module":"terraform"}`+"\n", i)
+	}
+	return NewLogParser().ParseStream(strings.NewReader(b.String()))
+}
+
+func TestMemoryStoreAppendAssignsSequentialIDs(t *testing.T) {
+	store := NewMemoryStore()
+	if store.Snapshot() != nil {
+		t.Fatal("пустое хранилище должно возвращать nil")
+	}
+
+	if total, err := store.Append(parseSample(t, 3)); err != nil || total != 3 {
+		t.Fatalf("total = %d (%v), ожидалось 3", total, err)
+	}
+	if total, err := store.Append(parseSample(t, 2)); err != nil || total != 5 {
+		t.Fatalf("total = %d (%v), ожидалось 5", total, err)
+	}
+
+	snapshot := store.Snapshot()
+	for i, log := range snapshot.Logs {
+		if log.ID != i {
+			t.Errorf("запись %d имеет ID %d", i, log.ID)
+		}
+	}
+	if snapshot.Stats.SuccessLines != 5 || snapshot.Stats.ByLevel["info"] != 5 {
+		t.Errorf("статистика не объединена: %+v", snapshot.Stats)
+	}
+
+	store.Clear()
+	if store.Snapshot() != nil {
+		t.Fatal("после Clear хранилище должно быть пустым")
+	}
+}
+
+func TestMemoryStoreSnapshotIsStable(t *testing.T) {
+	store := NewMemoryStore()
+	store.Replace(parseSample(t, 2))
+
+	snapshot := store.Snapshot()
+	store.Append(parseSample(t, 4))
+
+	if len(snapshot.Logs) != 2 || snapshot.Stats.SuccessLines != 2 || snapshot.Stats.ByLevel["info"] != 2 {
+		t.Errorf("снимок изменился после Append: %d записей, %+v", len(snapshot.Logs), snapshot.Stats)
+	}
+
+	// Дописывание в снимок не должно затрагивать хранилище
+	_ = append(snapshot.Logs, TerraformLog{ID: 100})
+	if got := store.Snapshot().Logs[2].ID; got != 2 {
+		t.Errorf("запись хранилища перезаписана через снимок: ID %d", got)
+	}
+}
+
+func TestMemoryStoreConcurrentAccess(t *testing.T) {
+	store := NewMemoryStore()
+	batch := parseSample(t, 10)
+
+	const writers, readers, rounds = 4, 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				// Каждая горутина передает свою копию записей
+				result := batch
+				result.Logs = append([]TerraformLog(nil), batch.Logs...)
+				store.Append(result)
+			}
+		}()
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				snapshot := store.Snapshot()
+				if snapshot == nil {
+					continue
+				}
+				for j, log := range snapshot.Logs {
+					if log.ID != j {
+						t.Errorf("несогласованный снимок: запись %d имеет ID %d", j, log.ID)
+						return
+					}
+				}
+				if snapshot.Stats.SuccessLines != len(snapshot.Logs) {
+					t.Errorf("статистика (%d) не соответствует записям (%d)", snapshot.Stats.SuccessLines, len(snapshot.Logs))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(store.Snapshot().Logs); got != writers*rounds*10 {
+		t.Fatalf("записей %d, ожидалось %d", got, writers*rounds*10)
+	}
+
+	// Clear параллельно с чтением и записью
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			store.Clear()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			store.Snapshot()
+		}
+	}()
+	wg.Wait()
+}
+
+// useTestSessions - реестр в памяти вместо глобального sessions на время теста
+func useTestSessions(t *testing.T) *SessionRegistry {
+	t.Helper()
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := sessions
+	sessions = registry
+	t.Cleanup(func() { sessions = previous })
+	return registry
+}
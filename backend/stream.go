@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStreamStatsInterval = 5 * time.Second
+	minStreamStatsInterval     = time.Second
+	streamRetry                = 3 * time.Second // пауза переподключения EventSource
+)
+
+// streamEvent - событие потока: новая запись (log), сводка изменений (stats),
+// пропуск вытесненных записей (gap) или замена содержимого сессии (reset).
+// ID - последняя учтенная запись: с нее продолжается поток после переподключения.
+type streamEvent struct {
+	Event string      `json:"event"`
+	ID    int         `json:"id"`
+	Data  interface{} `json:"data"`
+}
+
+// streamSink - транспорт потока: SSE или WebSocket
+type streamSink interface {
+	send(event streamEvent) error
+}
+
+// logStream - состояние потока одного клиента
+type logStream struct {
+	session    Session
+	filter     LogFilter
+	fields     string
+	lastID     int // последняя учтенная запись, -1 - ни одной
+	generation int // поколение содержимого хранилища, см. StoreUsage.Generation
+	errors     int // ошибок разбора учтено в сводках
+
+	// Изменения с прошлой сводки
+	added   int
+	matched int
+	levels  map[string]int
+}
+
+// handleAPIStream - новые записи сессии по мере поступления (SSE или WebSocket).
+// Параметры фильтрации те же, что у /api/logs (limit не применяется), fields
+// задает поля записей. Поток начинается с новых записей; Last-Event-ID или
+// last_event_id продолжают его после указанной записи (-1 - с начала сессии).
+// Каждые stats_interval (по умолчанию 5s) отправляется сводка изменений.
+func handleAPIStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+
+	snapshot, generation := streamSnapshot(session.Store)
+	filter, err := parseLogFilter(query, snapshotLogs(snapshot))
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	filter.Limit = 0
+
+	interval := defaultStreamStatsInterval
+	if value := query.Get("stats_interval"); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval < minStreamStatsInterval {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "stats_interval: ожидается длительность не меньше "+minStreamStatsInterval.String()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	lastID, err := streamResumeID(r, snapshot)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	stream := &logStream{
+		session:    session,
+		filter:     filter,
+		fields:     query.Get("fields"),
+		lastID:     lastID,
+		generation: generation,
+		levels:     make(map[string]int),
+	}
+	if snapshot != nil {
+		stream.errors = len(snapshot.Errors)
+	}
+
+	ctx := r.Context()
+	var sink streamSink
+	if isWebSocketRequest(r) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		go conn.readLoop(cancel)
+		sink = conn
+	} else {
+		sse, err := newSSESink(w)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		sink = sse
+	}
+
+	stream.run(ctx, sink, interval)
+}
+
+// run - отправка событий до отключения клиента
+func (s *logStream) run(ctx context.Context, sink streamSink, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Канал берется до снимка: изменение между ними разбудит следующую итерацию
+	watch := s.session.Store.Watch()
+	if err := s.deliver(sink); err != nil {
+		return
+	}
+	if err := sink.send(s.stats()); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-watch:
+			watch = s.session.Store.Watch()
+			if err := s.deliver(sink); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := sink.send(s.stats()); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver - события для записей, добавленных после lastID
+func (s *logStream) deliver(sink streamSink) error {
+	snapshot, generation := streamSnapshot(s.session.Store)
+	logs := snapshotLogs(snapshot)
+
+	// Содержимое заменено (или клиент продолжает поток после замены):
+	// ID могли начаться заново, клиент получает записи с начала
+	replaced := len(logs) > 0 && s.lastID > logs[len(logs)-1].ID
+	if generation != s.generation || replaced {
+		s.generation = generation
+		s.errors = 0
+		if s.lastID >= 0 {
+			s.lastID = -1
+			if err := sink.send(streamEvent{Event: "reset", ID: -1, Data: map[string]interface{}{}}); err != nil {
+				return err
+			}
+		}
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+
+	// Записи вытеснены ограничениями раньше, чем были отправлены
+	start := s.lastID + 1 - logs[0].ID
+	if start < 0 {
+		gap := map[string]int{"from": s.lastID + 1, "to": logs[0].ID - 1}
+		s.lastID = logs[0].ID - 1
+		if err := sink.send(streamEvent{Event: "gap", ID: s.lastID, Data: gap}); err != nil {
+			return err
+		}
+		start = 0
+	}
+
+	s.filter.useAnnotations(s.session.Annotations)
+	for i := start; i < len(logs); i++ {
+		s.added++
+		s.lastID = logs[i].ID
+		if !s.filter.Match(&logs[i]) {
+			continue
+		}
+		s.matched++
+		s.levels[normalizeLevel(logs[i].Level())]++
+
+		entry := []TerraformLog{logs[i]}
+		s.filter.Time.renderLogs(entry)
+		if err := sink.send(streamEvent{Event: "log", ID: logs[i].ID, Data: projectLog(entry, s.fields)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stats - сводка изменений с прошлой сводки; total и errors - текущие
+// значения сессии, new_errors - ошибки разбора с прошлой сводки
+func (s *logStream) stats() streamEvent {
+	total, errors := 0, 0
+	if snapshot := s.session.Store.Snapshot(); snapshot != nil {
+		total, errors = len(snapshot.Logs), len(snapshot.Errors)
+	}
+	data := map[string]interface{}{
+		"added":      s.added,
+		"matched":    s.matched,
+		"by_level":   s.levels,
+		"total":      total,
+		"errors":     errors,
+		"new_errors": max(errors-s.errors, 0),
+	}
+	s.added, s.matched = 0, 0
+	s.levels = make(map[string]int)
+	s.errors = errors
+	return streamEvent{Event: "stats", ID: s.lastID, Data: data}
+}
+
+// streamSnapshot - снимок и поколение содержимого, к которому он относится
+func streamSnapshot(store LogStore) (*ParseResult, int) {
+	for {
+		generation := store.Usage().Generation
+		snapshot := store.Snapshot()
+		if store.Usage().Generation == generation {
+			return snapshot, generation
+		}
+	}
+}
+
+func snapshotLogs(snapshot *ParseResult) []TerraformLog {
+	if snapshot == nil {
+		return nil
+	}
+	return snapshot.Logs
+}
+
+// streamResumeID - запись, после которой начинается поток: Last-Event-ID
+// (переподключение EventSource), last_event_id или последняя сохраненная
+func streamResumeID(r *http.Request, snapshot *ParseResult) (int, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		if logs := snapshotLogs(snapshot); len(logs) > 0 {
+			return logs[len(logs)-1].ID, nil
+		}
+		return -1, nil
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || id < -1 {
+		return 0, fmt.Errorf("last_event_id: ожидается ID записи или -1, получено %s", value)
+	}
+	return id, nil
+}
+
+// projectLog - одна запись в том же виде, что и в ответе /api/logs
+func projectLog(entry []TerraformLog, fields string) interface{} {
+	switch projected := projectLogs(entry, fields).(type) {
+	case []TerraformLog:
+		return projected[0]
+	case []map[string]interface{}:
+		return projected[0]
+	}
+	return nil
+}
+
+// sseSink - Server-Sent Events: event, id и data на отдельных строках
+type sseSink struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSESink(w http.ResponseWriter) (*sseSink, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("потоковая передача не поддерживается")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	flusher.Flush()
+	return &sseSink{w: w, flusher: flusher}, nil
+}
+
+func (s *sseSink) send(event streamEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\nid: %d\ndata: %s\n\n", event.Event, event.ID, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// recordingSink - события потока для проверки без HTTP
+type recordingSink []streamEvent
+
+func (s *recordingSink) send(event streamEvent) error {
+	*s = append(*s, event)
+	return nil
+}
+
+func (s *recordingSink) take() string {
+	var parts []string
+	for _, event := range *s {
+		parts = append(parts, fmt.Sprintf("%s:%d", event.Event, event.ID))
+	}
+	*s = nil
+	return strings.Join(parts, " ")
+}
+
+func TestStreamResumeGapAndReset(t *testing.T) {
+	store := NewMemoryStore()
+	store.Append(parseSample(t, 3))
+	filter, err := parseLogFilter(url.Values{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := &logStream{
+		session:    Session{Store: store},
+		filter:     filter,
+		lastID:     0,
+		generation: store.Usage().Generation,
+		levels:     make(map[string]int),
+	}
+	var sink recordingSink
+
+	// Продолжение после записи 0, затем только новые записи
+	changed := store.Watch()
+	stream.deliver(&sink)
+	if got := sink.take(); got != "log:1 log:2" {
+		t.Errorf("продолжение потока: %s", got)
+	}
+	store.Append(parseSample(t, 1))
+	select {
+	case <-changed:
+	default:
+		t.Fatal("Watch не сработал после Append")
+	}
+	stream.deliver(&sink)
+	if got := sink.take(); got != "log:3" {
+		t.Errorf("новые записи: %s", got)
+	}
+
+	// Вытесненные до отправки записи отмечаются пропуском
+	store.Append(parseSample(t, 3))
+	store.Trim(2, 0)
+	stream.deliver(&sink)
+	if got := sink.take(); got != "gap:4 log:5 log:6" {
+		t.Errorf("пропуск вытесненных: %s", got)
+	}
+	if stats := stream.stats(); stats.ID != 6 || stats.Data.(map[string]interface{})["added"] != 5 {
+		t.Errorf("сводка: %+v", stats)
+	}
+
+	// После замены содержимого поток начинается заново
+	store.Replace(parseSample(t, 10))
+	stream.deliver(&sink)
+	if got := sink.take(); !strings.HasPrefix(got, "reset:-1 log:0 log:1 ") || !strings.HasSuffix(got, "log:9") {
+		t.Errorf("замена содержимого: %s", got)
+	}
+}
+
+func TestHandleAPIStreamValidation(t *testing.T) {
+	_, server := testServer(t)
+	tests := []struct {
+		method, target string
+		code           int
+		want           string
+	}{
+		{"POST", "/api/stream", http.StatusMethodNotAllowed, "Метод"},
+		{"GET", "/api/sessions/missing/stream", http.StatusNotFound, "Сессия не найдена"},
+		{"GET", "/api/stream?min_level=loud", http.StatusBadRequest, "loud"},
+		{"GET", "/api/stream?stats_interval=500ms", http.StatusBadRequest, "stats_interval"},
+		{"GET", "/api/stream?stats_interval=often", http.StatusBadRequest, "stats_interval"},
+		{"GET", "/api/stream?last_event_id=first", http.StatusBadRequest, "last_event_id"},
+		{"GET", "/api/stream?last_event_id=-2", http.StatusBadRequest, "last_event_id"},
+	}
+	for _, tt := range tests {
+		var failed struct {
+			Error string `json:"error"`
+		}
+		if code := serveJSON(t, server, tt.method, tt.target, "", &failed); code != tt.code || !strings.Contains(failed.Error, tt.want) {
+			t.Errorf("%s %s: код %d, ошибка %q", tt.method, tt.target, code, failed.Error)
+		}
+	}
+
+	// Last-Event-ID важнее параметра и проверяется так же
+	request := httptest.NewRequest("GET", "/api/stream?last_event_id=0", nil)
+	request.Header.Set("Last-Event-ID", "abc")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "abc") {
+		t.Errorf("Last-Event-ID: код %d, %s", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Прием логов по syslog (RFC 5424 и RFC 3164) через UDP и TCP. JSON строка
+// Terraform из текста сообщения разбирается как строка лога, имя узла,
+// приложение и ID процесса становятся полями записи.
+
+const (
+	maxSyslogMessage = 1 << 20 // ограничение размера одного сообщения
+	maxSyslogBatch   = 1000    // сообщений в одной пачке
+
+	// syslogUDPBatchInterval - датаграммы, пришедшие за это время, сохраняются одной пачкой
+	syslogUDPBatchInterval = 200 * time.Millisecond
+)
+
+// syslogSessionParam - параметр structured data с ID или названием сессии
+// ([tflog session="nightly"]); важнее правил --syslog-route
+const syslogSessionParam = "session"
+
+// syslogOptions - флаги приема syslog
+type syslogOptions struct {
+	udp     string
+	tcp     string
+	session string
+	routes  syslogRoutes
+}
+
+func registerSyslogFlags(fs *flag.FlagSet) *syslogOptions {
+	opts := &syslogOptions{}
+
+	fs.StringVar(&opts.udp, "syslog-udp", "", "адрес приема syslog по UDP, например :5514 (пусто - выключено)")
+	fs.StringVar(&opts.tcp, "syslog-tcp", "", "адрес приема syslog по TCP, например :5514 (пусто - выключено)")
+	fs.StringVar(&opts.session, "syslog-session", "", "сессия для сообщений syslog, не подошедших ни под одно правило (пусто - default)")
+	fs.Var(&opts.routes, "syslog-route", "правило выбора сессии: host=ci-*,app=terraform:название (можно повторять; поля host, app, procid, msgid, facility)")
+
+	return opts
+}
+
+// syslogMessage - разобранное сообщение syslog
+type syslogMessage struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	Session   string // параметр session из structured data
+	Message   string
+}
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// syslogRouteFields - поля сообщения, доступные в правилах --syslog-route
+var syslogRouteFields = []string{"host", "app", "procid", "msgid", "facility"}
+
+// field - значение поля сообщения для правил маршрутизации
+func (m syslogMessage) field(name string) string {
+	switch name {
+	case "host":
+		return m.Hostname
+	case "app":
+		return m.AppName
+	case "procid":
+		return m.ProcID
+	case "msgid":
+		return m.MsgID
+	case "facility":
+		return syslogFacilities[m.Facility]
+	}
+	return ""
+}
+
+// level - уровень Terraform по severity: 0-3 error, 4 warn, 5-6 info, 7 debug
+func (m syslogMessage) level() string {
+	switch {
+	case m.Severity <= 3:
+		return "error"
+	case m.Severity == 4:
+		return "warn"
+	case m.Severity <= 6:
+		return "info"
+	}
+	return "debug"
+}
+
+// syslogRoute - правило: все условия совпадают - сообщение идет в session
+type syslogRoute struct {
+	conditions []syslogCondition
+	session    string
+}
+
+// syslogCondition - поле сообщения и шаблон его значения (* и ?, без учета регистра)
+type syslogCondition struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+// syslogRoutes - правила --syslog-route в порядке проверки
+type syslogRoutes []syslogRoute
+
+func (r *syslogRoutes) String() string {
+	return fmt.Sprintf("%d правил", len(*r))
+}
+
+// Set - правило вида host=ci-*,app=terraform:nightly
+func (r *syslogRoutes) Set(value string) error {
+	colon := strings.LastIndexByte(value, ':')
+	if colon < 0 || strings.TrimSpace(value[colon+1:]) == "" {
+		return errors.New("ожидается условия:сессия, например host=ci-*:nightly")
+	}
+	route := syslogRoute{session: strings.TrimSpace(value[colon+1:])}
+	for _, condition := range strings.Split(value[:colon], ",") {
+		name, pattern, ok := strings.Cut(strings.TrimSpace(condition), "=")
+		if !ok || name == "" || pattern == "" {
+			return fmt.Errorf("некорректное условие %q: ожидается поле=шаблон", condition)
+		}
+		if !containsString(syslogRouteFields, name) {
+			return fmt.Errorf("неизвестное поле %q: ожидается %s", name, strings.Join(syslogRouteFields, ", "))
+		}
+		route.conditions = append(route.conditions, syslogCondition{field: name, pattern: modulePattern(pattern)})
+	}
+	*r = append(*r, route)
+	return nil
+}
+
+// sessionFor - сессия сообщения: параметр session, первое подошедшее правило
+// или fallback
+func (r syslogRoutes) sessionFor(message syslogMessage, fallback string) string {
+	if message.Session != "" {
+		return message.Session
+	}
+	for _, route := range r {
+		matched := true
+		for _, condition := range route.conditions {
+			if !condition.pattern.MatchString(message.field(condition.field)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return route.session
+		}
+	}
+	return fallback
+}
+
+// startSyslog - запуск приема syslog на адресах из флагов; ошибка, если адрес занят
+func startSyslog(opts *syslogOptions) error {
+	if opts.udp != "" {
+		conn, err := net.ListenPacket("udp", opts.udp)
+		if err != nil {
+			return fmt.Errorf("syslog UDP: %w", err)
+		}
+		fmt.Printf("Прием syslog по UDP: %s\n", conn.LocalAddr())
+		go serveSyslogUDP(conn, opts)
+	}
+	if opts.tcp != "" {
+		listener, err := net.Listen("tcp", opts.tcp)
+		if err != nil {
+			return fmt.Errorf("syslog TCP: %w", err)
+		}
+		fmt.Printf("Прием syslog по TCP: %s\n", listener.Addr())
+		go serveSyslogTCP(listener, opts)
+	}
+	return nil
+}
+
+// serveSyslogUDP - одна датаграмма - одно сообщение. Первая датаграмма пачки
+// ставит срок чтения: все, что пришло до него, сохраняется одной пачкой.
+func serveSyslogUDP(conn net.PacketConn, opts *syslogOptions) {
+	buf := make([]byte, 64*1024)
+	batch := newIngestBatch()
+	pending := 0
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err == nil {
+			if pending == 0 {
+				conn.SetReadDeadline(time.Now().Add(syslogUDPBatchInterval))
+			}
+			addSyslogMessage(batch, buf[:n], opts)
+			pending++
+		}
+		closed := errors.Is(err, net.ErrClosed)
+		if pending > 0 && (closed || errors.Is(err, os.ErrDeadlineExceeded) || pending >= maxSyslogBatch) {
+			storeSyslogBatch(batch)
+			batch, pending = newIngestBatch(), 0
+			conn.SetReadDeadline(time.Time{})
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+func serveSyslogTCP(listener net.Listener, opts *syslogOptions) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			if err := readSyslogStream(conn, opts); err != nil {
+				log.Printf("syslog %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// readSyslogStream - сообщения из TCP соединения до его закрытия. Сообщения,
+// уже полученные целиком, сохраняются одной пачкой.
+func readSyslogStream(r io.Reader, opts *syslogOptions) error {
+	reader := bufio.NewReaderSize(r, maxSyslogMessage)
+	batch := newIngestBatch()
+	pending := 0
+	for {
+		frame, err := readSyslogFrame(reader)
+		if len(frame) > 0 {
+			addSyslogMessage(batch, frame, opts)
+			pending++
+		}
+		if pending > 0 && (err != nil || reader.Buffered() == 0 || pending >= maxSyslogBatch) {
+			storeSyslogBatch(batch)
+			batch, pending = newIngestBatch(), 0
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readSyslogFrame - одно сообщение потока TCP (RFC 6587): с подсчетом октетов
+// ("<длина> <сообщение>") или до перевода строки
+func readSyslogFrame(reader *bufio.Reader) ([]byte, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("сообщение без перевода строки длиннее %d байт", maxSyslogMessage)
+		}
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+
+	header, err := reader.ReadSlice(' ')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(string(header[:len(header)-1]))
+	if err != nil || length <= 0 || length > maxSyslogMessage {
+		return nil, fmt.Errorf("некорректная длина сообщения %q", bytes.TrimSpace(header))
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return bytes.TrimRight(frame, "\r\n"), nil
+}
+
+// addSyslogMessage - сообщение в пачку по правилам маршрутизации; сообщения,
+// которые не удалось разобрать, сохраняются целиком как текст
+func addSyslogMessage(batch *ingestBatch, data []byte, opts *syslogOptions) {
+	message, err := parseSyslogMessage(string(bytes.TrimRight(data, "\r\n\x00")), time.Now())
+	if err != nil {
+		message = syslogMessage{Severity: 6, Message: string(data)}
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+
+	attributes := map[string]interface{}{"@level": message.level()}
+	for name, value := range map[string]string{"hostname": message.Hostname, "appname": message.AppName, "procid": message.ProcID, "msgid": message.MsgID} {
+		if value != "" {
+			attributes[name] = value
+		}
+	}
+	batch.add(opts.routes.sessionFor(message, opts.session), ingestLine{
+		Line:       textPayloadLine(message.Message),
+		Timestamp:  message.Timestamp,
+		Attributes: attributes,
+	})
+}
+
+func storeSyslogBatch(batch *ingestBatch) {
+	summaries, err := batch.store(sessions, "syslog")
+	if err != nil {
+		log.Printf("Ошибка сохранения syslog: %v", err)
+	}
+	for _, summary := range summaries {
+		fmt.Fprintf(serverOutput, " syslog: сессия %s, записей %d, ошибок разбора %d\n", summary.Session, summary.Added, summary.Errors)
+	}
+}
+
+// parseSyslogMessage - сообщение RFC 5424 (<PRI>1 ...) или RFC 3164 (<PRI>Mmm dd hh:mm:ss ...);
+// now нужен для года в RFC 3164
+func parseSyslogMessage(text string, now time.Time) (syslogMessage, error) {
+	var message syslogMessage
+	if !strings.HasPrefix(text, "<") {
+		return message, errors.New("нет приоритета <PRI>")
+	}
+	end := strings.IndexByte(text, '>')
+	if end < 2 || end > 4 {
+		return message, errors.New("некорректный приоритет")
+	}
+	priority, err := strconv.Atoi(text[1:end])
+	if err != nil || priority > 191 {
+		return message, fmt.Errorf("некорректный приоритет %q", text[1:end])
+	}
+	message.Facility, message.Severity = priority/8, priority%8
+	rest := text[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parseSyslog5424(message, rest[2:])
+	}
+	return parseSyslog3164(message, rest, now), nil
+}
+
+// parseSyslog5424 - TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseSyslog5424(message syslogMessage, rest string) (syslogMessage, error) {
+	var header [5]string
+	for i := range header {
+		field, tail, ok := strings.Cut(rest, " ")
+		if !ok && i < len(header)-1 {
+			return message, errors.New("RFC 5424: неполный заголовок")
+		}
+		if field != "-" {
+			header[i] = field
+		}
+		rest = tail
+	}
+	if header[0] != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return message, fmt.Errorf("RFC 5424: некорректное время %q", header[0])
+		}
+		message.Timestamp = timestamp
+	}
+	message.Hostname, message.AppName, message.ProcID, message.MsgID = header[1], header[2], header[3], header[4]
+
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		for strings.HasPrefix(rest, "[") {
+			tail, err := parseSyslogElement(rest, &message)
+			if err != nil {
+				return message, err
+			}
+			rest = tail
+		}
+	}
+	message.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return message, nil
+}
+
+// parseSyslogElement - один элемент [id param="value" ...]; из параметров
+// нужен только session. Возвращает остаток строки.
+func parseSyslogElement(text string, message *syslogMessage) (string, error) {
+	i := 1
+	for i < len(text) && text[i] != ' ' && text[i] != ']' {
+		i++
+	}
+	for i < len(text) && text[i] == ' ' {
+		eq := strings.IndexByte(text[i:], '=')
+		if eq < 0 || i+eq+1 >= len(text) || text[i+eq+1] != '"' {
+			return "", errors.New("RFC 5424: некорректный параметр structured data")
+		}
+		name := text[i+1 : i+eq]
+		var value strings.Builder
+		j := i + eq + 2
+		for ; j < len(text) && text[j] != '"'; j++ {
+			if text[j] == '\\' && j+1 < len(text) && strings.IndexByte(`"\]`, text[j+1]) >= 0 {
+				j++
+			}
+			value.WriteByte(text[j])
+		}
+		if j >= len(text) {
+			return "", errors.New("RFC 5424: нет закрывающей кавычки в structured data")
+		}
+		if name == syslogSessionParam {
+			message.Session = value.String()
+		}
+		i = j + 1
+	}
+	if i >= len(text) || text[i] != ']' {
+		return "", errors.New("RFC 5424: незакрытый элемент structured data")
+	}
+	return text[i+1:], nil
+}
+
+// parseSyslog3164 - Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG. Формат не строгий:
+// без времени весь текст - сообщение, без тега - текст после имени узла.
+func parseSyslog3164(message syslogMessage, rest string, now time.Time) syslogMessage {
+	if len(rest) < len(time.Stamp)+1 {
+		message.Message = rest
+		return message
+	}
+	timestamp, err := time.ParseInLocation(time.Stamp, rest[:len(time.Stamp)], now.Location())
+	if err != nil {
+		message.Message = rest
+		return message
+	}
+	// Года в RFC 3164 нет: время из будущего относится к прошлому году
+	timestamp = timestamp.AddDate(now.Year(), 0, 0)
+	if timestamp.After(now.Add(24 * time.Hour)) {
+		timestamp = timestamp.AddDate(-1, 0, 0)
+	}
+	message.Timestamp = timestamp
+	rest = strings.TrimLeft(rest[len(time.Stamp):], " ")
+
+	host, tail, _ := strings.Cut(rest, " ")
+	message.Hostname = host
+	rest = tail
+
+	tagEnd := strings.IndexAny(rest, ":[ ")
+	if tagEnd <= 0 || rest[tagEnd] == ' ' {
+		message.Message = rest
+		return message
+	}
+	message.AppName = rest[:tagEnd]
+	rest = rest[tagEnd:]
+	if strings.HasPrefix(rest, "[") {
+		if pidEnd := strings.IndexByte(rest, ']'); pidEnd > 0 {
+			message.ProcID = rest[1:pidEnd]
+			rest = rest[pidEnd+1:]
+		}
+	}
+	message.Message = strings.TrimPrefix(strings.TrimPrefix(rest, ":"), " ")
+	return message
+}
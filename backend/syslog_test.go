@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogMessages(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	message, err := parseSyslogMessage(`<165>1 2024-01-01T10:00:00.5Z ci-01 terraform 4242 apply [tflog@1 session="nightly" note="a \"b\" \]"] `+"\ufeff"+`terraform: {"@level":"info"}`, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Facility != 20 || message.Severity != 5 || message.Hostname != "ci-01" || message.AppName != "terraform" || message.ProcID != "4242" || message.MsgID != "apply" || message.Session != "nightly" || message.Message != `terraform: {"@level":"info"}` {
+		t.Errorf("RFC 5424: %+v", message)
+	}
+	if textPayloadLine(message.Message) != `{"@level":"info"}` {
+		t.Errorf("JSON из сообщения: %s", textPayloadLine(message.Message))
+	}
+
+	// RFC 3164: декабрьская запись в январе относится к прошлому году
+	message, err = parseSyslogMessage("<11>Dec 31 23:59:59 agent7 terraform[77]: Error: boom", now)
+	if err != nil || message.Hostname != "agent7" || message.AppName != "terraform" || message.ProcID != "77" || message.Message != "Error: boom" || message.Timestamp.Year() != 2023 {
+		t.Errorf("RFC 3164: %+v, %v", message, err)
+	}
+	if _, err := parseSyslogMessage("<14>1 2024-01-01T10:00:00Z host", now); err == nil {
+		t.Error("неполный заголовок RFC 5424 должен быть ошибкой")
+	}
+
+	var routes syslogRoutes
+	if err := routes.Set("host=ci-*,app=terraform:ci"); err != nil {
+		t.Fatal(err)
+	}
+	if err := routes.Set("pid=1:x"); err == nil {
+		t.Error("неизвестное поле в правиле должно быть ошибкой")
+	}
+	if got := routes.sessionFor(syslogMessage{Hostname: "CI-02", AppName: "terraform"}, "fallback"); got != "ci" {
+		t.Errorf("правило: %s", got)
+	}
+	if got := routes.sessionFor(syslogMessage{Hostname: "ci-02", AppName: "vault"}, "fallback"); got != "fallback" {
+		t.Errorf("без правила: %s", got)
+	}
+
+	// Поток TCP: подсчет октетов и сообщения до перевода строки вперемешку
+	first := `<14>1 2024-01-01T10:00:00Z ci-01 terraform 1 - - {"@message":"one"}` + "\n"
+	stream := fmt.Sprintf("%d %s", len(first), first) + "<14>Jan  2 10:00:00 agent7 terraform: two\n"
+	reader := bufio.NewReader(strings.NewReader(stream))
+	var frames []string
+	for {
+		frame, err := readSyslogFrame(reader)
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		frames = append(frames, string(frame))
+	}
+	if len(frames) != 2 || frames[0] != strings.TrimSpace(first) || !strings.HasSuffix(frames[1], "two") {
+		t.Errorf("кадры: %q", frames)
+	}
+	if _, err := readSyslogFrame(bufio.NewReader(strings.NewReader("100 short"))); err != io.ErrUnexpectedEOF {
+		t.Errorf("обрезанное сообщение: %v", err)
+	}
+
+	batch := newIngestBatch()
+	opts := &syslogOptions{routes: routes}
+	addSyslogMessage(batch, []byte(frames[0]), opts)
+	addSyslogMessage(batch, []byte(frames[1]), opts)
+	if len(batch.order) != 2 || len(batch.lines["ci"]) != 1 || len(batch.lines[""]) != 1 {
+		t.Fatalf("сессии: %v", batch.lines)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(batch.lines["ci"][0]), &fields); err != nil || fields["hostname"] != "ci-01" || fields["appname"] != "terraform" || fields["procid"] != "1" || fields["@message"] != "one" {
+		t.Errorf("поля записи: %v, %v", fields, err)
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	oversized := strings.Repeat("x", maxSyslogMessage+1)
+	tests := []struct {
+		name   string
+		stream string
+		frames []string
+		err    string
+	}{
+		{"подсчет октетов", "5 <14>a5 <14>b", []string{"<14>a", "<14>b"}, ""},
+		{"перевод строки в конце кадра", "6 <14>a\n", []string{"<14>a"}, ""},
+		{"строки CRLF", "<14>a\r\n<14>b\r\n", []string{"<14>a", "<14>b"}, ""},
+		{"последняя строка без перевода", "<14>a\n<14>b", []string{"<14>a", "<14>b"}, ""},
+		{"пустые строки", "\n\n<14>a\n", []string{"", "", "<14>a"}, ""},
+		{"пустой поток", "", nil, ""},
+		{"обрезанный кадр", "10 <14>a", nil, io.ErrUnexpectedEOF.Error()},
+		{"длина без пробела", "12", nil, io.ErrUnexpectedEOF.Error()},
+		{"нечисловая длина", "12x <14>a", nil, "некорректная длина"},
+		{"нулевая длина", "0 ", nil, "некорректная длина"},
+		{"длина больше ограничения", fmt.Sprintf("%d ", maxSyslogMessage+1), nil, "некорректная длина"},
+		{"строка больше ограничения", "<14>" + oversized + "\n", nil, "длиннее"},
+		{"кадр, затем мусор", "5 <14>a12x ", []string{"<14>a"}, "некорректная длина"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(strings.NewReader(test.stream), maxSyslogMessage)
+			var frames []string
+			var err error
+			for {
+				var frame []byte
+				if frame, err = readSyslogFrame(reader); err != nil {
+					break
+				}
+				frames = append(frames, string(frame))
+			}
+			if test.err == "" && err != io.EOF || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("ошибка %v, ожидалось %q", err, test.err)
+			}
+			if fmt.Sprintf("%q", frames) != fmt.Sprintf("%q", test.frames) {
+				t.Errorf("кадры %q, ожидалось %q", frames, test.frames)
+			}
+		})
+	}
+}
+
+func TestParseSyslogMessageErrors(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"no priority", "нет приоритета"},
+		{"<>1 - - - - - -", "некорректный приоритет"},
+		{"<14", "некорректный приоритет"},
+		{"<12345>1 - - - - - -", "некорректный приоритет"},
+		{"<192>1 - - - - - -", "некорректный приоритет"},
+		{"<1a>1 - - - - - -", "некорректный приоритет"},
+		{"<14>1 вчера host app - - -", "некорректное время"},
+		{"<14>1 - host", "неполный заголовок"},
+		{`<14>1 - host app - - [id a="b"`, "незакрытый элемент"},
+		{`<14>1 - host app - - [id a=b]`, "некорректный параметр"},
+		{`<14>1 - host app - - [id a="b]`, "нет закрывающей кавычки"},
+	}
+	for _, test := range tests {
+		if _, err := parseSyslogMessage(test.input, now); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: ошибка %v, ожидалось %q", test.input, err, test.err)
+		}
+	}
+
+	valid := []struct {
+		input string
+		want  syslogMessage
+	}{
+		{"<14>1 - - - - - -", syslogMessage{Facility: 1, Severity: 6}},
+		{"<14>1 - host app - - - текст", syslogMessage{Facility: 1, Severity: 6, Hostname: "host", AppName: "app", Message: "текст"}},
+		{`<14>1 - h a - - [x@1 k="v"][tflog session="s"] m`, syslogMessage{Facility: 1, Severity: 6, Hostname: "h", AppName: "a", Session: "s", Message: "m"}},
+		{"<13>просто текст", syslogMessage{Facility: 1, Severity: 5, Message: "просто текст"}},
+		{"<13>Jun  1 11:00:00 host текст без тега", syslogMessage{Facility: 1, Severity: 5, Hostname: "host", Message: "текст без тега", Timestamp: time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)}},
+		{"<13>Jun  1 11:00:00 host app: m", syslogMessage{Facility: 1, Severity: 5, Hostname: "host", AppName: "app", Message: "m", Timestamp: time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)}},
+	}
+	for _, test := range valid {
+		got, err := parseSyslogMessage(test.input, now)
+		if err != nil || !got.Timestamp.Equal(test.want.Timestamp) {
+			t.Errorf("%q: %+v, %v", test.input, got, err)
+			continue
+		}
+		got.Timestamp = test.want.Timestamp
+		if got != test.want {
+			t.Errorf("%q: %+v, ожидалось %+v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestSyslogUDPBatches(t *testing.T) {
+	registry := useTestSessions(t)
+	var output bytes.Buffer
+	defer func(previous io.Writer) { serverOutput = previous }(serverOutput)
+	serverOutput = &output
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		serveSyslogUDP(conn, &syslogOptions{session: "udp"})
+		close(done)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := range 5 {
+		fmt.Fprintf(client, `<14>1 2024-01-01T10:00:00Z ci-01 terraform 1 - - {"@message":"m%d"}`, i)
+	}
+
+	// Датаграммы, пришедшие подряд, сохраняются одной пачкой
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries := 0
+		for _, session := range registry.List() {
+			entries += session.Store.Usage().Entries
+		}
+		if entries == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("датаграммы не сохранены")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	conn.Close()
+	<-done
+	if lines := strings.Count(output.String(), "syslog: сессия"); lines != 1 || !strings.Contains(output.String(), "записей 5") {
+		t.Errorf("сообщения о пачках: %q", output.String())
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // база часовых поясов для tz= в контейнере без tzdata
+	"unicode"
+)
+
+// timeContext - опорные точки для разбора времени в фильтрах:
+// границы сессии, зона для значений без смещения и зона вывода
+type timeContext struct {
+	start time.Time
+	end   time.Time
+	now   time.Time
+	loc   *time.Location // зона для значений без смещения
+	out   *time.Location // зона вывода; nil - как в исходном логе
+}
+
+// relativeTimePattern - start+10m, end-1h30m, now-2d, -15m, +10m
+var relativeTimePattern = regexp.MustCompile(`^(start|end|now)?\s*(?:([+-])\s*([0-9][0-9a-zµ.]*))?$`)
+
+// "+" в неэкранированной строке запроса приходит пробелом: start+30m -> "start 30m",
+// +10m -> " 10m", смещение +03:00 -> " 03:00". См. restorePlus.
+var (
+	spacedOffsetPattern = regexp.MustCompile(`^(start|end|now)?\s+([0-9][0-9.]*[a-zµ][0-9a-zµ.]*)$`)
+	spacedZonePattern   = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9:.]+)\s+([0-9]{2}(?::?[0-9]{2})?)$`)
+)
+
+// timeOfDayFormats - время без даты, привязывается к дню сессии, см. timeOfDay
+var timeOfDayFormats = []string{"15:04:05.999999999", "15:04:05", "15:04"}
+
+// newTimeContext - контекст по записям сессии и параметру tz
+// (IANA имя, UTC, Local или смещение +03:00)
+func newTimeContext(logs []TerraformLog, tz string) (timeContext, error) {
+	ctx := timeContext{now: time.Now(), loc: time.UTC}
+
+	for _, log := range logs {
+		if log.Timestamp.IsZero() {
+			continue
+		}
+		if ctx.start.IsZero() {
+			// Без tz значения без смещения читаем в зоне самого лога
+			ctx.loc = log.Timestamp.Location()
+		}
+		if ctx.start.IsZero() || log.Timestamp.Before(ctx.start) {
+			ctx.start = log.Timestamp
+		}
+		if log.Timestamp.After(ctx.end) {
+			ctx.end = log.Timestamp
+		}
+	}
+
+	if tz != "" {
+		loc, err := loadLocation(tz)
+		if err != nil {
+			return ctx, err
+		}
+		ctx.loc = loc
+		ctx.out = loc
+	}
+
+	return ctx, nil
+}
+
+// loadLocation - часовой пояс по имени или по смещению вида +03:00 / -0530
+func loadLocation(tz string) (*time.Location, error) {
+	if trimmed := strings.TrimLeft(tz, " "); trimmed != tz && trimmed != "" {
+		tz = "+" + trimmed // "+03:00" без экранирования
+	}
+	if tz[0] == '+' || tz[0] == '-' {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, tz); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(tz, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("неверное смещение часового пояса: %s", tz)
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("неизвестный часовой пояс: %s", tz)
+	}
+	return loc, nil
+}
+
+// parse - абсолютное, относительное или время суток.
+// Без опорной точки "-15m" отсчитывается от конца сессии, "+10m" - от начала.
+func (ctx timeContext) parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(restorePlus(value))
+
+	if match := relativeTimePattern.FindStringSubmatch(value); match != nil && value != "" {
+		anchor, sign, offsetStr := match[1], match[2], match[3]
+		if anchor == "" {
+			anchor = "end"
+			if sign == "+" {
+				anchor = "start"
+			}
+		}
+
+		base := ctx.anchor(anchor)
+		if offsetStr == "" {
+			return base, nil
+		}
+		offset, err := parseDurationWithDays(offsetStr)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("неверный интервал %q: %w", offsetStr, err)
+		}
+		if sign == "-" {
+			offset = -offset
+		}
+		return base.Add(offset), nil
+	}
+
+	for _, layout := range timeOfDayFormats {
+		if clock, err := time.Parse(layout, value); err == nil {
+			return ctx.timeOfDay(clock), nil
+		}
+	}
+
+	return parseTimeFlexible(value, ctx.loc)
+}
+
+// restorePlus - значение времени, в котором "+" пришел пробелом, с
+// восстановленным "+"; остальные значения возвращаются как есть
+func restorePlus(value string) string {
+	trimmed := strings.TrimRightFunc(value, unicode.IsSpace)
+	if spacedOffsetPattern.MatchString(trimmed) {
+		return spacedOffsetPattern.ReplaceAllString(trimmed, "$1+$2")
+	}
+	if spacedZonePattern.MatchString(trimmed) {
+		return spacedZonePattern.ReplaceAllString(trimmed, "$1+$2")
+	}
+	return value
+}
+
+// timeOfDay - время суток в первый день сессии, когда оно попадает в ее границы:
+// в сессии 23:30-00:40 значение 00:10 относится к следующему дню. Если время
+// в сессию не попадает, берется дата начала сессии (без записей - текущая дата).
+func (ctx timeContext) timeOfDay(clock time.Time) time.Time {
+	first, last := ctx.start, ctx.end
+	if first.IsZero() {
+		first, last = ctx.now, ctx.now
+	}
+	first = first.In(ctx.loc)
+	at := func(day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(),
+			clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), ctx.loc)
+	}
+
+	candidate := at(first)
+	if candidate.Before(first) {
+		if next := at(first.AddDate(0, 0, 1)); !next.After(last) {
+			return next
+		}
+	}
+	return candidate
+}
+
+func (ctx timeContext) anchor(name string) time.Time {
+	switch name {
+	case "start":
+		if !ctx.start.IsZero() {
+			return ctx.start
+		}
+	case "end":
+		if !ctx.end.IsZero() {
+			return ctx.end
+		}
+	}
+	return ctx.now
+}
+
+// parseDurationWithDays - time.ParseDuration с поддержкой суток: 2d, 1d12h
+func parseDurationWithDays(value string) (time.Duration, error) {
+	var days time.Duration
+	if idx := strings.Index(value, "d"); idx > 0 {
+		n, err := strconv.ParseFloat(value[:idx], 64)
+		if err != nil {
+			return 0, err
+		}
+		days = time.Duration(n * float64(24*time.Hour))
+		value = value[idx+1:]
+		if value == "" {
+			return days, nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return days + d, nil
+}
+
+// render - время в зоне вывода, если она задана параметром tz
+func (ctx timeContext) render(t time.Time) time.Time {
+	if ctx.out == nil || t.IsZero() {
+		return t
+	}
+	return t.In(ctx.out)
+}
+
+// renderLogs - перевод временных меток записей в зону вывода (записи - копии)
+func (ctx timeContext) renderLogs(logs []TerraformLog) {
+	if ctx.out == nil {
+		return
+	}
+	for i := range logs {
+		logs[i].Timestamp = ctx.render(logs[i].Timestamp)
+	}
+}
+
+// renderSpans - перевод границ интервалов в зону вывода
+func (ctx timeContext) renderSpans(spans []OperationSpan) {
+	if ctx.out == nil {
+		return
+	}
+	for i := range spans {
+		spans[i].Start = ctx.render(spans[i].Start)
+		spans[i].End = ctx.render(spans[i].End)
+	}
+}
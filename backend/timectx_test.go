@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// midnightSample - сессия с 23:30 до 00:40 следующего дня
+const midnightSample = `{"@level":"info","@message":"start","@timestamp":"2025-09-09T23:30:00+03:00"}
+{"@level":"info","@message":"before midnight","@timestamp":"2025-09-09T23:55:00+03:00"}
+{"@level":"info","@message":"after midnight","@timestamp":"2025-09-10T00:20:00+03:00"}
+{"@level":"info","@message":"end","@timestamp":"2025-09-10T00:40:00+03:00"}
+`
+
+func TestTimeContextParse(t *testing.T) {
+	logs := NewLogParser().ParseStream(strings.NewReader(midnightSample)).Logs
+	msk := time.FixedZone("", 3*3600)
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2025, 9, day, hour, minute, second, 0, msk)
+	}
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		tz    string
+		value string
+		want  time.Time
+	}{
+		// Относительное время: от начала, конца сессии и текущего момента
+		{"", "start", at(9, 23, 30, 0)},
+		{"", "end", at(10, 0, 40, 0)},
+		{"", "start+10m", at(9, 23, 40, 0)},
+		{"", "+10m", at(9, 23, 40, 0)},
+		{"", "-15m", at(10, 0, 25, 0)},
+		{"", "end - 1h30m", at(9, 23, 10, 0)},
+		{"", "now-1d", now.Add(-24 * time.Hour)},
+		{"", "now", now},
+		// "+" из неэкранированной строки запроса приходит пробелом
+		{"", "start 10m", at(9, 23, 40, 0)},
+		{"", " 10m", at(9, 23, 40, 0)},
+		{"", "2025-09-09T23:45:00 03:00", at(9, 23, 45, 0)},
+		{" 05:00", "2025-09-10 02:00", time.Date(2025, 9, 10, 2, 0, 0, 0, time.FixedZone("", 5*3600))},
+		// Абсолютное время: без смещения - в зоне лога или tz
+		{"", "2025-09-09T20:00:00Z", time.Date(2025, 9, 9, 20, 0, 0, 0, time.UTC)},
+		{"", "2025-09-09 23:45", at(9, 23, 45, 0)},
+		{"", "2025-09-10", at(10, 0, 0, 0)},
+		{"UTC", "2025-09-09T20:45", time.Date(2025, 9, 9, 20, 45, 0, 0, time.UTC)},
+		{"+05:00", "2025-09-10 02:00", time.Date(2025, 9, 10, 2, 0, 0, 0, time.FixedZone("", 5*3600))},
+		// Время суток: день, в который оно попадает в сессию
+		{"", "23:45", at(9, 23, 45, 0)},
+		{"", "00:10", at(10, 0, 10, 0)},
+		{"", "00:20:30.5", at(10, 0, 20, 30).Add(500 * time.Millisecond)},
+		{"", "12:00", at(9, 12, 0, 0)},
+		{"", "23:00", at(9, 23, 0, 0)},
+		// С tz время суток читается в этой зоне: 21:00 UTC = 00:00 MSK следующего дня
+		{"UTC", "21:00", time.Date(2025, 9, 9, 21, 0, 0, 0, time.UTC)},
+		{"UTC", "20:35", time.Date(2025, 9, 9, 20, 35, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		ctx, err := newTimeContext(logs, tt.tz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx.now = now
+		got, err := ctx.parse(tt.value)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("tz=%s %q: %v, %v; ожидалось %v", tt.tz, tt.value, got, err, tt.want)
+		}
+	}
+
+	ctx, _ := newTimeContext(nil, "")
+	ctx.now = now
+	if got, _ := ctx.parse("10:30"); !got.Equal(time.Date(2025, 10, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("время суток без записей: %v, ожидалась текущая дата", got)
+	}
+}
+
+func TestTimeContextErrors(t *testing.T) {
+	ctx, _ := newTimeContext(nil, "")
+	for _, value := range []string{"вчера", "start+", "start+5x", "25:00", "2025-13-01", "end*2"} {
+		if _, err := ctx.parse(value); err == nil {
+			t.Errorf("%q должно быть ошибкой", value)
+		}
+	}
+	for _, tz := range []string{"Mars/Olympus", "+25:00", "-x"} {
+		if _, err := newTimeContext(nil, tz); err == nil {
+			t.Errorf("tz=%s должно быть ошибкой", tz)
+		}
+	}
+	if d, err := parseDurationWithDays("1d12h"); err != nil || d != 36*time.Hour {
+		t.Errorf("1d12h: %v, %v", d, err)
+	}
+}
+
+func TestTimeFiltersAcrossMidnight(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", midnightSample, nil)
+
+	tests := []struct {
+		query url.Values
+		ids   []int
+	}{
+		{url.Values{"since": {"00:00"}}, []int{2, 3}},
+		{url.Values{"since": {"23:50"}, "until": {"00:30"}}, []int{1, 2}},
+		{url.Values{"until": {"start+30m"}}, []int{0, 1}},
+		{url.Values{"since": {"-20m"}}, []int{2, 3}},
+		{url.Values{"since": {"21:00"}, "tz": {"UTC"}}, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		code, page := fetchPage(t, server, tt.query)
+		if code != http.StatusOK || !reflect.DeepEqual(page.IDs, tt.ids) {
+			t.Errorf("%s: код %d, записи %v, ожидались %v", tt.query.Encode(), code, page.IDs, tt.ids)
+		}
+	}
+}
+
+// TestTimeFiltersUnencodedPlus - "+", набранный в строке запроса без
+// экранирования, приходит пробелом и читается как "+"
+func TestTimeFiltersUnencodedPlus(t *testing.T) {
+	_, server := testServer(t)
+	serveJSON(t, server, "POST", "/api/logs", midnightSample, nil)
+
+	tests := []struct {
+		query string
+		ids   []int
+	}{
+		{"until=start+30m", []int{0, 1}},
+		{"since=+10m", []int{1, 2, 3}},
+		{"since=2025-09-10T00:00:00+03:00", []int{2, 3}},
+		{"since=00:00&tz=+03:00", []int{2, 3}},
+		{"q=timestamp>=start+50m", []int{2, 3}},
+		{"q=timestamp>=+50m", []int{2, 3}},
+		{"q=timestamp<2025-09-09T23:56:00+03:00", []int{0, 1}},
+		{"q=timestamp<end+AND+message:start", []int{0}},
+	}
+	for _, tt := range tests {
+		var response struct {
+			Logs []TerraformLog `json:"logs"`
+		}
+		code := serveJSON(t, server, "GET", "/api/logs?"+tt.query, "", &response)
+		var ids []int
+		for _, entry := range response.Logs {
+			ids = append(ids, entry.ID)
+		}
+		if code != http.StatusOK || !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: код %d, записи %v, ожидались %v", tt.query, code, ids, tt.ids)
+		}
+	}
+}
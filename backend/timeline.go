@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OperationSpan - интервал выполнения одной операции
+type OperationSpan struct {
+	Kind           string // resource, rpc, http
+	Resource       string
+	Operation      string
+	Start          time.Time
+	End            time.Time
+	DurationMs     int64
+	Outcome        string // success, error, incomplete
+	Source         string // hook, vertex, tf_req_id или tf_http_trans_id
+	OnCriticalPath bool
+}
+
+// Timeline - Gantt-данные и критический путь для всего набора логов
+type Timeline struct {
+	Spans        []OperationSpan
+	CriticalPath []int // индексы в Spans в порядке выполнения
+	Start        time.Time
+	End          time.Time
+	WallTimeMs   int64
+}
+
+// openSpan - начатая, но еще не завершенная операция
+type openSpan struct {
+	resource  string
+	operation string
+	start     time.Time
+}
+
+// buildTimeline - восстановление интервалов операций и критического пути
+func buildTimeline(logs []TerraformLog) Timeline {
+	spans := collectHookSpans(logs)
+	if len(spans) == 0 {
+		spans = collectVertexSpans(logs)
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+
+	timeline := Timeline{Spans: spans}
+	if len(spans) == 0 {
+		return timeline
+	}
+
+	timeline.Start = spans[0].Start
+	for _, span := range spans {
+		if span.End.After(timeline.End) {
+			timeline.End = span.End
+		}
+	}
+	timeline.WallTimeMs = timeline.End.Sub(timeline.Start).Milliseconds()
+
+	timeline.CriticalPath = criticalPath(spans, collectDependencies(logs))
+	for _, idx := range timeline.CriticalPath {
+		timeline.Spans[idx].OnCriticalPath = true
+	}
+
+	return timeline
+}
+
+// collectHookSpans - интервалы из машиночитаемого вывода terraform apply -json
+// (события apply_start/apply_complete/apply_errored и refresh_*)
+func collectHookSpans(logs []TerraformLog) []OperationSpan {
+	var spans []OperationSpan
+	open := make(map[string]openSpan)
+
+	for _, log := range logs {
+		raw := rawJSON(&log)
+		if !strings.Contains(raw, `"hook"`) {
+			continue
+		}
+
+		var event struct {
+			Type string `json:"type"`
+			Hook struct {
+				Resource struct {
+					Addr string `json:"addr"`
+				} `json:"resource"`
+				Action string `json:"action"`
+			} `json:"hook"`
+		}
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			continue
+		}
+		addr := event.Hook.Resource.Addr
+		if addr == "" {
+			continue
+		}
+
+		phase, stage, ok := strings.Cut(event.Type, "_")
+		if !ok || (phase != "apply" && phase != "refresh") {
+			continue
+		}
+		key := phase + "|" + addr
+
+		switch stage {
+		case "start":
+			operation := event.Hook.Action
+			if phase == "refresh" || operation == "" {
+				operation = phase
+			}
+			open[key] = openSpan{resource: addr, operation: operation, start: log.Timestamp}
+		case "complete", "errored":
+			started, exists := open[key]
+			if !exists {
+				continue
+			}
+			delete(open, key)
+			outcome := "success"
+			if stage == "errored" {
+				outcome = "error"
+			}
+			spans = append(spans, newSpan(started, log.Timestamp, outcome, "hook"))
+		}
+	}
+
+	return appendIncomplete(spans, open, logs, "hook")
+}
+
+// collectVertexSpans - интервалы из трассировки обхода графа (TF_LOG=trace):
+// vertex "aws_instance.web": starting visit (*terraform.NodeApplyableResourceInstance)
+// vertex "aws_instance.web": visit complete
+func collectVertexSpans(logs []TerraformLog) []OperationSpan {
+	var spans []OperationSpan
+	open := make(map[string]openSpan)
+
+	for _, log := range logs {
+		name, rest, ok := parseVertexMessage(log.Message())
+		if !ok || strings.Contains(name, " (") {
+			// Узлы вида "aws_instance.web (expand)" - служебные, пропускаем
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(rest, "starting visit"):
+			nodeType := strings.Trim(strings.TrimPrefix(rest, "starting visit"), " ()*")
+			if !strings.Contains(nodeType, "Resource") {
+				continue
+			}
+			open[name] = openSpan{resource: name, operation: operationFromNodeType(nodeType), start: log.Timestamp}
+		case strings.HasPrefix(rest, "visit complete"):
+			started, exists := open[name]
+			if !exists {
+				continue
+			}
+			delete(open, name)
+			outcome := "success"
+			if strings.Contains(rest, "with errors") {
+				outcome = "error"
+			}
+			spans = append(spans, newSpan(started, log.Timestamp, outcome, "vertex"))
+		}
+	}
+
+	return appendIncomplete(spans, open, logs, "vertex")
+}
+
+// parseVertexMessage - разбор сообщения dag-обходчика на имя вершины и событие
+func parseVertexMessage(message string) (string, string, bool) {
+	idx := strings.Index(message, `vertex "`)
+	if idx < 0 {
+		return "", "", false
+	}
+	rest := message[idx+len(`vertex "`):]
+	end := strings.Index(rest, `":`)
+	if end < 0 {
+		return "", "", false
+	}
+	return rest[:end], strings.TrimSpace(rest[end+2:]), true
+}
+
+// operationFromNodeType - тип операции по типу узла графа Terraform
+func operationFromNodeType(nodeType string) string {
+	switch {
+	case strings.Contains(nodeType, "Destroy"):
+		return "destroy"
+	case strings.Contains(nodeType, "Import"):
+		return "import"
+	case strings.Contains(nodeType, "Refresh"):
+		return "refresh"
+	case strings.Contains(nodeType, "Apply"):
+		return "apply"
+	case strings.Contains(nodeType, "Plan"):
+		return "plan"
+	case strings.Contains(nodeType, "Validat"):
+		return "validate"
+	}
+	return "visit"
+}
+
+func newSpan(started openSpan, end time.Time, outcome, source string) OperationSpan {
+	return OperationSpan{
+		Kind:       "resource",
+		Resource:   started.resource,
+		Operation:  started.operation,
+		Start:      started.start,
+		End:        end,
+		DurationMs: end.Sub(started.start).Milliseconds(),
+		Outcome:    outcome,
+		Source:     source,
+	}
+}
+
+// appendIncomplete - незавершенные операции заканчиваются на последней записи лога
+func appendIncomplete(spans []OperationSpan, open map[string]openSpan, logs []TerraformLog, source string) []OperationSpan {
+	if len(open) == 0 {
+		return spans
+	}
+
+	var lastTimestamp time.Time
+	for _, log := range logs {
+		if log.Timestamp.After(lastTimestamp) {
+			lastTimestamp = log.Timestamp
+		}
+	}
+
+	keys := make([]string, 0, len(open))
+	for key := range open {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		spans = append(spans, newSpan(open[key], lastTimestamp, "incomplete", source))
+	}
+	return spans
+}
+
+// collectDependencies - зависимости ресурсов из сообщений ReferenceTransformer:
+// ReferenceTransformer: "aws_instance.b" references: [aws_instance.a (expand) var.name]
+func collectDependencies(logs []TerraformLog) map[string][]string {
+	deps := make(map[string][]string)
+
+	for _, log := range logs {
+		idx := strings.Index(log.Message(), `ReferenceTransformer: "`)
+		if idx < 0 {
+			continue
+		}
+		rest := log.Message()[idx+len(`ReferenceTransformer: "`):]
+		end := strings.Index(rest, `" references: [`)
+		if end < 0 {
+			continue
+		}
+		vertex := resourceBase(rest[:end])
+		list := strings.TrimSuffix(rest[end+len(`" references: [`):], "]")
+
+		for _, ref := range strings.Fields(list) {
+			if strings.HasPrefix(ref, "(") {
+				continue
+			}
+			ref = resourceBase(ref)
+			if ref != vertex {
+				deps[vertex] = append(deps[vertex], ref)
+			}
+		}
+	}
+
+	return deps
+}
+
+// resourceBase - адрес ресурса без ключей экземпляров и служебных суффиксов
+func resourceBase(addr string) string {
+	if idx := strings.Index(addr, " ("); idx >= 0 {
+		addr = addr[:idx]
+	}
+
+	var b strings.Builder
+	depth := 0
+	for _, r := range addr {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// criticalPath - цепочка операций, определившая общее время выполнения.
+// Идем назад от операции, закончившейся последней: предшественником считается
+// зависимость (если она известна), иначе любая операция, завершившаяся позже
+// всех остальных до начала текущей.
+func criticalPath(spans []OperationSpan, deps map[string][]string) []int {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	current := 0
+	for i, span := range spans {
+		if span.End.After(spans[current].End) {
+			current = i
+		}
+	}
+
+	visited := map[int]bool{current: true}
+	path := []int{current}
+
+	for {
+		allowed := make(map[string]bool)
+		for _, dep := range deps[resourceBase(spans[current].Resource)] {
+			allowed[dep] = true
+		}
+
+		best := -1
+		for i, span := range spans {
+			if visited[i] || span.End.After(spans[current].Start) {
+				continue
+			}
+			if len(allowed) > 0 && !allowed[resourceBase(span.Resource)] {
+				continue
+			}
+			if best < 0 || span.End.After(spans[best].End) {
+				best = i
+			}
+		}
+
+		// Зависимости не попали в лог - откатываемся к эвристике по времени
+		if best < 0 && len(allowed) > 0 {
+			for i, span := range spans {
+				if visited[i] || span.End.After(spans[current].Start) {
+					continue
+				}
+				if best < 0 || span.End.After(spans[best].End) {
+					best = i
+				}
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+		visited[best] = true
+		path = append(path, best)
+		current = best
+	}
+
+	// Разворачиваем: от первой операции к последней
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Обработчик API для Gantt-данных и критического пути
+func handleAPITimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var logs []TerraformLog
+	store, ok := requestStore(w, r)
+	if !ok {
+		return
+	}
+	snapshot := store.Snapshot()
+	if snapshot != nil {
+		logs = snapshot.Logs
+	}
+	ctx, err := newTimeContext(logs, r.URL.Query().Get("tz"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	timeline := buildTimeline(logs)
+	ctx.renderSpans(timeline.Spans)
+	timeline.Start = ctx.render(timeline.Start)
+	timeline.End = ctx.render(timeline.End)
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeTimelineCSV(w, timeline)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if snapshot == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "no_data",
+			"message": "Нет данных логов",
+			"spans":   []interface{}{},
+		})
+		return
+	}
+
+	criticalSpans := make([]OperationSpan, 0, len(timeline.CriticalPath))
+	for _, idx := range timeline.CriticalPath {
+		criticalSpans = append(criticalSpans, timeline.Spans[idx])
+	}
+
+	spans := timeline.Spans
+	if spans == nil {
+		spans = []OperationSpan{}
+	}
+
+	response := map[string]interface{}{
+		"status":        "success",
+		"spans":         spans,
+		"critical_path": criticalSpans,
+		"start":         timeline.Start,
+		"end":           timeline.End,
+		"wall_time_ms":  timeline.WallTimeMs,
+		"count":         len(spans),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeTimelineCSV - выгрузка Gantt-данных в CSV
+func writeTimelineCSV(w http.ResponseWriter, timeline Timeline) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="timeline.csv"`)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"resource", "operation", "start", "end", "duration_ms", "outcome", "critical"})
+	for _, span := range timeline.Spans {
+		writer.Write([]string{
+			span.Resource,
+			span.Operation,
+			span.Start.Format(time.RFC3339Nano),
+			span.End.Format(time.RFC3339Nano),
+			strconv.FormatInt(span.DurationMs, 10),
+			span.Outcome,
+			fmt.Sprint(span.OnCriticalPath),
+		})
+	}
+	writer.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// timelineSample - apply -json: b идет параллельно a, c зависит от a и падает
+const timelineSample = `{"@level":"info","@message":"aws_instance.a: Creating...","@timestamp":"2025-09-09T10:00:00Z","type":"apply_start","hook":{"resource":{"addr":"aws_instance.a"},"action":"create"}}
+{"@level":"info","@message":"aws_instance.b: Creating...","@timestamp":"2025-09-09T10:00:00.5Z","type":"apply_start","hook":{"resource":{"addr":"aws_instance.b"},"action":"create"}}
+{"@level":"info","@message":"aws_instance.b: Creation complete","@timestamp":"2025-09-09T10:00:01Z","type":"apply_complete","hook":{"resource":{"addr":"aws_instance.b"},"action":"create"}}
+{"@level":"trace","@message":"ReferenceTransformer: \"aws_instance.c\" references: [aws_instance.a]","@timestamp":"2025-09-09T10:00:01Z"}
+{"@level":"info","@message":"aws_instance.a: Creation complete","@timestamp":"2025-09-09T10:00:02Z","type":"apply_complete","hook":{"resource":{"addr":"aws_instance.a"},"action":"create"}}
+{"@level":"info","@message":"aws_instance.c: Creating...","@timestamp":"2025-09-09T10:00:02Z","type":"apply_start","hook":{"resource":{"addr":"aws_instance.c"},"action":"create"}}
+{"@level":"error","@message":"aws_instance.c: error","@timestamp":"2025-09-09T10:00:05Z","type":"apply_errored","hook":{"resource":{"addr":"aws_instance.c"},"action":"create"}}
+`
+
+func TestTimelineAPI(t *testing.T) {
+	_, server := testServer(t)
+
+	var empty struct {
+		Status string `json:"status"`
+	}
+	if serveJSON(t, server, "GET", "/api/timeline", "", &empty); empty.Status != "no_data" {
+		t.Errorf("пустая сессия: %+v", empty)
+	}
+
+	serveJSON(t, server, "POST", "/api/logs", timelineSample, nil)
+	var timeline struct {
+		Spans        []OperationSpan `json:"spans"`
+		CriticalPath []OperationSpan `json:"critical_path"`
+		WallTimeMs   int64           `json:"wall_time_ms"`
+	}
+	if code := serveJSON(t, server, "GET", "/api/timeline", "", &timeline); code != http.StatusOK {
+		t.Fatalf("код %d", code)
+	}
+	if len(timeline.Spans) != 3 || timeline.WallTimeMs != 5000 {
+		t.Fatalf("спанов %d, время %d мс: %+v", len(timeline.Spans), timeline.WallTimeMs, timeline.Spans)
+	}
+	var path []string
+	for _, span := range timeline.CriticalPath {
+		path = append(path, span.Resource+":"+span.Outcome)
+	}
+	if strings.Join(path, " ") != "aws_instance.a:success aws_instance.c:error" {
+		t.Errorf("критический путь %v", path)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/timeline?format=csv", nil))
+	records, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil || len(records) != 4 || records[0][0] != "resource" {
+		t.Errorf("CSV: %v, %v", records, err)
+	}
+
+	if code := serveJSON(t, server, "GET", "/api/timeline?tz=Mars/Olympus", "", nil); code != http.StatusBadRequest {
+		t.Errorf("неизвестная зона: код %d", code)
+	}
+	if code := serveJSON(t, server, "POST", "/api/timeline", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: код %d", code)
+	}
+}
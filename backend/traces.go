@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceSpan - спан трассировки OpenTelemetry, например от Terraform
+// с OTEL_TRACES_EXPORTER=otlp
+type TraceSpan struct {
+	TraceID       string // hex
+	SpanID        string // hex
+	ParentSpanID  string `json:",omitempty"`
+	Name          string
+	Kind          string // internal, server, client, producer, consumer
+	Scope         string `json:",omitempty"`
+	Start         time.Time
+	End           time.Time
+	DurationMs    float64 // дробная часть - доли миллисекунды
+	Status        string  // unset, ok, error
+	StatusMessage string  `json:",omitempty"`
+	Attributes    map[string]interface{}
+	Resource      map[string]interface{}
+	Events        []TraceSpanEvent `json:",omitempty"`
+}
+
+// TraceSpanEvent - событие внутри спана (например, исключение)
+type TraceSpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{} `json:",omitempty"`
+}
+
+// TraceStore - потокобезопасные спаны одной сессии по времени начала;
+// изменения сохраняются через save до того, как станут видны читателям
+type TraceStore struct {
+	mu    sync.RWMutex
+	spans []TraceSpan
+	save  func([]TraceSpan) error
+}
+
+func newTraceStore(spans []TraceSpan, save func([]TraceSpan) error) *TraceStore {
+	store := &TraceStore{save: save}
+	store.spans = sortSpans(append([]TraceSpan(nil), spans...))
+	return store
+}
+
+func sortSpans(spans []TraceSpan) []TraceSpan {
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+	return spans
+}
+
+// List - копия спанов по времени начала
+func (s *TraceStore) List() []TraceSpan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]TraceSpan(nil), s.spans...)
+}
+
+// Add - добавление спанов; спан с теми же TraceID и SpanID (повторная
+// отправка экспортером) заменяется. Возвращает общее число спанов.
+func (s *TraceStore) Add(spans []TraceSpan) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make(map[string]int, len(s.spans))
+	for i, span := range s.spans {
+		positions[span.TraceID+"/"+span.SpanID] = i
+	}
+	updated := append([]TraceSpan(nil), s.spans...)
+	for _, span := range spans {
+		key := span.TraceID + "/" + span.SpanID
+		if i, exists := positions[key]; exists {
+			updated[i] = span
+			continue
+		}
+		positions[key] = len(updated)
+		updated = append(updated, span)
+	}
+	updated = sortSpans(updated)
+
+	if err := s.save(updated); err != nil {
+		return len(s.spans), err
+	}
+	s.spans = updated
+	return len(updated), nil
+}
+
+// Replace - замена всех спанов, например из пакета сессии
+func (s *TraceStore) Replace(spans []TraceSpan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(spans) == 0 && len(s.spans) == 0 {
+		return nil
+	}
+	sorted := sortSpans(append([]TraceSpan(nil), spans...))
+	if err := s.save(sorted); err != nil {
+		return err
+	}
+	s.spans = sorted
+	return nil
+}
+
+// spanLinkSlack - допуск при сравнении времени записи с границами спана:
+// время в логах Terraform записывается с точностью до микросекунд
+const spanLinkSlack = time.Millisecond
+
+// spanAttributeAliases - атрибуты спана и соответствующие им поля записей
+// Terraform: gRPC вызовы провайдеров записываются в логи как tf_rpc
+var spanAttributeAliases = map[string][]string{
+	"rpc.method": {"tf_rpc"},
+}
+
+// SpanLink - спан, с которым связана запись. Reason: span_id (в записи есть
+// ID спана), attributes (совпадают атрибуты, см. Shared) или time (запись
+// попадает во время спана, выбран самый короткий из вложенных).
+type SpanLink struct {
+	EntryID int
+	TraceID string
+	SpanID  string
+	Reason  string
+	Shared  []string `json:",omitempty"`
+}
+
+// linkSpans - связь записей со спанами по ID записи. Кандидаты - спаны, во время
+// которых сделана запись; среди них выбирается спан из span_id записи, затем спан
+// с наибольшим числом общих атрибутов, затем самый короткий (самый вложенный).
+// Записи с trace_id другой трассировки с ее спанами не связываются.
+func linkSpans(logs []TerraformLog, spans []TraceSpan) map[int]SpanLink {
+	links := make(map[int]SpanLink)
+	if len(spans) == 0 {
+		return links
+	}
+
+	order := make([]int, 0, len(logs))
+	for i := range logs {
+		if !logs[i].Timestamp.IsZero() {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return logs[order[a]].Timestamp.Before(logs[order[b]].Timestamp) })
+
+	// Спаны отсортированы по началу: проход по записям во времени держит
+	// только спаны, открытые в момент текущей записи
+	var active []int
+	next := 0
+	for _, i := range order {
+		at := logs[i].Timestamp
+		for next < len(spans) && !spans[next].Start.After(at.Add(spanLinkSlack)) {
+			active = append(active, next)
+			next++
+		}
+		open := active[:0]
+		for _, s := range active {
+			if !spans[s].End.Add(spanLinkSlack).Before(at) {
+				open = append(open, s)
+			}
+		}
+		active = open
+		if len(active) == 0 {
+			continue
+		}
+
+		if link, ok := bestSpanLink(logAttributes(logs[i]), spans, active); ok {
+			link.EntryID = logs[i].ID
+			links[logs[i].ID] = link
+		}
+	}
+	return links
+}
+
+// bestSpanLink - спан для записи с атрибутами attrs среди candidates
+func bestSpanLink(attrs map[string]interface{}, spans []TraceSpan, candidates []int) (SpanLink, bool) {
+	spanID, _ := attrs["span_id"].(string)
+	traceID, _ := attrs["trace_id"].(string)
+
+	best, bestScore := -1, -1
+	var bestShared []string
+	for _, s := range candidates {
+		span := &spans[s]
+		if traceID != "" && !strings.EqualFold(traceID, span.TraceID) {
+			continue
+		}
+		if spanID != "" && strings.EqualFold(spanID, span.SpanID) {
+			return SpanLink{TraceID: span.TraceID, SpanID: span.SpanID, Reason: "span_id"}, true
+		}
+		shared := sharedSpanAttributes(attrs, span)
+		score := len(shared)
+		if score > bestScore || (score == bestScore && span.End.Sub(span.Start) < spans[best].End.Sub(spans[best].Start)) {
+			best, bestScore, bestShared = s, score, shared
+		}
+	}
+	if best < 0 {
+		return SpanLink{}, false
+	}
+	link := SpanLink{TraceID: spans[best].TraceID, SpanID: spans[best].SpanID, Reason: "time"}
+	if len(bestShared) > 0 {
+		link.Reason, link.Shared = "attributes", bestShared
+	}
+	return link, true
+}
+
+// sharedSpanAttributes - атрибуты спана, значения которых совпадают с полями записи
+func sharedSpanAttributes(attrs map[string]interface{}, span *TraceSpan) []string {
+	var shared []string
+	for name, value := range span.Attributes {
+		expected := fmt.Sprint(value)
+		if expected == "" {
+			continue
+		}
+		for _, field := range append([]string{name}, spanAttributeAliases[name]...) {
+			if actual, exists := attrs[field]; exists && fmt.Sprint(actual) == expected {
+				shared = append(shared, name)
+				break
+			}
+		}
+	}
+	sort.Strings(shared)
+	return shared
+}
+
+// findSpan - индекс спана по SpanID (и TraceID, если задан) или -1
+func findSpan(spans []TraceSpan, traceID, spanID string) int {
+	for i := range spans {
+		if strings.EqualFold(spans[i].SpanID, spanID) && (traceID == "" || strings.EqualFold(spans[i].TraceID, traceID)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// spanParents - родители спана от ближайшего к корню
+func spanParents(spans []TraceSpan, span TraceSpan) []TraceSpan {
+	var parents []TraceSpan
+	seen := map[string]bool{span.SpanID: true}
+	for span.ParentSpanID != "" && !seen[span.ParentSpanID] {
+		i := findSpan(spans, span.TraceID, span.ParentSpanID)
+		if i < 0 {
+			break
+		}
+		span = spans[i]
+		seen[span.SpanID] = true
+		parents = append(parents, span)
+	}
+	return parents
+}
+
+// spanDescendants - SpanID спана и всех вложенных в него
+func spanDescendants(spans []TraceSpan, root TraceSpan) map[string]bool {
+	children := make(map[string][]string)
+	for _, span := range spans {
+		if span.TraceID == root.TraceID && span.ParentSpanID != "" {
+			children[span.ParentSpanID] = append(children[span.ParentSpanID], span.SpanID)
+		}
+	}
+	result := map[string]bool{root.SpanID: true}
+	queue := []string{root.SpanID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range children[id] {
+			if !result[child] {
+				result[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return result
+}
+
+// TraceSpanSummary - спан в списке с числом связанных с ним записей
+type TraceSpanSummary struct {
+	TraceSpan
+	LogCount int
+}
+
+// Обработчик API для спанов сессии: GET /api/traces?trace=&status=error&min_duration=500ms&sort=duration&limit=
+func handleAPITraces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+
+	var minDuration time.Duration
+	if value := query.Get("min_duration"); value != "" {
+		var err error
+		if minDuration, err = time.ParseDuration(value); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "min_duration: "+err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		limit, _ = strconv.Atoi(value)
+	}
+
+	spans := session.Traces.List()
+	counts := make(map[string]int)
+	if snapshot := session.Store.Snapshot(); snapshot != nil {
+		for _, link := range linkSpans(snapshot.Logs, spans) {
+			counts[link.TraceID+"/"+link.SpanID]++
+		}
+	}
+
+	traces := make(map[string]bool)
+	result := []TraceSpanSummary{}
+	for _, span := range spans {
+		traces[span.TraceID] = true
+		if trace := query.Get("trace"); trace != "" && !strings.EqualFold(trace, span.TraceID) {
+			continue
+		}
+		if status := query.Get("status"); status != "" && status != span.Status {
+			continue
+		}
+		if span.End.Sub(span.Start) < minDuration {
+			continue
+		}
+		result = append(result, TraceSpanSummary{TraceSpan: span, LogCount: counts[span.TraceID+"/"+span.SpanID]})
+	}
+	if query.Get("sort") == "duration" {
+		sort.SliceStable(result, func(i, j int) bool { return result[i].DurationMs > result[j].DurationMs })
+	}
+	total := len(result)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"spans":  result,
+		"count":  len(result),
+		"total":  total,
+		"traces": len(traces),
+	})
+}
+
+// Обработчик API для одного спана: GET /api/traces/{span}?trace= - спан,
+// его родители и вложенные спаны, записи внутри него. По умолчанию это записи,
+// связанные со спаном или вложенными в него (см. linkSpans); с match=time - все
+// записи за время спана. Фильтры и fields - как у /api/logs.
+func handleAPITraceSpan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	match := query.Get("match")
+	if match == "" {
+		match = "linked"
+	}
+	if match != "linked" && match != "time" {
+		http.Error(w, `{"error": "match: ожидается linked или time"}`, http.StatusBadRequest)
+		return
+	}
+
+	spans := session.Traces.List()
+	i := findSpan(spans, query.Get("trace"), r.PathValue("span"))
+	if i < 0 {
+		http.Error(w, `{"error": "Спан не найден"}`, http.StatusNotFound)
+		return
+	}
+	span := spans[i]
+
+	var logs []TerraformLog
+	if snapshot := session.Store.Snapshot(); snapshot != nil {
+		logs = snapshot.Logs
+	}
+	filter, err := parseLogFilter(query, logs)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	filter.useAnnotations(session.Annotations)
+
+	inside := spanDescendants(spans, span)
+	var links map[int]SpanLink
+	if match == "linked" {
+		links = linkSpans(logs, spans)
+	}
+	var matched []TerraformLog
+	for j := range logs {
+		if match == "linked" {
+			link, linked := links[logs[j].ID]
+			if !linked || link.TraceID != span.TraceID || !inside[link.SpanID] {
+				continue
+			}
+		} else if logs[j].Timestamp.Before(span.Start.Add(-spanLinkSlack)) || logs[j].Timestamp.After(span.End.Add(spanLinkSlack)) {
+			continue
+		}
+		if !filter.Match(&logs[j]) {
+			continue
+		}
+		matched = append(matched, logs[j])
+		if filter.Limit > 0 && len(matched) >= filter.Limit {
+			break
+		}
+	}
+	filter.Time.renderLogs(matched)
+
+	children := []TraceSpan{}
+	for _, other := range spans {
+		if other.TraceID == span.TraceID && other.ParentSpanID == span.SpanID {
+			children = append(children, other)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"span":     span,
+		"parents":  spanParents(spans, span),
+		"children": children,
+		"match":    match,
+		"logs":     projectLogs(matched, query.Get("fields")),
+		"count":    len(matched),
+	})
+}
+
+// Обработчик API для перехода от записи к спану: GET /api/logs/{id}/span -
+// спан, с которым связана запись, причина связи и родители спана
+func handleAPILogSpan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := requestSession(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, `{"error": "Некорректный ID записи"}`, http.StatusBadRequest)
+		return
+	}
+	var entry *TerraformLog
+	if snapshot := session.Store.Snapshot(); snapshot != nil {
+		entry = findLogByID(snapshot.Logs, id)
+	}
+	if entry == nil {
+		http.Error(w, `{"error": "Запись не найдена"}`, http.StatusNotFound)
+		return
+	}
+
+	spans := session.Traces.List()
+	link, linked := linkSpans([]TerraformLog{*entry}, spans)[id]
+	if !linked {
+		http.Error(w, `{"error": "Запись не попадает ни в один спан"}`, http.StatusNotFound)
+		return
+	}
+	span := spans[findSpan(spans, link.TraceID, link.SpanID)]
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"link":    link,
+		"span":    span,
+		"parents": spanParents(spans, span),
+	})
+}
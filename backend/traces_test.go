@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// otlpTracesPayload - спаны Terraform: apply, вызов провайдера и HTTP запрос внутри него
+const otlpTracesPayload = `{"resourceSpans":[{"resource":{"attributes":[
+  {"key":"service.name","value":{"stringValue":"terraform"}},
+  {"key":"session","value":{"stringValue":"ci-apply"}}]},
+ "scopeSpans":[{"scope":{"name":"terraform"},"spans":[
+  {"traceId":"5B8EFFF798038103D269B633813FC60C","spanId":"0000000000000001","name":"apply",
+   "startTimeUnixNano":"1704103200000000000","endTimeUnixNano":"1704103210000000000"},
+  {"traceId":"5B8EFFF798038103D269B633813FC60C","spanId":"0000000000000002","parentSpanId":"0000000000000001",
+   "name":"ApplyResourceChange","kind":3,"startTimeUnixNano":"1704103201000000000","endTimeUnixNano":"1704103205000000000",
+   "attributes":[{"key":"rpc.method","value":{"stringValue":"ApplyResourceChange"}}],"status":{"code":2,"message":"boom"}},
+  {"traceId":"5B8EFFF798038103D269B633813FC60C","spanId":"0000000000000003","parentSpanId":"0000000000000002",
+   "name":"HTTP POST","startTimeUnixNano":"1704103202000000000","endTimeUnixNano":"1704103203000000000"}]}]}]}`
+
+func TestOTLPTracesLinkSpans(t *testing.T) {
+	registry, err := NewSessionRegistry(memoryBackend{}, storageLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, err := decodeOTLPTracesJSON([]byte(otlpTracesPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, bySession := otlpSpanSessions(spans)
+	if len(order) != 1 || order[0] != "ci-apply" {
+		t.Fatalf("сессии спанов: %v", order)
+	}
+	session, _ := registry.FindOrCreate("ci-apply", "otlp")
+	for i := 0; i < 2; i++ {
+		if total, err := session.Traces.Add(bySession["ci-apply"]); err != nil || total != 3 {
+			t.Fatalf("повторный прием: спанов %d, %v", total, err)
+		}
+	}
+	spans = session.Traces.List()
+	if span := spans[1]; span.TraceID != "5b8efff798038103d269b633813fc60c" || span.Status != "error" || span.DurationMs != 4000 || span.Resource["session"] != nil || span.Resource["service.name"] != "terraform" {
+		t.Errorf("спан: %+v", span)
+	}
+
+	lines := []string{
+		`{"@level":"debug","@message":"provider call","@timestamp":"2024-01-01T10:00:02.500000Z","tf_rpc":"ApplyResourceChange"}`,
+		`{"@level":"debug","@message":"http request","@timestamp":"2024-01-01T10:00:02.500000Z"}`,
+		`{"@level":"info","@message":"apply done","@timestamp":"2024-01-01T10:00:06.000000Z","span_id":"0000000000000001"}`,
+		`{"@level":"info","@message":"other trace","@timestamp":"2024-01-01T10:00:06.000000Z","trace_id":"00000000000000000000000000000001"}`,
+		`{"@level":"info","@message":"after apply","@timestamp":"2024-01-01T10:00:11.000000Z"}`,
+	}
+	if _, err := session.Store.Append(parseLines([]byte(strings.Join(lines, "\n")), 0)); err != nil {
+		t.Fatal(err)
+	}
+	links := linkSpans(session.Store.Snapshot().Logs, spans)
+	want := map[int]string{0: "0000000000000002/attributes", 1: "0000000000000003/time", 2: "0000000000000001/span_id"}
+	if len(links) != len(want) {
+		t.Errorf("связей %d, ожидалось %d: %+v", len(links), len(want), links)
+	}
+	for id, expected := range want {
+		if got := links[id].SpanID + "/" + links[id].Reason; got != expected {
+			t.Errorf("запись %d: %s, ожидалось %s", id, got, expected)
+		}
+	}
+
+	// Спаны по протоколу protobuf: ID в hex, родитель и имя
+	span := protoAppend(nil, 1, []byte{0xab, 0xcd})
+	span = protoAppend(span, 2, []byte{0x01})
+	span = protoAppend(span, 4, []byte{0x02})
+	span = protoAppend(span, 5, []byte("plan"))
+	request := protoAppend(nil, 1, protoAppend(nil, 2, protoAppend(nil, 2, span)))
+	if decoded, err := decodeOTLPTracesProto(request); err != nil || len(decoded) != 1 || decoded[0].TraceID != "abcd" || decoded[0].ParentSpanID != "02" || decoded[0].Name != "plan" {
+		t.Errorf("protobuf: %+v, %v", decoded, err)
+	}
+	if _, err := decodeOTLPTracesProto(protoAppend(nil, 1, protoAppend(nil, 2, protoAppend(nil, 2, protoAppend(nil, 5, []byte("x")))))); err == nil {
+		t.Error("спан без trace_id должен быть ошибкой")
+	}
+
+	// Очистка сессии удаляет и спаны
+	if err := session.Store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if spans := session.Traces.List(); len(spans) != 0 {
+		t.Errorf("после очистки осталось спанов: %d", len(spans))
+	}
+}
+
+func TestDecodeOTLPTracesJSONErrors(t *testing.T) {
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{`{"resourceSpans": {}}`, "некорректный JSON"},
+		{`{"resourceSpans": [{"scopeSpans": [{"spans": [{"name": "apply", "spanId": "01"}]}]}]}`, "без traceId"},
+		{`{"resourceSpans": [{"scopeSpans": [{"spans": [{"traceId": "ab", "spanId": "01", "startTimeUnixNano": "x"}]}]}]}`, "некорректное число"},
+		{`{"resourceSpans": [{"scopeSpans": [{"spans": [{"traceId": "ab", "spanId": "01", "events": [{"timeUnixNano": "-"}]}]}]}]}`, "некорректное число"},
+	}
+	for _, test := range tests {
+		if _, err := decodeOTLPTracesJSON([]byte(test.body)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: ошибка %v, ожидалось %q", test.body, err, test.err)
+		}
+	}
+}
+
+func TestLinkSpansEdgeCases(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	span := TraceSpan{TraceID: "t", SpanID: "s", Start: start, End: start.Add(time.Second)}
+	logs := []TerraformLog{
+		{ID: 0, Timestamp: start.Add(-time.Second)},                   // до спана
+		{ID: 1, Timestamp: start.Add(time.Second + time.Microsecond)}, // в пределах допуска
+		{ID: 2}, // без времени
+		{ID: 3, Timestamp: start.Add(2 * time.Second)}, // после спана
+	}
+	if links := linkSpans(logs, nil); len(links) != 0 {
+		t.Errorf("без спанов: %+v", links)
+	}
+	links := linkSpans(logs, []TraceSpan{span})
+	if len(links) != 1 || links[1].Reason != "time" {
+		t.Errorf("связи: %+v", links)
+	}
+}
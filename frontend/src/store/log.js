@@ -7,18 +7,26 @@ export const Logs = defineStore('logs', {
         filter_logs: [],
         uniqueModules: [],
         url: 'http://localhost:8080/api',
+        sessionId: 'default',
+        stream: null,
     }),
+    getters: {
+        // Эндпоинты данных текущей сессии
+        sessionUrl: (state) => `${state.url}/sessions/${state.sessionId}`,
+    },
     actions: {
         async uploadFile(file) {
             try {
-                this.clearLogs();
+                // Каждая загрузка - отдельная сессия, чужие данные не затираются
                 const formData = new FormData()
                 formData.append('file', file)
-                const response = await axios.post(`${this.url}/logs`, formData, {
+                const response = await axios.post(`${this.url}/sessions`, formData, {
+                    params: { name: file.name },
                     headers: {
                         'Content-Type': 'multipart/form-data'
                     }
                 })
+                this.sessionId = response.data.session.ID;
                 await this.getLogsData();
                 return response.data
             } catch (error) {
@@ -28,19 +36,56 @@ export const Logs = defineStore('logs', {
         },
         async getLogsData() {
             try {
-                const response = await axios.get(`${this.url}/logs`, {
+                const response = await axios.get(`${this.sessionUrl}/logs`, {
                     params: {
                         ...this.filter_logs,
                     }
                 });
                 this.logsData = response.data;
+                this.subscribe();
             } catch (error) {
                 console.error('Ошибка получения данных логов:', error);
             }
         },
+        // Новые записи с текущими фильтрами приходят через поток вместо повторной загрузки
+        subscribe() {
+            if (this.stream) {
+                this.stream.close();
+            }
+            const params = new URLSearchParams(
+                Object.entries(this.filter_logs).filter(([key, v]) => key !== 'limit' && v != null && v !== '')
+            );
+            this.stream = new EventSource(`${this.sessionUrl}/stream?${params}`);
+            this.stream.addEventListener('log', (event) => {
+                if (!this.logsData.logs) {
+                    return;
+                }
+                this.logsData.logs.push(JSON.parse(event.data));
+                this.logsData.count = this.logsData.logs.length;
+            });
+            this.stream.addEventListener('stats', (event) => {
+                this.logsData.total = JSON.parse(event.data).total;
+            });
+            // Содержимое сессии заменено: загружаем заново
+            this.stream.addEventListener('reset', () => this.getLogsData());
+        },
         async getModules() {
-            this.uniqueModules = this.logsData.logs.map(log => log.Module);
-            this.uniqueModules = [...new Set(this.uniqueModules)];
+            try {
+                // Фасет по всем совпадениям, а не по загруженной странице
+                const response = await axios.get(`${this.sessionUrl}/logs`, {
+                    params: {
+                        ...this.filter_logs,
+                        facets: 'module',
+                        facet_relax: 'true',
+                        fields: 'id',
+                        limit: 1,
+                    }
+                });
+                const buckets = (response.data.facets && response.data.facets.module) || [];
+                this.uniqueModules = buckets.map(bucket => bucket.Value);
+            } catch (error) {
+                console.error('Ошибка получения модулей:', error);
+            }
         },
         async setFiters(filters) {
             this.filter_logs = {
@@ -55,7 +100,7 @@ export const Logs = defineStore('logs', {
         },
         async clearLogs() {
             try {
-                const response = await axios.post(`${this.url}/clear`);
+                const response = await axios.post(`${this.sessionUrl}/clear`);
                 return response;
             } catch (error) {
                 console.error('Ошибка удаления:', error)